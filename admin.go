@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// handleAllowChatCommand adds a chat to the allowlist.
+// Without arguments the current chat is allowed. The dispatcher only lets owners in.
+func handleAllowChatCommand(c tele.Context, allowlist *chatAllowlist) error {
+	chatID, err := targetChatID(c)
+	if err != nil {
+		return c.Reply(localize(userLanguage(c), msgAllowChatUsage))
+	}
+
+	err = allowlist.Allow(context.Background(), chatID)
+	logJSON("info", "Chat allowed by owner", map[string]interface{}{
+		"user":      getUserInfo(c),
+		"target_id": chatID,
+		"persisted": err == nil,
+	})
+
+	language := userLanguage(c)
+	return c.Reply(allowlistChangeReply(language, localize(language, msgChatAllowed, chatID), err))
+}
+
+// handleDenyChatCommand removes a chat from the allowlist.
+// Without arguments the current chat is denied.
+func handleDenyChatCommand(c tele.Context, allowlist *chatAllowlist) error {
+	chatID, err := targetChatID(c)
+	if err != nil {
+		return c.Reply(localize(userLanguage(c), msgDenyChatUsage))
+	}
+
+	err = allowlist.Deny(context.Background(), chatID)
+	logJSON("info", "Chat denied by owner", map[string]interface{}{
+		"user":      getUserInfo(c),
+		"target_id": chatID,
+		"persisted": err == nil,
+	})
+
+	language := userLanguage(c)
+	return c.Reply(allowlistChangeReply(language, localize(language, msgChatDenied, chatID), err))
+}
+
+// handleListChatsCommand replies with the current allowlist
+func handleListChatsCommand(c tele.Context, allowlist *chatAllowlist) error {
+	ids := allowlist.List()
+	if len(ids) == 0 {
+		return c.Reply(localize(userLanguage(c), msgNoAllowedChats))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(localizeCount(userLanguage(c), msgAllowedChats, len(ids)))
+	for _, id := range ids {
+		sb.WriteString(fmt.Sprintf("\n• %d", id))
+	}
+	return c.Reply(sb.String())
+}
+
+// targetChatID returns the chat ID passed as the first argument, or the current chat
+func targetChatID(c tele.Context) (int64, error) {
+	args := c.Args()
+	if len(args) == 0 {
+		if c.Chat() == nil {
+			return 0, errors.New("no chat")
+		}
+		return c.Chat().ID, nil
+	}
+	return strconv.ParseInt(strings.TrimSpace(args[0]), 10, 64)
+}
+
+// allowlistChangeReply adds a persistence warning to the reply if the store failed
+func allowlistChangeReply(language string, message string, err error) string {
+	if err == nil {
+		return message
+	}
+	logJSON("warn", "Failed to persist allowlist change", map[string]interface{}{
+		"error": err.Error(),
+	})
+	return message + localize(language, msgNotPersisted)
+}
+
+// replyNotOwner logs and rejects an owner-only command
+func replyNotOwner(c tele.Context, command string) error {
+	logJSON("warn", "Owner command used by non-owner", map[string]interface{}{
+		"user":    getUserInfo(c),
+		"chat":    getChatInfo(c),
+		"command": command,
+	})
+	return c.Reply(localize(userLanguage(c), msgOwnerOnly))
+}
+
+// parseOwnerUserIDs parses comma-separated owner user IDs from environment variable
+func parseOwnerUserIDs(usersStr string) []int64 {
+	var userIDs []int64
+
+	for _, part := range strings.Split(usersStr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		userID, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			logJSON("warn", "Invalid owner user ID", map[string]interface{}{
+				"value": part,
+				"error": err.Error(),
+			})
+			continue
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs
+}
+
+// isOwner checks if the sender is one of the bot owners
+func isOwner(c tele.Context, ownerUserIDs []int64) bool {
+	sender := c.Sender()
+	if sender == nil {
+		return false
+	}
+
+	for _, ownerID := range ownerUserIDs {
+		if sender.ID == ownerID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// newAdminMockContext builds a context for an owner command sent by userID with the given args
+func newAdminMockContext(userID int64, args []string, reply *string) *MockContextWithReply {
+	return &MockContextWithReply{
+		MockContext: MockContext{
+			chat:    &tele.Chat{ID: -1001234567890, Type: tele.ChatSuperGroup},
+			sender:  &tele.User{ID: userID, Username: "owner"},
+			message: &tele.Message{ID: 1},
+			args:    args,
+		},
+		replyFunc: func(what interface{}, opts ...interface{}) error {
+			*reply = what.(string)
+			return nil
+		},
+	}
+}
+
+func TestParseOwnerUserIDs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []int64
+	}{
+		{"", nil},
+		{"123", []int64{123}},
+		{"123, 456 ,bad,", []int64{123, 456}},
+	}
+
+	for _, tt := range tests {
+		result := parseOwnerUserIDs(tt.input)
+		if len(result) != len(tt.expected) {
+			t.Errorf("parseOwnerUserIDs(%q) = %v, want %v", tt.input, result, tt.expected)
+			continue
+		}
+		for i := range result {
+			if result[i] != tt.expected[i] {
+				t.Errorf("parseOwnerUserIDs(%q)[%d] = %d, want %d", tt.input, i, result[i], tt.expected[i])
+			}
+		}
+	}
+}
+
+func TestIsOwner(t *testing.T) {
+	owners := []int64{111, 222}
+
+	if !isOwner(&MockContext{sender: &tele.User{ID: 222}}, owners) {
+		t.Error("isOwner() = false for an owner")
+	}
+	if isOwner(&MockContext{sender: &tele.User{ID: 333}}, owners) {
+		t.Error("isOwner() = true for a non-owner")
+	}
+	if isOwner(&MockContext{}, owners) {
+		t.Error("isOwner() = true for a nil sender")
+	}
+}
+
+func TestAdminCommandsRejectNonOwners(t *testing.T) {
+	silenceStdout(t)
+	allowlist := newChatAllowlist(nil)
+
+	// The handlers rely on the dispatcher's owner check
+	handlers := map[string]func(tele.Context, *chatAllowlist) error{
+		"allowchat": handleAllowChatCommand,
+		"denychat":  handleDenyChatCommand,
+		"listchats": handleListChatsCommand,
+	}
+
+	registry := newCommandRegistry([]int64{111})
+	for name, handler := range handlers {
+		cmd := botCommand{Name: name, Scopes: []commandScope{scopeGroup, scopePrivate}, OwnerOnly: true, Handler: func(c tele.Context, cmd botCommand) error {
+			return handler(c, allowlist)
+		}}
+		t.Run(name, func(t *testing.T) {
+			reply := ""
+			if err := registry.dispatch(cmd)(newAdminMockContext(999, []string{"-100"}, &reply)); err != nil {
+				t.Fatalf("handler returned error: %v", err)
+			}
+			if !strings.Contains(reply, "only available to the bot owners") {
+				t.Errorf("reply = %q, want owner-only refusal", reply)
+			}
+		})
+	}
+
+	if allowlist.Contains(-100) {
+		t.Error("non-owner was able to change the allowlist")
+	}
+}
+
+func TestAllowAndDenyChatCommands(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	allowlist := newChatAllowlist(nil)
+
+	reply := ""
+	handleAllowChatCommand(newAdminMockContext(111, []string{"-1009999999999"}, &reply), allowlist)
+	if !allowlist.Contains(-1009999999999) {
+		t.Error("/allowchat with an argument did not allow the chat")
+	}
+	if reply != "✅ Chat -1009999999999 is now allowed" {
+		t.Errorf("/allowchat reply = %q", reply)
+	}
+
+	handleAllowChatCommand(newAdminMockContext(111, nil, &reply), allowlist)
+	if !allowlist.Contains(-1001234567890) {
+		t.Error("/allowchat without arguments did not allow the current chat")
+	}
+
+	handleListChatsCommand(newAdminMockContext(111, nil, &reply), allowlist)
+	if !strings.Contains(reply, "-1009999999999") || !strings.Contains(reply, "-1001234567890") {
+		t.Errorf("/listchats reply = %q, want both chats", reply)
+	}
+
+	handleDenyChatCommand(newAdminMockContext(111, []string{"-1009999999999"}, &reply), allowlist)
+	if allowlist.Contains(-1009999999999) {
+		t.Error("/denychat did not deny the chat")
+	}
+	if reply != "🚫 Chat -1009999999999 is no longer allowed" {
+		t.Errorf("/denychat reply = %q", reply)
+	}
+}
+
+func TestAllowChatCommandInvalidArgument(t *testing.T) {
+	silenceStdout(t)
+	allowlist := newChatAllowlist(nil)
+
+	reply := ""
+	handleAllowChatCommand(newAdminMockContext(111, []string{"general"}, &reply), allowlist)
+
+	if reply != "Usage: /allowchat [chat_id]" {
+		t.Errorf("reply = %q, want usage", reply)
+	}
+}
+
+func TestAllowChatCommandWithoutStore(t *testing.T) {
+	originalClient := redisClient
+	defer func() { redisClient = originalClient }()
+	redisClient = nil
+	silenceStdout(t)
+
+	allowlist := newChatAllowlist(nil)
+	reply := ""
+	handleAllowChatCommand(newAdminMockContext(111, []string{"-100"}, &reply), allowlist)
+
+	if !allowlist.Contains(-100) {
+		t.Error("chat should be allowed in memory even without store")
+	}
+	if !strings.Contains(reply, "not persisted") {
+		t.Errorf("reply = %q, want persistence warning", reply)
+	}
+}
+
+func TestListChatsCommandEmpty(t *testing.T) {
+	silenceStdout(t)
+	reply := ""
+	handleListChatsCommand(newAdminMockContext(111, nil, &reply), newChatAllowlist(nil))
+
+	if reply != "No chats are allowed" {
+		t.Errorf("reply = %q, want %q", reply, "No chats are allowed")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// errStoreUnavailable is returned when a change could not be persisted because Redis is down
+var errStoreUnavailable = errors.New("store unavailable")
+
+// chatAllowlist is the live set of chats the bot works in. It starts from the
+// ALLOWED_CHAT_IDS seed and is merged with the changes persisted in Redis:
+// chats added by owners, minus chats denied by owners (even if seeded).
+// Changes made while Redis is down are kept as pending and written by Load.
+type chatAllowlist struct {
+	mu      sync.RWMutex
+	seed    map[int64]bool
+	added   map[int64]bool
+	removed map[int64]bool
+	pending map[int64]bool // chat ID -> allowed, for changes not yet persisted
+}
+
+// newChatAllowlist creates an allowlist seeded with the given chat IDs
+func newChatAllowlist(seed []int64) *chatAllowlist {
+	a := &chatAllowlist{
+		seed:    make(map[int64]bool),
+		added:   make(map[int64]bool),
+		removed: make(map[int64]bool),
+		pending: make(map[int64]bool),
+	}
+	for _, id := range seed {
+		a.seed[id] = true
+	}
+	return a
+}
+
+// Contains checks if the chat is currently allowed
+func (a *chatAllowlist) Contains(chatID int64) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.removed[chatID] {
+		return false
+	}
+	return a.seed[chatID] || a.added[chatID]
+}
+
+// List returns the allowed chat IDs in ascending order
+func (a *chatAllowlist) List() []int64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var ids []int64
+	for id := range a.seed {
+		if !a.removed[id] {
+			ids = append(ids, id)
+		}
+	}
+	for id := range a.added {
+		if !a.seed[id] && !a.removed[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Allow adds the chat to the allowlist and persists the change
+func (a *chatAllowlist) Allow(ctx context.Context, chatID int64) error {
+	return a.change(ctx, chatID, true)
+}
+
+// Deny removes the chat from the allowlist and persists the change
+func (a *chatAllowlist) Deny(ctx context.Context, chatID int64) error {
+	return a.change(ctx, chatID, false)
+}
+
+// change applies an owner decision to the live set and persists it. If Redis is
+// down or the write fails, the change stays pending until the next Load.
+func (a *chatAllowlist) change(ctx context.Context, chatID int64, allowed bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.apply(chatID, allowed)
+	a.pending[chatID] = allowed
+
+	rdb := activeRedis()
+	if rdb == nil {
+		return errStoreUnavailable
+	}
+	if err := persistAllowlistChanges(ctx, rdb, map[int64]bool{chatID: allowed}); err != nil {
+		return err
+	}
+	delete(a.pending, chatID)
+	return nil
+}
+
+// apply records a decision in the live set; the caller holds the lock
+func (a *chatAllowlist) apply(chatID int64, allowed bool) {
+	if allowed {
+		a.added[chatID] = true
+		delete(a.removed, chatID)
+	} else {
+		a.removed[chatID] = true
+		delete(a.added, chatID)
+	}
+}
+
+// Load writes the pending changes to Redis, then replaces the owner changes in
+// the allowlist with the ones persisted there
+func (a *chatAllowlist) Load(ctx context.Context, rdb redis.UniversalClient) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.pending) > 0 {
+		if err := persistAllowlistChanges(ctx, rdb, a.pending); err != nil {
+			return err
+		}
+		logJSON("info", "Persisted pending allowlist changes", map[string]interface{}{
+			"count": len(a.pending),
+		})
+		a.pending = make(map[int64]bool)
+	}
+
+	added, err := loadChatIDSet(ctx, rdb, redisKey("allowlist:added"))
+	if err != nil {
+		return err
+	}
+	removed, err := loadChatIDSet(ctx, rdb, redisKey("allowlist:removed"))
+	if err != nil {
+		return err
+	}
+
+	a.added = added
+	a.removed = make(map[int64]bool, len(removed))
+	for id := range removed {
+		if !added[id] {
+			a.removed[id] = true
+		}
+	}
+
+	return nil
+}
+
+// persistAllowlistChanges writes owner decisions (chat ID -> allowed) to Redis in one transaction
+func persistAllowlistChanges(ctx context.Context, rdb redis.UniversalClient, changes map[int64]bool) error {
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for chatID, allowed := range changes {
+			member := strconv.FormatInt(chatID, 10)
+			if allowed {
+				pipe.SAdd(ctx, redisKey("allowlist:added"), member)
+				pipe.SRem(ctx, redisKey("allowlist:removed"), member)
+			} else {
+				pipe.SRem(ctx, redisKey("allowlist:added"), member)
+				pipe.SAdd(ctx, redisKey("allowlist:removed"), member)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// loadChatIDSet reads a Redis set of chat IDs, skipping malformed members
+func loadChatIDSet(ctx context.Context, rdb redis.UniversalClient, key string) (map[int64]bool, error) {
+	members, err := rdb.SMembers(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int64]bool, len(members))
+	for _, member := range members {
+		id, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			logJSON("warn", "Invalid chat ID in stored allowlist", map[string]interface{}{
+				"key":   key,
+				"value": member,
+			})
+			continue
+		}
+		ids[id] = true
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestChatAllowlistSeed(t *testing.T) {
+	allowlist := newChatAllowlist([]int64{-100, 42})
+
+	if !allowlist.Contains(-100) {
+		t.Error("Contains(-100) = false, want true")
+	}
+	if !allowlist.Contains(42) {
+		t.Error("Contains(42) = false, want true")
+	}
+	if allowlist.Contains(7) {
+		t.Error("Contains(7) = true, want false")
+	}
+
+	list := allowlist.List()
+	if len(list) != 2 || list[0] != -100 || list[1] != 42 {
+		t.Errorf("List() = %v, want [-100 42]", list)
+	}
+}
+
+func TestChatAllowlistAllowDenyWithoutStore(t *testing.T) {
+	originalClient := redisClient
+	defer func() { redisClient = originalClient }()
+	redisClient = nil
+
+	allowlist := newChatAllowlist([]int64{-100})
+	ctx := context.Background()
+
+	if err := allowlist.Allow(ctx, -200); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("Allow() without store error = %v, want %v", err, errStoreUnavailable)
+	}
+	if !allowlist.Contains(-200) {
+		t.Error("Allow() should update the live set even without store")
+	}
+
+	if err := allowlist.Deny(ctx, -100); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("Deny() without store error = %v, want %v", err, errStoreUnavailable)
+	}
+	if allowlist.Contains(-100) {
+		t.Error("Deny() should remove seeded chats from the live set")
+	}
+
+	list := allowlist.List()
+	if len(list) != 1 || list[0] != -200 {
+		t.Errorf("List() = %v, want [-200]", list)
+	}
+}
+
+func TestChatAllowlistPersistence(t *testing.T) {
+	mr := useMiniredis(t)
+	ctx := context.Background()
+
+	allowlist := newChatAllowlist([]int64{-100, -300})
+	if err := allowlist.Allow(ctx, -200); err != nil {
+		t.Fatalf("Allow() returned error: %v", err)
+	}
+	if err := allowlist.Deny(ctx, -300); err != nil {
+		t.Fatalf("Deny() returned error: %v", err)
+	}
+
+	if ok, _ := mr.SIsMember("allowlist:added", "-200"); !ok {
+		t.Error("allowed chat was not stored in allowlist:added")
+	}
+	if ok, _ := mr.SIsMember("allowlist:removed", "-300"); !ok {
+		t.Error("denied chat was not stored in allowlist:removed")
+	}
+
+	// A fresh instance with the same env seed sees the stored changes
+	restored := newChatAllowlist([]int64{-100, -300})
+	if err := restored.Load(ctx, redisClient); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	tests := []struct {
+		chatID   int64
+		expected bool
+	}{
+		{-100, true},
+		{-200, true},
+		{-300, false},
+	}
+	for _, tt := range tests {
+		if result := restored.Contains(tt.chatID); result != tt.expected {
+			t.Errorf("restored Contains(%d) = %v, want %v", tt.chatID, result, tt.expected)
+		}
+	}
+}
+
+func TestChatAllowlistReallowDeniedChat(t *testing.T) {
+	mr := useMiniredis(t)
+	ctx := context.Background()
+
+	allowlist := newChatAllowlist([]int64{-100})
+	allowlist.Deny(ctx, -100)
+	allowlist.Allow(ctx, -100)
+
+	if !allowlist.Contains(-100) {
+		t.Error("Contains(-100) = false after re-allowing")
+	}
+	if ok, _ := mr.SIsMember("allowlist:removed", "-100"); ok {
+		t.Error("re-allowed chat is still stored in allowlist:removed")
+	}
+}
+
+func TestChatAllowlistPersistsChangesMadeWhileStoreDown(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	ctx := context.Background()
+	mr.SAdd("allowlist:removed", "-200")
+
+	allowlist := newChatAllowlist([]int64{-100})
+	if err := allowlist.Load(ctx, redisClient); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	redisUp.Store(false)
+	if err := allowlist.Allow(ctx, -200); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("Allow() while down error = %v, want %v", err, errStoreUnavailable)
+	}
+	if err := allowlist.Deny(ctx, -100); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("Deny() while down error = %v, want %v", err, errStoreUnavailable)
+	}
+
+	// Reconnecting writes the pending changes before reloading
+	redisUp.Store(true)
+	if err := allowlist.Load(ctx, redisClient); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if ok, _ := mr.SIsMember("allowlist:added", "-200"); !ok {
+		t.Error("chat allowed while down was not stored in allowlist:added")
+	}
+	if ok, _ := mr.SIsMember("allowlist:removed", "-200"); ok {
+		t.Error("chat allowed while down is still stored in allowlist:removed")
+	}
+	if ok, _ := mr.SIsMember("allowlist:removed", "-100"); !ok {
+		t.Error("chat denied while down was not stored in allowlist:removed")
+	}
+
+	// A restart keeps the changes
+	restored := newChatAllowlist([]int64{-100})
+	if err := restored.Load(ctx, redisClient); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if !restored.Contains(-200) || restored.Contains(-100) {
+		t.Errorf("restored List() = %v, want [-200]", restored.List())
+	}
+}
+
+func TestChatAllowlistLoadSkipsInvalidMembers(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+
+	mr.SAdd("allowlist:added", "-500", "not-a-chat")
+
+	allowlist := newChatAllowlist(nil)
+	if err := allowlist.Load(context.Background(), redisClient); err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	list := allowlist.List()
+	if len(list) != 1 || list[0] != -500 {
+		t.Errorf("List() = %v, want [-500]", list)
+	}
+}
+
+func TestChatAllowlistUsesKeyPrefix(t *testing.T) {
+	mr := useMiniredis(t)
+	original := redisKeyPrefix
+	defer func() { redisKeyPrefix = original }()
+	redisKeyPrefix = "brm-test:"
+
+	newChatAllowlist(nil).Allow(context.Background(), -100)
+
+	if ok, _ := mr.SIsMember("brm-test:allowlist:added", "-100"); !ok {
+		t.Error("allowlist key is not namespaced with REDIS_KEY_PREFIX")
+	}
+}
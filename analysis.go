@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	tele "gopkg.in/telebot.v3"
+)
+
+// analysisMode describes how a reply command turns the replied message into an answer
+type analysisMode struct {
+	Name      string                                                 // used in logs, cache keys and rate-limit entries
+	Duplicate messageKey                                             // reply when the message was already processed
+	Process   func(c tele.Context, text string) analysisResult       // answers about the replied text
+	Image     func(c tele.Context, image replyImage) analysisResult  // optional, answers about an attached image
+	Document  func(c tele.Context, doc replyDocument) analysisResult // optional, answers about an attached document
+	Audio     func(c tele.Context, audio replyAudio) analysisResult  // optional, answers about a voice message, audio or video note
+}
+
+// textAnalysis enables opinions about long plain-text messages in selected chats
+type textAnalysis struct {
+	chatIDs   []int64
+	minLength int // messages shorter than this still get a refusal
+}
+
+// Enabled checks if plain-text analysis is enabled for the chat
+func (t *textAnalysis) Enabled(chatID int64) bool {
+	if t == nil {
+		return false
+	}
+	for _, id := range t.chatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// opinionMode answers with an opinion in a randomly selected tone; in chats with
+// text analysis enabled, long messages without a link are analyzed too.
+// Successful opinions start a thread users can continue by replying to the answer.
+func opinionMode(texts *textAnalysis) analysisMode {
+	return analysisMode{
+		Name:      "opinion",
+		Duplicate: msgDuplicateOpinion,
+		Process: func(c tele.Context, text string) analysisResult {
+			tone := selectPromptType()
+			var result analysisResult
+			if texts.Enabled(c.Chat().ID) {
+				result = getOpinionWithText(requestContext(c), text, texts.minLength, tone, replyLanguage(c))
+			} else {
+				result = getOpinionInTone(requestContext(c), text, tone, replyLanguage(c))
+			}
+			if result.OK() {
+				url := extractURL(text)
+				source := text
+				if url != "" {
+					source = url
+				}
+				rememberConversation(c, tone, source, url, result.Answer)
+			}
+			return result
+		},
+		Image: func(c tele.Context, image replyImage) analysisResult {
+			tone := selectPromptType()
+			result := getImageOpinion(c, image, tone, replyLanguage(c))
+			if result.OK() {
+				rememberConversation(c, tone, mediaSource("an image", image.Caption), "", result.Answer)
+			}
+			return result
+		},
+		Document: func(c tele.Context, doc replyDocument) analysisResult {
+			tone := selectPromptType()
+			result := getDocumentOpinion(c, doc, tone, replyLanguage(c))
+			if result.OK() {
+				rememberConversation(c, tone, mediaSource(fmt.Sprintf("the document %q", doc.FileName), doc.Caption), "", result.Answer)
+			}
+			return result
+		},
+		Audio: func(c tele.Context, audio replyAudio) analysisResult {
+			tone := selectPromptType()
+			result := getAudioOpinion(c, audio, tone, replyLanguage(c))
+			if result.OK() {
+				rememberConversation(c, tone, mediaSource("a recording", ""), "", result.Answer)
+			}
+			return result
+		},
+	}
+}
+
+// mediaSource describes analyzed media in a thread, since the file itself is not stored
+func mediaSource(what string, caption string) string {
+	source := fmt.Sprintf("(The user shared %s, which is no longer available.)", what)
+	if caption != "" {
+		source += "\n\n" + caption
+	}
+	return source
+}
+
+// tldrMode answers with a neutral summary of the given length
+func tldrMode(length SummaryLength) analysisMode {
+	return analysisMode{
+		Name:      "tldr",
+		Duplicate: msgDuplicateSummary,
+		Process: func(c tele.Context, text string) analysisResult {
+			return getSummary(requestContext(c), text, length, replyLanguage(c))
+		},
+	}
+}
+
+// factcheckMode rates the claims of the linked content with cited sources
+var factcheckMode = analysisMode{
+	Name:      "factcheck",
+	Duplicate: msgDuplicateFactCheck,
+	Process: func(c tele.Context, text string) analysisResult {
+		return getFactCheck(requestContext(c), text, replyLanguage(c))
+	},
+}
+
+// handleTLDRCommand handles /tldr [short|medium|bullets], falling back to defaultLength
+func handleTLDRCommand(c tele.Context, allowlist *chatAllowlist, excludedUserIDs []int64, cost int, defaultLength SummaryLength) error {
+	length := defaultLength
+	if args := c.Args(); len(args) > 0 {
+		parsed, ok := parseSummaryLength(args[0])
+		if !ok {
+			return c.Reply(localize(userLanguage(c), msgTLDRUsage))
+		}
+		length = parsed
+	}
+
+	return handleAnalysisCommand(c, allowlist, excludedUserIDs, cost, tldrMode(length))
+}
+
+// handleAnalysisCommand runs the shared pipeline of reply commands: authorization,
+// duplicate detection, rate limiting, processing, caching and replying
+func handleAnalysisCommand(c tele.Context, allowlist *chatAllowlist, excludedUserIDs []int64, cost int, mode analysisMode) error {
+	requestCtx, span := startRequestSpan(c, "command."+mode.Name, attribute.String("command", mode.Name))
+	defer span.End()
+	// Redis writes finish even when a shutdown cancels the request
+	ctx := context.WithoutCancel(requestCtx)
+
+	// Check if in allowed group
+	if !isAllowedChat(c, allowlist) {
+		chatType := string(c.Chat().Type)
+		var message string
+
+		if chatType == "private" {
+			message = localize(userLanguage(c), msgNotAuthorizedPrivate, os.Getenv("GROUP_LINK"))
+		} else {
+			message = localize(userLanguage(c), msgNotAuthorizedGroup, os.Getenv("GROUP_LINK"))
+		}
+
+		logJSONContext(ctx, "warn", "Unauthorized chat access attempt", map[string]interface{}{
+			"user":      getUserInfo(c),
+			"chat":      getChatInfo(c),
+			"chat_type": chatType,
+		})
+		recordCommand(mode.Name, outcomeUnauthorized)
+
+		return c.Reply(message)
+	}
+
+	// Check if we've already processed this message
+	messageID := c.Message().ReplyTo.ID
+	userID := c.Sender().ID
+	rdb := activeRedis()
+	cacheKey := redisKey("%s:%d:%d", mode.Name, c.Chat().ID, messageID)
+
+	if rdb != nil {
+		marker, err := rdb.Get(ctx, cacheKey).Result()
+		if err == nil || errors.Is(err, redis.Nil) {
+			recordCacheLookup(mode.Name, err == nil)
+		}
+		if err == nil {
+			logJSONContext(ctx, "info", "Duplicate request detected", map[string]interface{}{
+				"user":       getUserInfo(c),
+				"chat":       getChatInfo(c),
+				"message_id": messageID,
+				"mode":       mode.Name,
+				"marker":     marker,
+			})
+			recordCommand(mode.Name, outcomeDuplicate)
+			// Refused messages get the refusal again, answered ones the duplicate notice
+			if key, ok := cachedRefusals[resultCategory(marker)]; ok {
+				return c.Reply(localize(userLanguage(c), key))
+			}
+			return c.Reply(localize(userLanguage(c), mode.Duplicate))
+		}
+	}
+
+	// Reply in the chat's fixed language or the language of the replied message,
+	// falling back to the sender's language
+	language := resolveReplyLanguage(c.Chat().ID, c.Message().ReplyTo)
+	detectTitle := language == ""
+	if language == "" {
+		language = userLanguage(c)
+	}
+	c.Set(replyLanguageKey, language)
+
+	// Past a spending cap only answers cached for the same link are served
+	linkURL := extractURL(c.Message().ReplyTo.Text)
+	if rdb != nil && applyBudget(ctx, rdb, c) == budgetExhausted {
+		if linkURL != "" {
+			if answer, err := rdb.Get(ctx, linkAnswerKey(mode.Name, linkURL)).Result(); err == nil {
+				recordCommand(mode.Name, outcomeCached)
+				span.SetAttributes(attribute.String("command.outcome", outcomeCached))
+				_, err := replyToOriginal(ctx, c, answer)
+				return err
+			}
+		}
+		recordCommand(mode.Name, outcomeOverBudget)
+		return c.Reply(localize(language, msgBudgetExhausted))
+	}
+
+	// Recordings have their own cost
+	audio, isAudio := findReplyAudio(c.Message().ReplyTo)
+	isAudio = isAudio && mode.Audio != nil
+	if isAudio {
+		cost = audioCost
+	}
+
+	// Rate limiting: duplicates were answered above, so only new messages from non-excluded users count
+	if rdb == nil && redisUnavailablePolicy == redisFailClosed && !isExcludedUser(userID, excludedUserIDs) {
+		logJSONContext(ctx, "warn", "Rate limiting unavailable, rejecting request", map[string]interface{}{
+			"user":   getUserInfo(c),
+			"chat":   getChatInfo(c),
+			"policy": redisUnavailablePolicy,
+		})
+		recordCommand(mode.Name, outcomeRateLimited)
+		return c.Reply(localize(language, msgRateLimitUnavailable))
+	}
+
+	var charge *rateLimitCharge
+	if rdb != nil && !isExcludedUser(userID, excludedUserIDs) {
+		member := fmt.Sprintf("%s:%d:%d", mode.Name, c.Chat().ID, messageID)
+		allowed, count := consumeRateLimit(ctx, rdb, userID, member, cost)
+		if !allowed {
+			logJSONContext(ctx, "warn", "Rate limit exceeded", map[string]interface{}{
+				"user":  getUserInfo(c),
+				"chat":  getChatInfo(c),
+				"count": count,
+				"cost":  cost,
+				"mode":  mode.Name,
+			})
+			recordCommand(mode.Name, outcomeRateLimited)
+			return c.Reply(localizeCount(language, msgRateLimited, dailyRequestLimit))
+		}
+		charge = &rateLimitCharge{UserID: userID, Member: member, Cost: cost}
+	}
+
+	// Bare links are answered in the language of the page title, fetched only once
+	// the request passed the budget and the rate limit
+	if detectTitle {
+		if title := titleLanguage(requestCtx, c.Message().ReplyTo); title != "" {
+			language = title
+			c.Set(replyLanguageKey, language)
+		}
+	}
+
+	var result analysisResult
+	textRequest := false
+	if image, ok := findReplyImage(c.Message().ReplyTo); ok && mode.Image != nil {
+		logJSONContext(ctx, "info", "Processing image request", map[string]interface{}{
+			"user":      getUserInfo(c),
+			"chat":      getChatInfo(c),
+			"file_size": image.File.FileSize,
+			"mime":      image.MIME,
+			"mode":      mode.Name,
+		})
+
+		result = mode.Image(c, image)
+	} else if doc, ok := findReplyDocument(c.Message().ReplyTo); ok && mode.Document != nil {
+		logJSONContext(ctx, "info", "Processing document request", map[string]interface{}{
+			"user":      getUserInfo(c),
+			"chat":      getChatInfo(c),
+			"file_name": doc.FileName,
+			"file_size": doc.File.FileSize,
+			"kind":      doc.Kind,
+			"mode":      mode.Name,
+		})
+
+		result = mode.Document(c, doc)
+	} else if isAudio {
+		logJSONContext(ctx, "info", "Processing audio request", map[string]interface{}{
+			"user":       getUserInfo(c),
+			"chat":       getChatInfo(c),
+			"kind":       audio.Kind,
+			"duration_s": int(audio.Duration.Seconds()),
+			"mode":       mode.Name,
+		})
+
+		result = mode.Audio(c, audio)
+	} else {
+		// Get the text from the replied message
+		originalText := c.Message().ReplyTo.Text
+		if originalText == "" {
+			logJSONContext(ctx, "warn", "Replied message has no text", map[string]interface{}{
+				"user": getUserInfo(c),
+				"chat": getChatInfo(c),
+			})
+			recordCommand(mode.Name, outcomeRejected)
+			return c.Reply(localize(language, msgNoTextReply))
+		}
+
+		logJSONContext(ctx, "info", "Processing request", map[string]interface{}{
+			"user":        getUserInfo(c),
+			"chat":        getChatInfo(c),
+			"text_length": len(originalText),
+			"mode":        mode.Name,
+		})
+
+		result = mode.Process(c, originalText)
+		textRequest = true
+	}
+
+	result = settleResult(ctx, c, rdb, mode.Name, result, language, charge, cacheKey)
+
+	// Answers about a link are also kept by link, for when the budget is exhausted
+	if result.OK() && textRequest && linkURL != "" && rdb != nil {
+		if err := rdb.Set(ctx, linkAnswerKey(mode.Name, linkURL), result.Answer, answerCacheTTL).Err(); err != nil {
+			logJSONContext(ctx, "warn", "Failed to cache answer", map[string]interface{}{
+				"error": err.Error(),
+				"mode":  mode.Name,
+			})
+		}
+	}
+
+	// Reply logic:
+	// - If answered -> reply to original message
+	// - Otherwise (refusal or error) -> reply to command message
+
+	logJSONContext(ctx, "debug", "Preparing to send reply", map[string]interface{}{
+		"category":       result.Category,
+		"answer_length":  len(result.Answer),
+		"answer_preview": truncateString(result.Answer, 100),
+		"mode":           mode.Name,
+	})
+
+	if result.OK() {
+		sent, err := replyToOriginal(ctx, c, result.Answer)
+		if err != nil {
+			return err
+		}
+
+		// Replies to the answer continue the thread
+		if conv := pendingConversation(c); conv != nil && rdb != nil {
+			if err := saveConversation(ctx, rdb, c.Chat().ID, sent.ID, conv); err != nil {
+				logJSONContext(ctx, "warn", "Failed to save conversation", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+		}
+		return nil
+	}
+
+	logJSONContext(ctx, "info", "Replying to command message", map[string]interface{}{
+		"user":           getUserInfo(c),
+		"chat":           getChatInfo(c),
+		"command_msg_id": c.Message().ID,
+	})
+	// Error or no URL: reply to the command message
+	return c.Reply(result.Answer, &tele.SendOptions{
+		DisableWebPagePreview: true,
+	})
+}
+
+// replyToOriginal sends the answer as a reply to the analyzed message
+func replyToOriginal(ctx context.Context, c tele.Context, answer string) (*tele.Message, error) {
+	logJSONContext(ctx, "success", "Replying to original message", map[string]interface{}{
+		"user":            getUserInfo(c),
+		"chat":            getChatInfo(c),
+		"original_msg_id": c.Message().ReplyTo.ID,
+		"command_msg_id":  c.Message().ID,
+		"chat_id":         c.Chat().ID,
+	})
+	_, sendSpan := tracer.Start(ctx, "telegram.sendMessage")
+	sent, err := c.Bot().Send(c.Chat(), answer, &tele.SendOptions{
+		ReplyTo:               c.Message().ReplyTo,
+		DisableWebPagePreview: true,
+	})
+	endSpan(sendSpan, err)
+	if err != nil {
+		logJSONContext(ctx, "error", "Failed to reply to original message", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return sent, err
+}
+
+// linkAnswerKey keeps the latest answer about a link, served when the budget is exhausted
+func linkAnswerKey(mode string, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return redisKey("answer:%s:%s", mode, hex.EncodeToString(sum[:16]))
+}
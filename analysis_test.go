@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// newAnalysisMockContext builds a reply command in an allowed group replying to a message with a link
+func newAnalysisMockContext(args []string, reply *string) *MockContextWithReply {
+	return &MockContextWithReply{
+		MockContext: MockContext{
+			chat:   &tele.Chat{ID: -1001234567890, Type: tele.ChatGroup},
+			sender: &tele.User{ID: 123456789, Username: "testuser"},
+			message: &tele.Message{
+				ID:      42,
+				ReplyTo: &tele.Message{ID: 41, Text: "look https://example.com"},
+			},
+			args: args,
+		},
+		replyFunc: func(what interface{}, opts ...interface{}) error {
+			*reply = what.(string)
+			return nil
+		},
+	}
+}
+
+func TestHandleTLDRCommandInvalidLength(t *testing.T) {
+	reply := ""
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+
+	err := handleTLDRCommand(newAnalysisMockContext([]string{"huge"}, &reply), allowlist, nil, 1, SummaryShort)
+	if err != nil {
+		t.Fatalf("handleTLDRCommand returned error: %v", err)
+	}
+	if reply != "Usage: /tldr [short|medium|bullets] as a reply to a message" {
+		t.Errorf("reply = %q, want usage", reply)
+	}
+}
+
+func TestHandleAnalysisCommandSeparateCaches(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	originalKey := googleAPIKey
+	defer func() { googleAPIKey = originalKey }()
+	googleAPIKey = ""
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	mr.Set("opinion:-1001234567890:41", "1")
+
+	// An existing opinion does not block a summary of the same message
+	reply := ""
+	handleTLDRCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, SummaryShort)
+	if reply != "I couldn't summarize this link, try again later 😴" {
+		t.Errorf("/tldr reply = %q, want a fresh summary attempt", reply)
+	}
+
+	// A cached summary is reported as a duplicate
+	mr.Set("tldr:-1001234567890:41", "1")
+	handleTLDRCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, SummaryShort)
+	if reply != "I've already summarized this, try to use search" {
+		t.Errorf("/tldr reply = %q, want duplicate notice", reply)
+	}
+
+	handleOpinionCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, nil)
+	if reply != "I've already answered, try to use search" {
+		t.Errorf("/opinion reply = %q, want duplicate notice", reply)
+	}
+}
+
+func TestHandleAnalysisCommandRateLimitPerMode(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	// Safety blocks are not refunded, unlike other failures
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{stoppedChunk("", genai.FinishReasonSafety)}})
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	reply := ""
+	handleOpinionCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, nil)
+	handleTLDRCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, SummaryShort)
+
+	members, _ := mr.ZMembers("ratelimit:123456789")
+	if len(members) != 2 {
+		t.Errorf("rate limit entries = %v, want one per command", members)
+	}
+}
+
+func TestHandleAnalysisCommandRefundsFailures(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{err: genai.APIError{Code: http.StatusTooManyRequests}})
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	reply := ""
+	handleOpinionCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, nil)
+	if reply != localize("en", msgLLMQuota) {
+		t.Errorf("reply = %q, want the quota notice", reply)
+	}
+	if members, _ := mr.ZMembers("ratelimit:123456789"); len(members) != 0 {
+		t.Errorf("rate limit entries = %v, want the request refunded", members)
+	}
+	if mr.Exists("opinion:-1001234567890:41") {
+		t.Error("a failed request should not be marked as answered")
+	}
+}
+
+func TestHandleAnalysisCommandCachesSafetyBlock(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{stoppedChunk("", genai.FinishReasonSafety)}}
+	useFakeLLMProvider(t, fake)
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	reply := ""
+	handleAnalysisCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, factcheckMode)
+	if reply != localize("en", msgBlocked) {
+		t.Fatalf("reply = %q, want the safety notice", reply)
+	}
+	if got, _ := mr.Get("factcheck:-1001234567890:41"); got != string(resultSafetyBlocked) {
+		t.Errorf("cache marker = %q, want %s", got, resultSafetyBlocked)
+	}
+	if members, _ := mr.ZMembers("ratelimit:123456789"); len(members) != 1 {
+		t.Errorf("rate limit entries = %v, want the blocked request counted", members)
+	}
+
+	// Asking again gets the refusal without another LLM call
+	fake.calls = 0
+	handleAnalysisCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, factcheckMode)
+	if reply != localize("en", msgBlocked) || fake.calls != 0 {
+		t.Errorf("repeat reply = %q after %d LLM calls, want the cached refusal", reply, fake.calls)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Limits and cost of voice messages, audio files and video notes
+var (
+	audioMaxDuration       = 5 * time.Minute
+	audioMaxBytes    int64 = 20 << 20
+	audioCost              = 2
+)
+
+var errAudioTooLong = errors.New("audio is too long")
+
+// replyAudio is a voice message, audio file or video note attached to the replied message
+type replyAudio struct {
+	File     tele.File
+	Kind     string // voice, audio or video_note
+	MIME     string
+	Duration time.Duration
+}
+
+// transcriber turns speech into text
+type transcriber interface {
+	Transcribe(ctx context.Context, data []byte, mimeType string) (string, error)
+}
+
+// audioTranscriber is the local transcription backend; when nil the audio is
+// sent directly to the multimodal model
+var audioTranscriber transcriber
+
+// commandTranscriber runs an external program (e.g. a whisper.cpp wrapper) that
+// reads the audio from stdin and writes the transcript to stdout. The MIME type
+// is passed in the AUDIO_MIME_TYPE environment variable.
+type commandTranscriber struct {
+	args    []string
+	timeout time.Duration
+}
+
+// newCommandTranscriber creates a transcriber from a command line like "whisper-stdin --model base"
+func newCommandTranscriber(command string, timeout time.Duration) (*commandTranscriber, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty transcriber command")
+	}
+	return &commandTranscriber{args: args, timeout: timeout}, nil
+}
+
+// Transcribe runs the command on the audio data
+func (t *commandTranscriber) Transcribe(ctx context.Context, data []byte, mimeType string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.args[0], t.args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "AUDIO_MIME_TYPE="+mimeType)
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("transcriber failed: %w: %s", err, truncateString(strings.TrimSpace(stderr.String()), 200))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// findReplyAudio returns the voice message, audio file or video note of the message
+func findReplyAudio(msg *tele.Message) (replyAudio, bool) {
+	if msg == nil {
+		return replyAudio{}, false
+	}
+
+	switch {
+	case msg.Voice != nil:
+		return replyAudio{File: msg.Voice.File, Kind: "voice", MIME: mimeOrDefault(msg.Voice.MIME, "audio/ogg"), Duration: time.Duration(msg.Voice.Duration) * time.Second}, true
+	case msg.Audio != nil:
+		return replyAudio{File: msg.Audio.File, Kind: "audio", MIME: mimeOrDefault(msg.Audio.MIME, "audio/mpeg"), Duration: time.Duration(msg.Audio.Duration) * time.Second}, true
+	case msg.VideoNote != nil:
+		return replyAudio{File: msg.VideoNote.File, Kind: "video_note", MIME: "video/mp4", Duration: time.Duration(msg.VideoNote.Duration) * time.Second}, true
+	}
+	return replyAudio{}, false
+}
+
+// mimeOrDefault returns mimeType, or def if it is empty
+func mimeOrDefault(mimeType string, def string) string {
+	if mimeType == "" {
+		return def
+	}
+	return mimeType
+}
+
+// fetchAudio checks the duration limit and downloads the audio
+func fetchAudio(c tele.Context, audio replyAudio) ([]byte, error) {
+	if audio.Duration > audioMaxDuration {
+		return nil, errAudioTooLong
+	}
+	return downloadLimited(c, &audio.File, audioMaxBytes)
+}
+
+// getAudioOpinion returns an opinion about a voice message, audio file or video note,
+// transcribing it locally when a transcriber is configured
+// Returns the opinion, or the reply and category of the failure
+func getAudioOpinion(c tele.Context, audio replyAudio, tone PromptType, language string) analysisResult {
+	ctx := requestContext(c)
+	data, err := fetchAudio(c, audio)
+	if err != nil {
+		logJSONContext(ctx, "warn", "Audio rejected", map[string]interface{}{
+			"user":       getUserInfo(c),
+			"chat":       getChatInfo(c),
+			"kind":       audio.Kind,
+			"duration_s": int(audio.Duration.Seconds()),
+			"file_size":  audio.File.FileSize,
+			"error":      err.Error(),
+		})
+		switch {
+		case errors.Is(err, errAudioTooLong):
+			return failed(resultTooLarge, localize(language, msgAudioTooLong, audioMaxDuration), err)
+		case errors.Is(err, errFileTooLarge):
+			return failed(resultTooLarge, localize(language, msgAudioTooLarge, audioMaxBytes>>20), err)
+		default:
+			return failed(resultFetchFailed, localize(language, msgAudioDownloadFailed), err)
+		}
+	}
+
+	if audioTranscriber == nil {
+		analysis, err := analyzeAudioWithLLM(ctx, data, audio.MIME, tone, language)
+		if err != nil {
+			return llmFailed(language, tone, err, msgTired)
+		}
+		return answered(analysis)
+	}
+
+	transcript, err := audioTranscriber.Transcribe(ctx, data, audio.MIME)
+	if err != nil {
+		logJSONContext(ctx, "error", "Transcription failed", map[string]interface{}{
+			"user":  getUserInfo(c),
+			"chat":  getChatInfo(c),
+			"kind":  audio.Kind,
+			"error": err.Error(),
+		})
+		return failed(resultFetchFailed, localize(language, msgTranscriptionFailed), err)
+	}
+	if transcript == "" {
+		return failed(resultNoText, localize(language, msgTranscriptEmpty), nil)
+	}
+
+	logJSONContext(ctx, "info", "Audio transcribed", map[string]interface{}{
+		"kind":              audio.Kind,
+		"transcript_length": len(transcript),
+	})
+
+	analysis, err := analyzeTextWithLLM(ctx, "Transcript of a voice message:\n\n"+transcript, tone, language)
+	if err != nil {
+		return llmFailed(language, tone, err, msgTired)
+	}
+	return answered(analysis)
+}
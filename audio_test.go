@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeTranscriber returns a canned transcript
+type fakeTranscriber struct {
+	transcript string
+	err        error
+	mimeType   string
+}
+
+func (f *fakeTranscriber) Transcribe(ctx context.Context, data []byte, mimeType string) (string, error) {
+	f.mimeType = mimeType
+	return f.transcript, f.err
+}
+
+// useTranscriber sets the transcription backend for the duration of the test
+func useTranscriber(t *testing.T, backend transcriber) {
+	t.Helper()
+	original := audioTranscriber
+	audioTranscriber = backend
+	t.Cleanup(func() { audioTranscriber = original })
+}
+
+func TestFindReplyAudio(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      *tele.Message
+		kind     string
+		mime     string
+		duration time.Duration
+	}{
+		{"Voice", &tele.Message{Voice: &tele.Voice{Duration: 12}}, "voice", "audio/ogg", 12 * time.Second},
+		{"Audio", &tele.Message{Audio: &tele.Audio{Duration: 200, MIME: "audio/mp4"}}, "audio", "audio/mp4", 200 * time.Second},
+		{"Video note", &tele.Message{VideoNote: &tele.VideoNote{Duration: 30}}, "video_note", "video/mp4", 30 * time.Second},
+		{"Text", &tele.Message{Text: "hello"}, "", "", 0},
+		{"Nil", nil, "", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio, ok := findReplyAudio(tt.msg)
+			if ok != (tt.kind != "") {
+				t.Fatalf("findReplyAudio() ok = %v", ok)
+			}
+			if audio.Kind != tt.kind || audio.MIME != tt.mime || audio.Duration != tt.duration {
+				t.Errorf("findReplyAudio() = %+v", audio)
+			}
+		})
+	}
+}
+
+func TestGetAudioOpinionDirect(t *testing.T) {
+	silenceStdout(t)
+	useTranscriber(t, nil)
+	useFakeDownload(t, []byte("OggS fake voice"), nil)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Loud, but wrong.")}}
+	useFakeLLMProvider(t, fake)
+
+	mockCtx := newAnalysisMockContext(nil, new(string))
+	result := getAudioOpinion(mockCtx, replyAudio{Kind: "voice", MIME: "audio/ogg", Duration: time.Minute}, PromptPositive, "")
+	if !result.OK() || result.Answer != "Loud, but wrong." {
+		t.Fatalf("getAudioOpinion() = %q, %s", result.Answer, result.Category)
+	}
+	part := fake.contents[0].Parts[0]
+	if part.InlineData == nil || part.InlineData.MIMEType != "audio/ogg" || string(part.InlineData.Data) != "OggS fake voice" {
+		t.Errorf("expected an inline audio part, got %+v", part)
+	}
+}
+
+func TestGetAudioOpinionTranscribed(t *testing.T) {
+	silenceStdout(t)
+	backend := &fakeTranscriber{transcript: "Tabs are better than spaces"}
+	useTranscriber(t, backend)
+	useFakeDownload(t, []byte("audio"), nil)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Spaces win.")}}
+	useFakeLLMProvider(t, fake)
+
+	mockCtx := newAnalysisMockContext(nil, new(string))
+	result := getAudioOpinion(mockCtx, replyAudio{Kind: "video_note", MIME: "video/mp4"}, PromptPositive, "")
+	if !result.OK() || result.Answer != "Spaces win." {
+		t.Fatalf("getAudioOpinion() = %q, %s", result.Answer, result.Category)
+	}
+	if backend.mimeType != "video/mp4" {
+		t.Errorf("transcriber MIME = %q, want video/mp4", backend.mimeType)
+	}
+	if sent := fake.contents[0].Parts[0].Text; !strings.HasSuffix(sent, "Tabs are better than spaces") {
+		t.Errorf("sent text = %q, want the transcript", sent)
+	}
+}
+
+func TestGetAudioOpinionErrors(t *testing.T) {
+	silenceStdout(t)
+	mockCtx := newAnalysisMockContext(nil, new(string))
+
+	tests := []struct {
+		name        string
+		audio       replyAudio
+		backend     transcriber
+		downloadErr error
+		category    resultCategory
+		expected    string
+	}{
+		{"Too long", replyAudio{Duration: 10 * time.Minute}, nil, nil, resultTooLarge, "That's too long to listen to, the limit is 5m0s ⏱"},
+		{"Too large", replyAudio{File: tele.File{FileSize: 30 << 20}}, nil, nil, resultTooLarge, "This recording is too big for me, the limit is 20 MB 🐘"},
+		{"Download failed", replyAudio{}, nil, errors.New("telegram down"), resultFetchFailed, "I couldn't download the recording, try again later 😴"},
+		{"Transcriber failed", replyAudio{}, &fakeTranscriber{err: errors.New("no model")}, nil, resultFetchFailed, "I couldn't make out what was said, try again later 😴"},
+		{"Silence", replyAudio{}, &fakeTranscriber{}, nil, resultNoText, "I couldn't hear anything in this recording 🤷"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTranscriber(t, tt.backend)
+			useFakeDownload(t, []byte("audio"), tt.downloadErr)
+			result := getAudioOpinion(mockCtx, tt.audio, PromptPositive, "")
+			if result.Category != tt.category || result.Answer != tt.expected {
+				t.Errorf("getAudioOpinion() = %q, %s, want %q, %s", result.Answer, result.Category, tt.expected, tt.category)
+			}
+		})
+	}
+}
+
+func TestCommandTranscriber(t *testing.T) {
+	backend, err := newCommandTranscriber("cat", time.Minute)
+	if err != nil {
+		t.Fatalf("newCommandTranscriber() error: %v", err)
+	}
+	transcript, err := backend.Transcribe(context.Background(), []byte("  hello world\n"), "audio/ogg")
+	if err != nil || transcript != "hello world" {
+		t.Errorf("Transcribe() = %q, %v", transcript, err)
+	}
+
+	envBackend, _ := newCommandTranscriber("env", time.Minute)
+	if output, err := envBackend.Transcribe(context.Background(), nil, "audio/ogg"); err != nil || !strings.Contains(output, "AUDIO_MIME_TYPE=audio/ogg") {
+		t.Errorf("transcriber command should get AUDIO_MIME_TYPE, err %v", err)
+	}
+
+	failing, _ := newCommandTranscriber("false", time.Minute)
+	if _, err := failing.Transcribe(context.Background(), []byte("audio"), "audio/ogg"); err == nil {
+		t.Error("Transcribe() with a failing command expected error, got nil")
+	}
+
+	if _, err := newCommandTranscriber("  ", time.Minute); err == nil {
+		t.Error("newCommandTranscriber() with empty command expected error, got nil")
+	}
+}
+
+func TestHandleOpinionCommandAudioCost(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	useTranscriber(t, nil)
+	useFakeDownload(t, []byte("OggS fake voice"), nil)
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{stoppedChunk("", genai.FinishReasonSafety)}})
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	reply := ""
+
+	// Each voice reply costs audioCost units, so the daily limit runs out earlier
+	for i := 0; i < dailyRequestLimit/audioCost; i++ {
+		mockCtx := newAnalysisMockContext(nil, &reply)
+		mockCtx.message.ReplyTo = &tele.Message{ID: 100 + i, Voice: &tele.Voice{Duration: 5}}
+		handleOpinionCommand(mockCtx, allowlist, nil, 1, nil)
+		if strings.Contains(reply, "limit of") {
+			t.Fatalf("request %d was rate limited: %q", i, reply)
+		}
+	}
+
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	mockCtx.message.ReplyTo = &tele.Message{ID: 200, Voice: &tele.Voice{Duration: 5}}
+	handleOpinionCommand(mockCtx, allowlist, nil, 1, nil)
+	expected := fmt.Sprintf("⚠️ You've reached the limit of %d requests per day for new messages. Already analyzed messages can still be searched.", dailyRequestLimit)
+	if reply != expected {
+		t.Errorf("reply = %q, want rate limit", reply)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	tele "gopkg.in/telebot.v3"
+)
+
+// budgetState is how close the spending is to the caps
+type budgetState int
+
+const (
+	budgetOK        budgetState = iota
+	budgetDegraded              // near a cap: cheaper model, no thinking
+	budgetExhausted             // past a cap: only cached answers
+)
+
+// Budget scopes, also the /budget arguments and the fields of the override hash
+const (
+	budgetDaily       = "daily"
+	budgetMonthly     = "monthly"
+	budgetChatDaily   = "chat_daily"
+	budgetChatMonthly = "chat_monthly"
+)
+
+var budgetScopes = []string{budgetDaily, budgetMonthly, budgetChatDaily, budgetChatMonthly}
+
+// budgetConfig holds the spending caps in micro-USD (0 for no cap) and the degradation settings
+type budgetConfig struct {
+	Caps          map[string]int64
+	DegradeAt     float64 // fraction of a cap from which requests are degraded
+	FallbackModel string  // cheaper model used when degraded, empty to keep the model
+}
+
+// budget is the configured budget; owners override the caps with /budget
+var budget = budgetConfig{Caps: map[string]int64{}, DegradeAt: 0.8}
+
+// loadBudgetConfig reads the caps in USD from BUDGET_DAILY_USD, BUDGET_MONTHLY_USD,
+// BUDGET_CHAT_DAILY_USD and BUDGET_CHAT_MONTHLY_USD, and the degradation settings
+// from BUDGET_DEGRADE_AT and BUDGET_FALLBACK_MODEL. With a cap set, the model and the
+// fallback model must be in the price table, or their spending would not count.
+func loadBudgetConfig() (budgetConfig, error) {
+	cfg := budgetConfig{Caps: map[string]int64{}, DegradeAt: 0.8}
+
+	for _, scope := range budgetScopes {
+		name := "BUDGET_" + strings.ToUpper(scope) + "_USD"
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		usd, err := parseUSD(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q", name, v)
+		}
+		cfg.Caps[scope] = usdToMicros(usd)
+	}
+
+	if v := os.Getenv("BUDGET_DEGRADE_AT"); v != "" {
+		fraction, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(fraction > 0 && fraction <= 1) {
+			return cfg, fmt.Errorf("invalid BUDGET_DEGRADE_AT %q, want a fraction like 0.8", v)
+		}
+		cfg.DegradeAt = fraction
+	}
+	cfg.FallbackModel = os.Getenv("BUDGET_FALLBACK_MODEL")
+
+	if len(cfg.Caps) > 0 {
+		for _, model := range []string{llmModel, cfg.FallbackModel} {
+			if _, ok := modelPrices[model]; model != "" && !ok {
+				return cfg, fmt.Errorf("model %q has no price in LLM_PRICES", model)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// maxUSD keeps amounts representable in micro-USD
+const maxUSD = math.MaxInt64 / 1e6
+
+// parseUSD parses a non-negative, finite amount in USD
+func parseUSD(s string) (float64, error) {
+	usd, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(usd) || usd < 0 || usd >= maxUSD {
+		return 0, fmt.Errorf("amount %q out of range", s)
+	}
+	return usd, nil
+}
+
+// usdToMicros converts USD to micro-USD
+func usdToMicros(usd float64) int64 {
+	return int64(math.Round(usd * 1e6))
+}
+
+// usageMonth is the UTC month the usage is accounted to
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// budgetOverridesKey is the hash of caps set with /budget, in micro-USD: the scopes,
+// and chat:<id>:daily / chat:<id>:monthly for single chats
+func budgetOverridesKey() string {
+	return redisKey("budget:caps")
+}
+
+// budgetLimit is the spending in one scope against its cap
+type budgetLimit struct {
+	Scope string
+	Spent int64 // micro-USD
+	Cap   int64 // micro-USD, 0 without a cap
+}
+
+// budgetStatus is the spending of the bot and of a chat in the current day and month
+type budgetStatus struct {
+	ChatID int64
+	Limits []budgetLimit
+}
+
+// State returns the state of the most constrained cap
+func (s budgetStatus) State(degradeAt float64) budgetState {
+	state := budgetOK
+	for _, limit := range s.Limits {
+		if limit.Cap == 0 {
+			continue
+		}
+		ratio := float64(limit.Spent) / float64(limit.Cap)
+		if ratio >= 1 {
+			return budgetExhausted
+		}
+		if ratio >= degradeAt {
+			state = budgetDegraded
+		}
+	}
+	return state
+}
+
+// loadBudgetStatus reads the spending of today and this month against the effective caps.
+// A chat cap is the override for the chat, else the override for all chats, else the configured one.
+func loadBudgetStatus(ctx context.Context, rdb redis.UniversalClient, chatID int64, now time.Time) (budgetStatus, error) {
+	status := budgetStatus{ChatID: chatID}
+	day, month := usageDay(now), usageMonth(now)
+
+	pipe := rdb.Pipeline()
+	overrides := pipe.HGetAll(ctx, budgetOverridesKey())
+	spent := map[string]*redis.StringCmd{
+		budgetDaily:   pipe.HGet(ctx, redisKey("usage:%s:total", day), "cost_micros"),
+		budgetMonthly: pipe.HGet(ctx, redisKey("usage:%s:total", month), "cost_micros"),
+	}
+	if chatID != 0 {
+		spent[budgetChatDaily] = pipe.HGet(ctx, redisKey("usage:%s:chat:%d", day, chatID), "cost_micros")
+		spent[budgetChatMonthly] = pipe.HGet(ctx, redisKey("usage:%s:chat:%d", month, chatID), "cost_micros")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return status, err
+	}
+
+	for _, scope := range budgetScopes {
+		cmd, ok := spent[scope]
+		if !ok {
+			continue
+		}
+		limit := budgetLimit{Scope: scope, Cap: budget.Caps[scope]}
+		limit.Spent, _ = strconv.ParseInt(cmd.Val(), 10, 64)
+
+		fields := []string{scope}
+		if scope == budgetChatDaily || scope == budgetChatMonthly {
+			fields = []string{chatBudgetField(chatID, scope), scope}
+		}
+		for _, field := range fields {
+			if v, ok := overrides.Val()[field]; ok {
+				limit.Cap, _ = strconv.ParseInt(v, 10, 64)
+				break
+			}
+		}
+		status.Limits = append(status.Limits, limit)
+	}
+	return status, nil
+}
+
+// chatBudgetField is the override field of a chat cap
+func chatBudgetField(chatID int64, scope string) string {
+	return fmt.Sprintf("chat:%d:%s", chatID, strings.TrimPrefix(scope, "chat_"))
+}
+
+type budgetContextKey struct{}
+
+// withBudgetState marks the requests made with ctx as degraded or not
+func withBudgetState(ctx context.Context, state budgetState) context.Context {
+	return context.WithValue(ctx, budgetContextKey{}, state)
+}
+
+// degradedByBudget checks if LLM requests made with ctx should use the cheaper setup
+func degradedByBudget(ctx context.Context) bool {
+	state, _ := ctx.Value(budgetContextKey{}).(budgetState)
+	return state == budgetDegraded
+}
+
+// applyBudget checks the caps for the chat of c. Near a cap the request context of c
+// is marked degraded so runLLM uses the cheaper setup. Requests are allowed when the
+// spending cannot be read.
+func applyBudget(ctx context.Context, rdb redis.UniversalClient, c tele.Context) budgetState {
+	status, err := loadBudgetStatus(ctx, rdb, c.Chat().ID, time.Now())
+	if err != nil {
+		logJSONContext(ctx, "warn", "Failed to check budget", map[string]interface{}{
+			"chat":  getChatInfo(c),
+			"error": err.Error(),
+		})
+		return budgetOK
+	}
+
+	state := status.State(budget.DegradeAt)
+	if state != budgetOK {
+		limits := make(map[string]interface{}, len(status.Limits))
+		for _, limit := range status.Limits {
+			limits[limit.Scope] = fmt.Sprintf("%d/%d", limit.Spent, limit.Cap)
+		}
+		logJSONContext(ctx, "info", "Budget cap near or reached", map[string]interface{}{
+			"chat":      getChatInfo(c),
+			"exhausted": state == budgetExhausted,
+			"limits":    limits,
+		})
+	}
+	if state == budgetDegraded {
+		c.Set(requestContextKey, withBudgetState(requestContext(c), state))
+	}
+	return state
+}
+
+// handleBudgetCommand shows the spending against the caps, or overrides a cap:
+// /budget <scope> <usd|off|default> [chat_id]
+func handleBudgetCommand(c tele.Context) error {
+	language := userLanguage(c)
+	rdb := activeRedis()
+	if rdb == nil {
+		return c.Reply(localize(language, msgBudgetUnavailable))
+	}
+	ctx := context.Background()
+
+	args := c.Args()
+	if len(args) == 0 {
+		status, err := loadBudgetStatus(ctx, rdb, c.Chat().ID, time.Now())
+		if err != nil {
+			logJSON("warn", "Failed to load budget", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return c.Reply(localize(language, msgBudgetUnavailable))
+		}
+		return c.Reply(formatBudgetStatus(language, status))
+	}
+
+	field, value, err := parseBudgetOverride(args)
+	if err != nil {
+		return c.Reply(localize(language, msgBudgetHelp))
+	}
+
+	display := value
+	switch value {
+	case "default":
+		err = rdb.HDel(ctx, budgetOverridesKey(), field).Err()
+	case "off":
+		err = rdb.HSet(ctx, budgetOverridesKey(), field, 0).Err()
+	default:
+		usd, _ := parseUSD(value)
+		display = fmt.Sprintf("$%.2f", usd)
+		err = rdb.HSet(ctx, budgetOverridesKey(), field, usdToMicros(usd)).Err()
+	}
+	logJSON("info", "Budget cap overridden by owner", map[string]interface{}{
+		"user":      getUserInfo(c),
+		"field":     field,
+		"value":     value,
+		"persisted": err == nil,
+	})
+	if err != nil {
+		return c.Reply(localize(language, msgBudgetUnavailable))
+	}
+	return c.Reply(localize(language, msgBudgetUpdated, field, display))
+}
+
+// parseBudgetOverride returns the override field and value of /budget arguments.
+// The value is a positive amount in USD, off or default; a chat ID is only accepted
+// for the chat scopes.
+func parseBudgetOverride(args []string) (string, string, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return "", "", fmt.Errorf("want a scope and a value")
+	}
+
+	scope := strings.ToLower(strings.TrimSpace(args[0]))
+	field := ""
+	for _, s := range budgetScopes {
+		if s == scope {
+			field = scope
+		}
+	}
+	if field == "" {
+		return "", "", fmt.Errorf("unknown scope %q", scope)
+	}
+
+	value := strings.ToLower(strings.TrimSpace(args[1]))
+	if value != "off" && value != "default" {
+		usd, err := parseUSD(value)
+		if err != nil || usd == 0 {
+			return "", "", fmt.Errorf("invalid amount %q", value)
+		}
+	}
+
+	if len(args) == 3 {
+		chatID, err := strconv.ParseInt(strings.TrimSpace(args[2]), 10, 64)
+		if err != nil || (scope != budgetChatDaily && scope != budgetChatMonthly) {
+			return "", "", fmt.Errorf("invalid chat %q", args[2])
+		}
+		field = chatBudgetField(chatID, scope)
+	}
+	return field, value, nil
+}
+
+// formatBudgetStatus renders the /budget reply
+func formatBudgetStatus(language string, status budgetStatus) string {
+	stateKey := map[budgetState]messageKey{
+		budgetOK:        msgBudgetOK,
+		budgetDegraded:  msgBudgetDegraded,
+		budgetExhausted: msgBudgetOver,
+	}[status.State(budget.DegradeAt)]
+
+	var sb strings.Builder
+	sb.WriteString(localize(language, msgBudgetStatus, localize(language, stateKey)))
+	for _, limit := range status.Limits {
+		scope := limit.Scope
+		if scope == budgetChatDaily || scope == budgetChatMonthly {
+			scope = fmt.Sprintf("%s (%d)", scope, status.ChatID)
+		}
+		spent := float64(limit.Spent) / 1e6
+		if limit.Cap == 0 {
+			sb.WriteString("\n" + localize(language, msgBudgetNoLimit, scope, spent))
+		} else {
+			sb.WriteString("\n" + localize(language, msgBudgetLimit, scope, spent, float64(limit.Cap)/1e6))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// useBudget replaces the budget configuration for the duration of the test
+func useBudget(t *testing.T, cfg budgetConfig) {
+	t.Helper()
+	original := budget
+	budget = cfg
+	t.Cleanup(func() { budget = original })
+}
+
+// spend records usage costing micros for the chat today
+func spend(t *testing.T, chatID int64, micros int64) {
+	t.Helper()
+	original := modelPrices
+	modelPrices = map[string]modelPrice{"budget-test": {Input: 1}}
+	defer func() { modelPrices = original }()
+
+	usage := llmUsage{PromptTokens: micros}
+	if err := recordUsage(context.Background(), redisClient, "budget-test", requester{ChatID: chatID, UserID: 1}, usage, time.Now()); err != nil {
+		t.Fatalf("recordUsage() error: %v", err)
+	}
+}
+
+func TestLoadBudgetConfig(t *testing.T) {
+	for _, name := range []string{"BUDGET_DAILY_USD", "BUDGET_MONTHLY_USD", "BUDGET_CHAT_DAILY_USD", "BUDGET_CHAT_MONTHLY_USD", "BUDGET_DEGRADE_AT", "BUDGET_FALLBACK_MODEL"} {
+		t.Setenv(name, "")
+	}
+
+	cfg, err := loadBudgetConfig()
+	if err != nil {
+		t.Fatalf("loadBudgetConfig() error: %v", err)
+	}
+	if len(cfg.Caps) != 0 || cfg.DegradeAt != 0.8 || cfg.FallbackModel != "" {
+		t.Errorf("default config = %+v", cfg)
+	}
+
+	t.Setenv("BUDGET_DAILY_USD", "5")
+	t.Setenv("BUDGET_CHAT_MONTHLY_USD", "12.5")
+	t.Setenv("BUDGET_DEGRADE_AT", "0.9")
+	t.Setenv("BUDGET_FALLBACK_MODEL", "gemini-flash-lite-latest")
+	cfg, err = loadBudgetConfig()
+	if err != nil {
+		t.Fatalf("loadBudgetConfig() error: %v", err)
+	}
+	if cfg.Caps[budgetDaily] != 5000000 || cfg.Caps[budgetChatMonthly] != 12500000 || cfg.Caps[budgetMonthly] != 0 {
+		t.Errorf("caps = %v", cfg.Caps)
+	}
+	if cfg.DegradeAt != 0.9 || cfg.FallbackModel != "gemini-flash-lite-latest" {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	for name, value := range map[string]string{
+		"BUDGET_MONTHLY_USD":      "lots",
+		"BUDGET_DAILY_USD":        "-1",
+		"BUDGET_CHAT_DAILY_USD":   "NaN",
+		"BUDGET_CHAT_MONTHLY_USD": "+Inf",
+		"BUDGET_DEGRADE_AT":       "1.5",
+		"BUDGET_FALLBACK_MODEL":   "gemini-flash-lite-lates",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loadBudgetConfig(); err == nil {
+				t.Errorf("loadBudgetConfig() with %s=%q should fail", name, value)
+			}
+		})
+	}
+}
+
+func TestBudgetState(t *testing.T) {
+	tests := []struct {
+		limits []budgetLimit
+		want   budgetState
+	}{
+		{nil, budgetOK},
+		{[]budgetLimit{{Scope: budgetDaily, Spent: 1000, Cap: 0}}, budgetOK},
+		{[]budgetLimit{{Scope: budgetDaily, Spent: 790, Cap: 1000}}, budgetOK},
+		{[]budgetLimit{{Scope: budgetDaily, Spent: 800, Cap: 1000}}, budgetDegraded},
+		{[]budgetLimit{{Scope: budgetDaily, Spent: 100, Cap: 1000}, {Scope: budgetChatMonthly, Spent: 500, Cap: 500}}, budgetExhausted},
+	}
+	for _, tt := range tests {
+		if got := (budgetStatus{Limits: tt.limits}).State(0.8); got != tt.want {
+			t.Errorf("State(%v) = %v, want %v", tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestLoadBudgetStatusOverrides(t *testing.T) {
+	mr := useMiniredis(t)
+	useBudget(t, budgetConfig{Caps: map[string]int64{budgetDaily: 1000, budgetChatDaily: 300}, DegradeAt: 0.8})
+	spend(t, -100, 250)
+
+	status, err := loadBudgetStatus(context.Background(), redisClient, -100, time.Now())
+	if err != nil {
+		t.Fatalf("loadBudgetStatus() error: %v", err)
+	}
+	caps := map[string]budgetLimit{}
+	for _, limit := range status.Limits {
+		caps[limit.Scope] = limit
+	}
+	if caps[budgetDaily].Spent != 250 || caps[budgetMonthly].Spent != 250 || caps[budgetChatDaily].Spent != 250 {
+		t.Errorf("spent = %+v", status.Limits)
+	}
+	if caps[budgetChatDaily].Cap != 300 || status.State(budget.DegradeAt) != budgetDegraded {
+		t.Errorf("configured chat cap = %+v, state %v", caps[budgetChatDaily], status.State(budget.DegradeAt))
+	}
+
+	// The override for all chats replaces the configured cap, the one for a chat wins over both
+	mr.HSet("budget:caps", budgetChatDaily, "200")
+	mr.HSet("budget:caps", "chat:-100:daily", "0")
+	status, _ = loadBudgetStatus(context.Background(), redisClient, -100, time.Now())
+	if status.State(budget.DegradeAt) != budgetOK {
+		t.Errorf("state with the chat cap off = %v, want ok", status.State(budget.DegradeAt))
+	}
+	status, _ = loadBudgetStatus(context.Background(), redisClient, -200, time.Now())
+	for _, limit := range status.Limits {
+		if limit.Scope == budgetChatDaily && limit.Cap != 200 {
+			t.Errorf("cap of another chat = %d, want the override for all chats", limit.Cap)
+		}
+	}
+}
+
+func TestAnalysisCommandOverBudget(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	useBudget(t, budgetConfig{Caps: map[string]int64{budgetMonthly: 1000}, DegradeAt: 0.8})
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Answer")}}
+	useFakeLLMProvider(t, fake)
+	spend(t, -5, 1000)
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	overBudget := counterDelta(commandsTotal.WithLabelValues("factcheck", outcomeOverBudget))
+	reply := ""
+	handleAnalysisCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, factcheckMode)
+	if reply != localize("en", msgBudgetExhausted) {
+		t.Errorf("reply = %q, want the budget notice", reply)
+	}
+	if got := overBudget(); got != 1 {
+		t.Errorf("over_budget = %v, want 1", got)
+	}
+	if fake.config != nil {
+		t.Error("the LLM should not be called past the budget")
+	}
+	if mr.Exists("ratelimit:123456789") {
+		t.Error("a request rejected by the budget should not consume the rate limit")
+	}
+
+	// Already answered messages are still served
+	mr.Set("factcheck:-1001234567890:41", "1")
+	handleAnalysisCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, factcheckMode)
+	if reply != localize("en", msgDuplicateFactCheck) {
+		t.Errorf("reply for an answered message = %q, want the duplicate notice", reply)
+	}
+}
+
+// useTelegramAPI returns an offline bot whose API calls go to a fake server recording the sent texts
+func useTelegramAPI(t *testing.T) (*tele.Bot, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var sent []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		json.NewDecoder(r.Body).Decode(&params)
+		mu.Lock()
+		sent = append(sent, params["text"])
+		id := 100 + len(sent)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"chat":{"id":%s},"text":%q}}`, id, params["chat_id"], params["text"])
+	}))
+	t.Cleanup(api.Close)
+
+	bot, err := tele.NewBot(tele.Settings{Token: "test-token", URL: api.URL, Offline: true})
+	if err != nil {
+		t.Fatalf("NewBot() error: %v", err)
+	}
+	return bot, &sent
+}
+
+func TestAnalysisCommandServesCachedLinkAnswerOverBudget(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	useBudget(t, budgetConfig{Caps: map[string]int64{budgetMonthly: 1000}, DegradeAt: 0.8})
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Mostly true")}}
+	useFakeLLMProvider(t, fake)
+	bot, sent := useTelegramAPI(t)
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+
+	reply := ""
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	mockCtx.bot = bot
+	if err := handleAnalysisCommand(mockCtx, allowlist, nil, 1, factcheckMode); err != nil {
+		t.Fatalf("handleAnalysisCommand() error: %v", err)
+	}
+	if len(*sent) != 1 || (*sent)[0] != "Mostly true" {
+		t.Fatalf("sent = %v, want the answer", *sent)
+	}
+
+	// Past the cap, another message with the same link gets the cached answer
+	spend(t, -5, 1000)
+	fake.calls = 0
+	cached := counterDelta(commandsTotal.WithLabelValues("factcheck", outcomeCached))
+	mockCtx = newAnalysisMockContext(nil, &reply)
+	mockCtx.bot = bot
+	mockCtx.message.ReplyTo = &tele.Message{ID: 55, Text: "same link https://example.com"}
+	if err := handleAnalysisCommand(mockCtx, allowlist, nil, 1, factcheckMode); err != nil {
+		t.Fatalf("handleAnalysisCommand() error: %v", err)
+	}
+	if len(*sent) != 2 || (*sent)[1] != "Mostly true" || fake.calls != 0 {
+		t.Errorf("sent = %v after %d LLM calls, want the cached answer without calling the LLM", *sent, fake.calls)
+	}
+	if got := cached(); got != 1 {
+		t.Errorf("cached = %v, want 1", got)
+	}
+
+	// Links never answered still get the budget notice
+	mockCtx = newAnalysisMockContext(nil, &reply)
+	mockCtx.bot = bot
+	mockCtx.message.ReplyTo = &tele.Message{ID: 56, Text: "https://example.org/new"}
+	handleAnalysisCommand(mockCtx, allowlist, nil, 1, factcheckMode)
+	if reply != localize("en", msgBudgetExhausted) || len(*sent) != 2 {
+		t.Errorf("reply = %q, want the budget notice", reply)
+	}
+}
+
+func TestRunLLMDegradedByBudget(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	useBudget(t, budgetConfig{Caps: map[string]int64{budgetChatDaily: 1000}, DegradeAt: 0.8, FallbackModel: "cheap-model"})
+	spend(t, -1001234567890, 850)
+
+	reply := ""
+	c := &storingContext{MockContextWithReply: newAnalysisMockContext(nil, &reply), store: map[string]interface{}{}}
+	if state := applyBudget(context.Background(), redisClient, c); state != budgetDegraded {
+		t.Fatalf("applyBudget() = %v, want degraded", state)
+	}
+
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Short answer")}}
+	useFakeLLMProvider(t, fake)
+	if _, err := runLLM(requestContext(c), llmRequest{PromptType: "negative"}); err != nil {
+		t.Fatalf("runLLM() error: %v", err)
+	}
+	if fake.model != "cheap-model" {
+		t.Errorf("model = %q, want the fallback model", fake.model)
+	}
+	if got := *fake.config.ThinkingConfig.ThinkingBudget; got != 0 {
+		t.Errorf("thinking budget = %d, want 0", got)
+	}
+
+	if _, err := runLLM(context.Background(), llmRequest{PromptType: "negative"}); err != nil {
+		t.Fatalf("runLLM() error: %v", err)
+	}
+	if fake.model != llmModel || *fake.config.ThinkingConfig.ThinkingBudget == 0 {
+		t.Errorf("requests without the degraded context use %q with thinking %d", fake.model, *fake.config.ThinkingConfig.ThinkingBudget)
+	}
+}
+
+func TestParseBudgetOverride(t *testing.T) {
+	tests := []struct {
+		args  []string
+		field string
+		value string
+		ok    bool
+	}{
+		{[]string{"daily", "5"}, "daily", "5", true},
+		{[]string{"MONTHLY", "off"}, "monthly", "off", true},
+		{[]string{"chat_daily", "0.5"}, "chat_daily", "0.5", true},
+		{[]string{"chat_monthly", "default", "-100"}, "chat:-100:monthly", "default", true},
+		{[]string{"daily"}, "", "", false},
+		{[]string{"weekly", "5"}, "", "", false},
+		{[]string{"daily", "0"}, "", "", false},
+		{[]string{"daily", "NaN"}, "", "", false},
+		{[]string{"daily", "Inf"}, "", "", false},
+		{[]string{"monthly", "-Inf"}, "", "", false},
+		{[]string{"monthly", "-5"}, "", "", false},
+		{[]string{"monthly", "1e300"}, "", "", false},
+		{[]string{"daily", "5", "-100"}, "", "", false},
+		{[]string{"chat_daily", "5", "abc"}, "", "", false},
+	}
+	for _, tt := range tests {
+		field, value, err := parseBudgetOverride(tt.args)
+		if (err == nil) != tt.ok || field != tt.field || value != tt.value {
+			t.Errorf("parseBudgetOverride(%v) = %q, %q, %v", tt.args, field, value, err)
+		}
+	}
+}
+
+func TestHandleBudgetCommand(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	useBudget(t, budgetConfig{Caps: map[string]int64{budgetDaily: 5000000}, DegradeAt: 0.8})
+	reply := ""
+	handleBudgetCommand(newAdminMockContext(111, []string{"weekly", "5"}, &reply))
+	if !strings.Contains(reply, "Usage: /budget") {
+		t.Errorf("invalid arguments reply = %q", reply)
+	}
+
+	handleBudgetCommand(newAdminMockContext(111, []string{"monthly", "100"}, &reply))
+	if got := mr.HGet("budget:caps", "monthly"); got != "100000000" {
+		t.Errorf("monthly override = %q, want 100000000", got)
+	}
+	if !strings.Contains(reply, "monthly") || !strings.Contains(reply, "$100.00") {
+		t.Errorf("override reply = %q", reply)
+	}
+
+	handleBudgetCommand(newAdminMockContext(111, []string{"chat_daily", "off", "-100"}, &reply))
+	if got := mr.HGet("budget:caps", "chat:-100:daily"); got != "0" {
+		t.Errorf("chat override = %q, want 0", got)
+	}
+
+	spend(t, -1001234567890, 1500000)
+	handleBudgetCommand(newAdminMockContext(111, nil, &reply))
+	for _, want := range []string{"within the caps", "daily: $1.5000 of $5.00", "monthly: $1.5000 of $100.00", "chat_daily (-1001234567890): $1.5000, no cap"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("status %q does not contain %q", reply, want)
+		}
+	}
+
+	handleBudgetCommand(newAdminMockContext(111, []string{"monthly", "default"}, &reply))
+	if mr.Exists("budget:caps") && mr.HGet("budget:caps", "monthly") != "" {
+		t.Error("default should remove the monthly override")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// commandScope tells in which kind of chat a command can be used
+type commandScope string
+
+const (
+	scopeGroup   commandScope = "group"   // groups and supergroups
+	scopePrivate commandScope = "private" // private chats with the bot
+)
+
+// botCommand describes a command, where it can be used and how it is handled
+type botCommand struct {
+	Name        string         // command name without the leading slash
+	Description string         // shown in /help and the Telegram command menu
+	Scopes      []commandScope // chats where the command is available
+	NeedsReply  bool           // command must be sent as a reply to another message
+	Cost        int            // rate-limit units consumed by a new request, 0 if not limited
+	OwnerOnly   bool           // only listed for and usable by bot owners
+	Handler     func(c tele.Context, cmd botCommand) error
+}
+
+// availableIn checks if the command can be used in the given scope
+func (cmd botCommand) availableIn(scope commandScope) bool {
+	for _, s := range cmd.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// commandMenuSetter is the part of *tele.Bot used to publish the command menu
+type commandMenuSetter interface {
+	SetCommands(opts ...interface{}) error
+}
+
+// commandRegistry holds the bot commands in registration order
+type commandRegistry struct {
+	commands     []botCommand
+	ownerUserIDs []int64
+}
+
+// newCommandRegistry creates an empty registry; owners see owner-only commands
+func newCommandRegistry(ownerUserIDs []int64) *commandRegistry {
+	return &commandRegistry{ownerUserIDs: ownerUserIDs}
+}
+
+// Register adds a command to the registry
+func (r *commandRegistry) Register(cmd botCommand) {
+	r.commands = append(r.commands, cmd)
+}
+
+// Lookup finds a command by name
+func (r *commandRegistry) Lookup(name string) (botCommand, bool) {
+	name = strings.TrimPrefix(name, "/")
+	for _, cmd := range r.commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return botCommand{}, false
+}
+
+// Commands returns the commands available in the scope, including owner-only ones if requested
+func (r *commandRegistry) Commands(scope commandScope, includeOwnerOnly bool) []botCommand {
+	var result []botCommand
+	for _, cmd := range r.commands {
+		if !cmd.availableIn(scope) || (cmd.OwnerOnly && !includeOwnerOnly) {
+			continue
+		}
+		result = append(result, cmd)
+	}
+	return result
+}
+
+// HelpText generates the /help message for the scope in the language
+func (r *commandRegistry) HelpText(scope commandScope, includeOwnerOnly bool, language string) string {
+	var sb strings.Builder
+	sb.WriteString(localize(language, msgHelpHeader))
+
+	for _, cmd := range r.Commands(scope, includeOwnerOnly) {
+		description := localizeOr(language, commandDescriptionKey(cmd.Name), cmd.Description)
+		sb.WriteString(fmt.Sprintf("\n/%s - %s", cmd.Name, description))
+		if cmd.NeedsReply {
+			sb.WriteString(localize(language, msgHelpNeedsReply))
+		}
+	}
+
+	return sb.String()
+}
+
+// Install registers a Telegram handler for every command
+func (r *commandRegistry) Install(bot *tele.Bot) {
+	for _, cmd := range r.commands {
+		bot.Handle("/"+cmd.Name, r.dispatch(cmd))
+	}
+}
+
+// dispatch wraps a command handler with logging, owner, scope and reply checks
+func (r *commandRegistry) dispatch(cmd botCommand) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		logRequest(c, "/"+cmd.Name)
+
+		if cmd.OwnerOnly && !isOwner(c, r.ownerUserIDs) {
+			recordCommand(cmd.Name, outcomeUnauthorized)
+			return replyNotOwner(c, "/"+cmd.Name)
+		}
+
+		if !cmd.availableIn(chatScope(c.Chat())) {
+			logJSON("warn", "Command used in unsupported chat type", map[string]interface{}{
+				"user":    getUserInfo(c),
+				"chat":    getChatInfo(c),
+				"command": "/" + cmd.Name,
+			})
+			if cmd.availableIn(scopePrivate) {
+				return c.Reply(localize(userLanguage(c), msgCommandPrivateOnly, cmd.Name))
+			}
+			return c.Reply(localize(userLanguage(c), msgCommandGroupsOnly, cmd.Name))
+		}
+
+		if cmd.NeedsReply && c.Message().ReplyTo == nil {
+			logJSON("warn", "Command used without reply", map[string]interface{}{
+				"user":    getUserInfo(c),
+				"chat":    getChatInfo(c),
+				"command": "/" + cmd.Name,
+			})
+			recordCommand(cmd.Name, outcomeNoReply)
+			return c.Reply(localize(userLanguage(c), msgCommandNeedsReply, cmd.Name))
+		}
+
+		return cmd.Handler(c, cmd)
+	}
+}
+
+// commandMenu is the list of commands shown in one Telegram command scope
+type commandMenu struct {
+	name  string
+	scope tele.CommandScope
+	cmds  []botCommand
+}
+
+// SyncMenu publishes the command menu for group and private chats, plus an
+// extended menu in each owner's private chat
+func (r *commandRegistry) SyncMenu(bot commandMenuSetter) error {
+	menus := []commandMenu{
+		{"groups", tele.CommandScope{Type: tele.CommandScopeAllGroupChats}, r.Commands(scopeGroup, false)},
+		{"private", tele.CommandScope{Type: tele.CommandScopeAllPrivateChats}, r.Commands(scopePrivate, false)},
+	}
+	for _, ownerID := range r.ownerUserIDs {
+		menus = append(menus, commandMenu{"owner", tele.CommandScope{Type: tele.CommandScopeChat, ChatID: ownerID}, r.Commands(scopePrivate, true)})
+	}
+
+	for _, menu := range menus {
+		if err := bot.SetCommands(telegramCommands(menu.cmds), menu.scope); err != nil {
+			return fmt.Errorf("failed to set %s commands: %w", menu.name, err)
+		}
+	}
+
+	return nil
+}
+
+// telegramCommands converts commands to the setMyCommands format
+func telegramCommands(cmds []botCommand) []tele.Command {
+	result := make([]tele.Command, 0, len(cmds))
+	for _, cmd := range cmds {
+		result = append(result, tele.Command{Text: cmd.Name, Description: cmd.Description})
+	}
+	return result
+}
+
+// chatScope maps a Telegram chat type to a command scope
+func chatScope(chat *tele.Chat) commandScope {
+	if chat != nil && chat.Type == tele.ChatPrivate {
+		return scopePrivate
+	}
+	return scopeGroup
+}
+
+// handleHelpCommand replies with the commands available in the current chat
+func handleHelpCommand(c tele.Context, registry *commandRegistry) error {
+	includeOwnerOnly := isOwner(c, registry.ownerUserIDs)
+	return c.Reply(registry.HelpText(chatScope(c.Chat()), includeOwnerOnly, userLanguage(c)))
+}
+
+// handleStartCommand greets the user and shows the help text
+func handleStartCommand(c tele.Context, registry *commandRegistry) error {
+	language := userLanguage(c)
+	includeOwnerOnly := isOwner(c, registry.ownerUserIDs)
+	return c.Reply(localize(language, msgStartGreeting) + registry.HelpText(chatScope(c.Chat()), includeOwnerOnly, language))
+}
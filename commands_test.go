@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeMenuSetter records setMyCommands calls
+type fakeMenuSetter struct {
+	calls map[string][]tele.Command
+	err   error
+}
+
+func (f *fakeMenuSetter) SetCommands(opts ...interface{}) error {
+	if f.err != nil {
+		return f.err
+	}
+	var cmds []tele.Command
+	var scope tele.CommandScope
+	for _, opt := range opts {
+		switch v := opt.(type) {
+		case []tele.Command:
+			cmds = v
+		case tele.CommandScope:
+			scope = v
+		}
+	}
+	if f.calls == nil {
+		f.calls = make(map[string][]tele.Command)
+	}
+	key := scope.Type
+	if scope.ChatID != 0 {
+		key = fmt.Sprintf("%s:%d", key, scope.ChatID)
+	}
+	f.calls[key] = cmds
+	return nil
+}
+
+// newTestRegistry builds a registry with a typical mix of commands
+func newTestRegistry(handled *string) *commandRegistry {
+	record := func(c tele.Context, cmd botCommand) error {
+		*handled = cmd.Name
+		return nil
+	}
+
+	registry := newCommandRegistry([]int64{111})
+	registry.Register(botCommand{Name: "opinion", Description: "Share an opinion", Scopes: []commandScope{scopeGroup, scopePrivate}, NeedsReply: true, Cost: 1, Handler: record})
+	registry.Register(botCommand{Name: "help", Description: "List commands", Scopes: []commandScope{scopeGroup, scopePrivate}, Handler: record})
+	registry.Register(botCommand{Name: "start", Description: "Introduction", Scopes: []commandScope{scopePrivate}, Handler: record})
+	registry.Register(botCommand{Name: "listchats", Description: "List allowed chats", Scopes: []commandScope{scopeGroup, scopePrivate}, OwnerOnly: true, Handler: record})
+	return registry
+}
+
+func commandNames(cmds []botCommand) []string {
+	var names []string
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name)
+	}
+	return names
+}
+
+func TestCommandRegistryCommands(t *testing.T) {
+	handled := ""
+	registry := newTestRegistry(&handled)
+
+	tests := []struct {
+		name             string
+		scope            commandScope
+		includeOwnerOnly bool
+		expected         string
+	}{
+		{"Group", scopeGroup, false, "opinion,help"},
+		{"Private", scopePrivate, false, "opinion,help,start"},
+		{"Owner private", scopePrivate, true, "opinion,help,start,listchats"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := strings.Join(commandNames(registry.Commands(tt.scope, tt.includeOwnerOnly)), ",")
+			if result != tt.expected {
+				t.Errorf("Commands(%s, %v) = %s, want %s", tt.scope, tt.includeOwnerOnly, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommandRegistryLookup(t *testing.T) {
+	handled := ""
+	registry := newTestRegistry(&handled)
+
+	if cmd, ok := registry.Lookup("/opinion"); !ok || cmd.Cost != 1 {
+		t.Errorf("Lookup(/opinion) = %v, %v", cmd, ok)
+	}
+	if _, ok := registry.Lookup("missing"); ok {
+		t.Error("Lookup(missing) = true, want false")
+	}
+}
+
+func TestCommandRegistryHelpText(t *testing.T) {
+	handled := ""
+	registry := newTestRegistry(&handled)
+
+	help := registry.HelpText(scopeGroup, false, "")
+	if !strings.Contains(help, "/opinion - Share an opinion (reply to a message)") {
+		t.Errorf("help text missing /opinion line:\n%s", help)
+	}
+	if strings.Contains(help, "/start") {
+		t.Error("group help text should not list private-only commands")
+	}
+	if strings.Contains(help, "/listchats") {
+		t.Error("help text for regular users should not list owner commands")
+	}
+
+	if !strings.Contains(registry.HelpText(scopePrivate, true, ""), "/listchats") {
+		t.Error("owner help text should list owner commands")
+	}
+
+	help = registry.HelpText(scopeGroup, false, "ru")
+	if !strings.HasPrefix(help, "Доступные команды:") || !strings.Contains(help, "/opinion - Мнение о ссылке в сообщении (в ответ на сообщение)") {
+		t.Errorf("Russian help text is not translated:\n%s", help)
+	}
+}
+
+func TestCommandRegistryDispatch(t *testing.T) {
+	groupChat := &tele.Chat{ID: -100, Type: tele.ChatSuperGroup}
+	privateChat := &tele.Chat{ID: 222, Type: tele.ChatPrivate}
+	reply := &tele.Message{ID: 1}
+
+	tests := []struct {
+		name          string
+		command       string
+		chat          *tele.Chat
+		senderID      int64
+		languageCode  string
+		replyTo       *tele.Message
+		expectHandled bool
+		expectedReply string
+	}{
+		{"Reply command with reply", "opinion", groupChat, 222, "", reply, true, ""},
+		{"Reply command without reply", "opinion", groupChat, 222, "", nil, false, "Please use /opinion as a reply to a message"},
+		{"Reply command without reply in Russian", "opinion", groupChat, 222, "ru", nil, false, "Используй /opinion в ответ на сообщение"},
+		{"Private command in group", "start", groupChat, 222, "", nil, false, "/start works only in a private chat with me"},
+		{"Private command in private chat", "start", privateChat, 222, "", nil, true, ""},
+		{"Owner command by owner", "listchats", privateChat, 111, "", nil, true, ""},
+		{"Owner command by user", "listchats", privateChat, 222, "", nil, false, "⛔ This command is only available to the bot owners"},
+		{"Owner command by German user", "listchats", privateChat, 222, "de", nil, false, "⛔ Dieser Befehl ist nur für die Besitzer des Bots verfügbar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			silenceStdout(t)
+			handled := ""
+			registry := newTestRegistry(&handled)
+			cmd, _ := registry.Lookup(tt.command)
+
+			replyMessage := ""
+			mockCtx := &MockContextWithReply{
+				MockContext: MockContext{
+					chat:    tt.chat,
+					sender:  &tele.User{ID: tt.senderID, LanguageCode: tt.languageCode},
+					message: &tele.Message{ID: 2, ReplyTo: tt.replyTo},
+				},
+				replyFunc: func(what interface{}, opts ...interface{}) error {
+					replyMessage = what.(string)
+					return nil
+				},
+			}
+
+			if err := registry.dispatch(cmd)(mockCtx); err != nil {
+				t.Fatalf("dispatch returned error: %v", err)
+			}
+			if (handled == tt.command) != tt.expectHandled {
+				t.Errorf("handler called = %v, want %v", handled == tt.command, tt.expectHandled)
+			}
+			if replyMessage != tt.expectedReply {
+				t.Errorf("reply = %q, want %q", replyMessage, tt.expectedReply)
+			}
+		})
+	}
+}
+
+func TestCommandRegistrySyncMenu(t *testing.T) {
+	handled := ""
+	registry := newTestRegistry(&handled)
+	setter := &fakeMenuSetter{}
+
+	if err := registry.SyncMenu(setter); err != nil {
+		t.Fatalf("SyncMenu returned error: %v", err)
+	}
+
+	groups := setter.calls[tele.CommandScopeAllGroupChats]
+	if len(groups) != 2 || groups[0].Text != "opinion" || groups[0].Description != "Share an opinion" {
+		t.Errorf("group menu = %v, want opinion and help", groups)
+	}
+	if len(setter.calls[tele.CommandScopeAllPrivateChats]) != 3 {
+		t.Errorf("private menu = %v, want 3 commands", setter.calls[tele.CommandScopeAllPrivateChats])
+	}
+	if len(setter.calls[tele.CommandScopeChat+":111"]) != 4 {
+		t.Errorf("owner menu = %v, want 4 commands", setter.calls[tele.CommandScopeChat+":111"])
+	}
+}
+
+func TestCommandRegistrySyncMenuError(t *testing.T) {
+	handled := ""
+	registry := newTestRegistry(&handled)
+
+	if err := registry.SyncMenu(&fakeMenuSetter{err: errors.New("telegram down")}); err == nil {
+		t.Error("SyncMenu() expected error, got nil")
+	}
+}
+
+func TestHandleHelpCommand(t *testing.T) {
+	handled := ""
+	registry := newTestRegistry(&handled)
+
+	replyMessage := ""
+	mockCtx := &MockContextWithReply{
+		MockContext: MockContext{
+			chat:   &tele.Chat{ID: -100, Type: tele.ChatGroup},
+			sender: &tele.User{ID: 222},
+		},
+		replyFunc: func(what interface{}, opts ...interface{}) error {
+			replyMessage = what.(string)
+			return nil
+		},
+	}
+
+	handleHelpCommand(mockCtx, registry)
+
+	if !strings.HasPrefix(replyMessage, "Available commands:") || !strings.Contains(replyMessage, "/help") {
+		t.Errorf("help reply = %q", replyMessage)
+	}
+}
+
+func TestChatScope(t *testing.T) {
+	tests := []struct {
+		chat     *tele.Chat
+		expected commandScope
+	}{
+		{&tele.Chat{Type: tele.ChatPrivate}, scopePrivate},
+		{&tele.Chat{Type: tele.ChatGroup}, scopeGroup},
+		{&tele.Chat{Type: tele.ChatSuperGroup}, scopeGroup},
+		{nil, scopeGroup},
+	}
+
+	for _, tt := range tests {
+		if result := chatScope(tt.chat); result != tt.expected {
+			t.Errorf("chatScope(%v) = %s, want %s", tt.chat, result, tt.expected)
+		}
+	}
+}
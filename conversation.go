@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Limits of follow-up threads on the bot's opinions
+var (
+	conversationMaxTurns = 5
+	conversationTTL      = 24 * time.Hour
+)
+
+// pendingConversationKey stores a new thread in the update context until the answer is sent
+const pendingConversationKey = "conversation"
+
+// Personas used for follow-up answers, so the bot keeps its tone for the whole thread
+var tonePersonas = map[PromptType]string{
+	PromptBullshit: "You are a sarcastic and funny skeptic who thinks the content is a bullshit.",
+	PromptPositive: "You are an encouraging reviewer who highlights the good aspects of the content with solid arguments.",
+	PromptNegative: "You are a critical but constructive reviewer who points out the weaknesses of the content with solid arguments.",
+}
+
+// Instruction added to the persona for follow-up answers
+const followUpPrompt = " You already gave your opinion about the content, and the user replied to it. Answer the reply in the same tone, consistently with your previous answers. Don't write introduction, just answer. Keep the answer short."
+
+// conversationTurn is one message of a thread
+type conversationTurn struct {
+	Role string `json:"role"` // "user" or "model"
+	Text string `json:"text"`
+}
+
+// conversation is a follow-up thread started by an opinion
+type conversation struct {
+	Tone     PromptType         `json:"tone"`
+	Language string             `json:"language,omitempty"` // reply language of the thread
+	Source   string             `json:"source"`             // analyzed URL, text or a description of the media
+	URL      string             `json:"url,omitempty"`      // set when the source is a link, to let the LLM read it again
+	Turns    []conversationTurn `json:"turns"`
+}
+
+// followUpBot is the part of *tele.Bot used to answer follow-ups
+type followUpBot interface {
+	Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error)
+}
+
+// rememberConversation keeps a new thread in the update context; it is saved once the answer is sent
+func rememberConversation(c tele.Context, tone PromptType, source string, url string, answer string) {
+	c.Set(pendingConversationKey, &conversation{
+		Tone:     tone,
+		Language: replyLanguage(c),
+		Source:   source,
+		URL:      url,
+		Turns:    []conversationTurn{{Role: "model", Text: answer}},
+	})
+}
+
+// pendingConversation returns the thread remembered during this update, if any
+func pendingConversation(c tele.Context) *conversation {
+	conv, _ := c.Get(pendingConversationKey).(*conversation)
+	return conv
+}
+
+// conversationKey is the Redis key of the thread continued by replying to the bot message
+func conversationKey(chatID int64, messageID int) string {
+	return redisKey("conversation:%d:%d", chatID, messageID)
+}
+
+// saveConversation stores the thread under the bot message that users reply to
+func saveConversation(ctx context.Context, rdb redis.UniversalClient, chatID int64, messageID int, conv *conversation) error {
+	data, err := json.Marshal(conv)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation: %w", err)
+	}
+	return rdb.Set(ctx, conversationKey(chatID, messageID), data, conversationTTL).Err()
+}
+
+// loadConversation loads the thread of a bot message; it returns nil if there is none or it expired
+func loadConversation(ctx context.Context, rdb redis.UniversalClient, chatID int64, messageID int) (*conversation, error) {
+	data, err := rdb.Get(ctx, conversationKey(chatID, messageID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var conv conversation
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, fmt.Errorf("failed to decode conversation: %w", err)
+	}
+	return &conv, nil
+}
+
+// FollowUps returns the number of user replies in the thread
+func (conv *conversation) FollowUps() int {
+	count := 0
+	for _, turn := range conv.Turns {
+		if turn.Role == "user" {
+			count++
+		}
+	}
+	return count
+}
+
+// contents builds the LLM history: the source, the previous turns and the new question
+func (conv *conversation) contents(question string) []*genai.Content {
+	contents := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{genai.NewPartFromText(conv.Source)}},
+	}
+	for _, turn := range conv.Turns {
+		contents = append(contents, &genai.Content{Role: turn.Role, Parts: []*genai.Part{genai.NewPartFromText(turn.Text)}})
+	}
+	return append(contents, &genai.Content{Role: "user", Parts: []*genai.Part{genai.NewPartFromText(question)}})
+}
+
+// continueConversationWithLLM answers a follow-up question in the tone of the thread
+func continueConversationWithLLM(ctx context.Context, conv *conversation, question string) (string, error) {
+	var tools []*genai.Tool
+	if conv.URL != "" {
+		tools = []*genai.Tool{{URLContext: &genai.URLContext{}}}
+	}
+
+	result, err := runLLMInTone(ctx, conv.Tone, func(tone PromptType) llmRequest {
+		return llmRequest{
+			URL:        conv.URL,
+			Prompt:     tonePersonas[tone] + followUpPrompt,
+			PromptType: "followup_" + string(tone),
+			Language:   conv.Language,
+			Contents:   conv.contents(question),
+			Tools:      tools,
+		}
+	})
+	return result.Text, err
+}
+
+// handleFollowUp answers replies to the bot's opinions, continuing the stored thread.
+// Other messages are ignored.
+func handleFollowUp(c tele.Context, bot followUpBot, botID int64, allowlist *chatAllowlist, excludedUserIDs []int64) error {
+	msg := c.Message()
+	if msg == nil || msg.ReplyTo == nil || msg.ReplyTo.Sender == nil || msg.ReplyTo.Sender.ID != botID || msg.Text == "" {
+		return nil
+	}
+	if !isAllowedChat(c, allowlist) {
+		return nil
+	}
+
+	rdb := activeRedis()
+	if rdb == nil {
+		return nil
+	}
+
+	requestCtx, span := startRequestSpan(c, "followup")
+	defer span.End()
+	// Redis writes finish even when a shutdown cancels the request
+	ctx := context.WithoutCancel(requestCtx)
+
+	conv, err := loadConversation(ctx, rdb, c.Chat().ID, msg.ReplyTo.ID)
+	if err != nil {
+		logJSONContext(ctx, "warn", "Failed to load conversation", map[string]interface{}{
+			"chat":  getChatInfo(c),
+			"error": err.Error(),
+		})
+		return nil
+	}
+	if conv == nil {
+		return nil
+	}
+
+	language := conv.Language
+	if language == "" {
+		language = userLanguage(c)
+	}
+
+	if conv.FollowUps() >= conversationMaxTurns {
+		recordCommand("followup", outcomeRejected)
+		return c.Reply(localize(language, msgConversationLimit))
+	}
+
+	if applyBudget(ctx, rdb, c) == budgetExhausted {
+		recordCommand("followup", outcomeOverBudget)
+		return c.Reply(localize(language, msgBudgetExhausted))
+	}
+
+	var charge *rateLimitCharge
+	userID := c.Sender().ID
+	if !isExcludedUser(userID, excludedUserIDs) {
+		member := fmt.Sprintf("followup:%d:%d", c.Chat().ID, msg.ID)
+		if allowed, count := consumeRateLimit(ctx, rdb, userID, member, 1); !allowed {
+			logJSONContext(ctx, "warn", "Rate limit exceeded", map[string]interface{}{
+				"user":  getUserInfo(c),
+				"chat":  getChatInfo(c),
+				"count": count,
+				"mode":  "followup",
+			})
+			recordCommand("followup", outcomeRateLimited)
+			return c.Reply(localizeCount(language, msgRateLimited, dailyRequestLimit))
+		}
+		charge = &rateLimitCharge{UserID: userID, Member: member, Cost: 1}
+	}
+
+	logJSONContext(ctx, "info", "Processing follow-up", map[string]interface{}{
+		"user":       getUserInfo(c),
+		"chat":       getChatInfo(c),
+		"tone":       conv.Tone,
+		"follow_ups": conv.FollowUps(),
+	})
+
+	var result analysisResult
+	if answer, err := continueConversationWithLLM(requestContext(c), conv, msg.Text); err != nil {
+		result = llmFailed(language, conv.Tone, err, msgTired)
+	} else {
+		result = answered(answer)
+	}
+	// Follow-ups are not marked as answered: the same reply is not asked again
+	result = settleResult(ctx, c, rdb, "followup", result, language, charge, "")
+	if !result.OK() {
+		return c.Reply(result.Answer)
+	}
+
+	_, sendSpan := tracer.Start(ctx, "telegram.sendMessage")
+	sent, err := bot.Send(c.Chat(), result.Answer, &tele.SendOptions{
+		ReplyTo:               msg,
+		DisableWebPagePreview: true,
+	})
+	endSpan(sendSpan, err)
+	if err != nil {
+		logJSONContext(ctx, "error", "Failed to send follow-up", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return err
+	}
+
+	conv.Turns = append(conv.Turns,
+		conversationTurn{Role: "user", Text: msg.Text},
+		conversationTurn{Role: "model", Text: result.Answer},
+	)
+	if err := saveConversation(ctx, rdb, c.Chat().ID, sent.ID, conv); err != nil {
+		logJSONContext(ctx, "warn", "Failed to save conversation", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return nil
+}
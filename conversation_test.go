@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+const testBotID = 999
+
+// MockStoreContext keeps values set during an update, like the real context does
+type MockStoreContext struct {
+	MockContextWithReply
+	store map[string]interface{}
+}
+
+func (m *MockStoreContext) Get(key string) interface{} { return m.store[key] }
+func (m *MockStoreContext) Set(key string, val interface{}) {
+	if m.store == nil {
+		m.store = make(map[string]interface{})
+	}
+	m.store[key] = val
+}
+
+// fakeFollowUpBot records follow-up answers and assigns increasing message IDs
+type fakeFollowUpBot struct {
+	sent   []string
+	nextID int
+}
+
+func (b *fakeFollowUpBot) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	b.sent = append(b.sent, what.(string))
+	b.nextID++
+	return &tele.Message{ID: 500 + b.nextID}, nil
+}
+
+// newFollowUpContext builds a user reply to the bot message with the given ID
+func newFollowUpContext(text string, botMessageID int, reply *string) *MockContextWithReply {
+	return &MockContextWithReply{
+		MockContext: MockContext{
+			chat:   &tele.Chat{ID: -1001234567890, Type: tele.ChatGroup},
+			sender: &tele.User{ID: 123456789, Username: "testuser"},
+			message: &tele.Message{
+				ID:      botMessageID + 1,
+				Text:    text,
+				ReplyTo: &tele.Message{ID: botMessageID, Sender: &tele.User{ID: testBotID}},
+			},
+		},
+		replyFunc: func(what interface{}, opts ...interface{}) error {
+			*reply = what.(string)
+			return nil
+		},
+	}
+}
+
+func TestConversationSaveLoad(t *testing.T) {
+	mr := useMiniredis(t)
+	ctx := context.Background()
+
+	conv := &conversation{Tone: PromptNegative, Source: "https://example.com", URL: "https://example.com", Turns: []conversationTurn{{Role: "model", Text: "Weak."}}}
+	if err := saveConversation(ctx, redisClient, -100, 7, conv); err != nil {
+		t.Fatalf("saveConversation() error: %v", err)
+	}
+	if ttl := mr.TTL("conversation:-100:7"); ttl != conversationTTL {
+		t.Errorf("TTL = %v, want %v", ttl, conversationTTL)
+	}
+
+	loaded, err := loadConversation(ctx, redisClient, -100, 7)
+	if err != nil || loaded == nil {
+		t.Fatalf("loadConversation() = %v, %v", loaded, err)
+	}
+	if loaded.Tone != PromptNegative || loaded.URL != "https://example.com" || len(loaded.Turns) != 1 {
+		t.Errorf("loadConversation() = %+v", loaded)
+	}
+
+	missing, err := loadConversation(ctx, redisClient, -100, 8)
+	if missing != nil || err != nil {
+		t.Errorf("loadConversation() for unknown message = %v, %v, want nil, nil", missing, err)
+	}
+
+	mr.FastForward(conversationTTL + time.Second)
+	if expired, _ := loadConversation(ctx, redisClient, -100, 7); expired != nil {
+		t.Error("conversation should expire after the TTL")
+	}
+}
+
+func TestConversationContents(t *testing.T) {
+	conv := &conversation{
+		Source: "https://example.com",
+		Turns: []conversationTurn{
+			{Role: "model", Text: "Weak."},
+			{Role: "user", Text: "Why?"},
+			{Role: "model", Text: "No sources."},
+		},
+	}
+
+	if conv.FollowUps() != 1 {
+		t.Errorf("FollowUps() = %d, want 1", conv.FollowUps())
+	}
+
+	contents := conv.contents("Really?")
+	var roles []string
+	for _, content := range contents {
+		roles = append(roles, content.Role+":"+content.Parts[0].Text)
+	}
+	expected := "user:https://example.com,model:Weak.,user:Why?,model:No sources.,user:Really?"
+	if strings.Join(roles, ",") != expected {
+		t.Errorf("contents = %s, want %s", strings.Join(roles, ","), expected)
+	}
+}
+
+func TestContinueConversationKeepsPersona(t *testing.T) {
+	silenceStdout(t)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Because it's nonsense.")}}
+	useFakeLLMProvider(t, fake)
+
+	conv := &conversation{Tone: PromptBullshit, Source: "https://example.com", URL: "https://example.com", Turns: []conversationTurn{{Role: "model", Text: "Bullshit."}}}
+	answer, err := continueConversationWithLLM(context.Background(), conv, "why?")
+	if err != nil || answer != "Because it's nonsense." {
+		t.Fatalf("continueConversationWithLLM() = %q, %v", answer, err)
+	}
+
+	system := fake.config.SystemInstruction.Parts[0].Text
+	if !strings.HasPrefix(system, tonePersonas[PromptBullshit]) {
+		t.Errorf("system prompt = %q, want the bullshit persona", system)
+	}
+	if len(fake.config.Tools) != 1 || fake.config.Tools[0].URLContext == nil {
+		t.Error("follow-ups on links should keep URL context")
+	}
+}
+
+func TestHandleFollowUp(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Still weak.")}}
+	useFakeLLMProvider(t, fake)
+
+	ctx := context.Background()
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	saveConversation(ctx, redisClient, -1001234567890, 10, &conversation{
+		Tone:   PromptNegative,
+		Source: "https://example.com",
+		Turns:  []conversationTurn{{Role: "model", Text: "Weak."}},
+	})
+
+	bot := &fakeFollowUpBot{}
+	reply := ""
+	if err := handleFollowUp(newFollowUpContext("why?", 10, &reply), bot, testBotID, allowlist, nil); err != nil {
+		t.Fatalf("handleFollowUp() error: %v", err)
+	}
+	if len(bot.sent) != 1 || bot.sent[0] != "Still weak." {
+		t.Fatalf("sent = %v, want the follow-up answer", bot.sent)
+	}
+
+	// The answer continues the thread with the whole history
+	conv, _ := loadConversation(ctx, redisClient, -1001234567890, 501)
+	if conv == nil || conv.FollowUps() != 1 || conv.Turns[len(conv.Turns)-1].Text != "Still weak." {
+		t.Fatalf("thread after follow-up = %+v", conv)
+	}
+	if len(fake.contents) != 3 || fake.contents[2].Parts[0].Text != "why?" {
+		t.Errorf("LLM history = %d messages, want source, answer and question", len(fake.contents))
+	}
+}
+
+func TestHandleFollowUpRefundsFailures(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	fake := &fakeLLMProvider{err: genai.APIError{Code: http.StatusTooManyRequests}}
+	useFakeLLMProvider(t, fake)
+	saveConversation(context.Background(), redisClient, -1001234567890, 10, &conversation{Tone: PromptNegative, Source: "text", Turns: []conversationTurn{{Role: "model", Text: "Weak."}}})
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+
+	quota := counterDelta(commandsTotal.WithLabelValues("followup", outcomeLLMError))
+	bot := &fakeFollowUpBot{}
+	reply := ""
+	handleFollowUp(newFollowUpContext("why?", 10, &reply), bot, testBotID, allowlist, nil)
+	if reply != localize("en", msgLLMQuota) || len(bot.sent) != 0 {
+		t.Errorf("reply = %q, sent = %v, want the quota notice", reply, bot.sent)
+	}
+	if members, _ := mr.ZMembers("ratelimit:123456789"); len(members) != 0 {
+		t.Errorf("rate limit entries = %v, want the follow-up refunded", members)
+	}
+	if got := quota(); got != 1 {
+		t.Errorf("followup llm_error = %v, want 1", got)
+	}
+
+	// Answered follow-ups keep their charge and count as successes
+	fake.err = nil
+	fake.chunks = []*genai.GenerateContentResponse{textChunk("Still weak.")}
+	success := counterDelta(commandsTotal.WithLabelValues("followup", outcomeSuccess))
+	handleFollowUp(newFollowUpContext("why?", 10, &reply), bot, testBotID, allowlist, nil)
+	if members, _ := mr.ZMembers("ratelimit:123456789"); len(members) != 1 || len(bot.sent) != 1 {
+		t.Errorf("rate limit entries = %v, sent = %v, want one answered follow-up", members, bot.sent)
+	}
+	if got := success(); got != 1 {
+		t.Errorf("followup success = %v, want 1", got)
+	}
+}
+
+func TestHandleFollowUpTurnLimit(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+
+	turns := []conversationTurn{{Role: "model", Text: "Weak."}}
+	for i := 0; i < conversationMaxTurns; i++ {
+		turns = append(turns, conversationTurn{Role: "user", Text: "why?"}, conversationTurn{Role: "model", Text: "because"})
+	}
+	saveConversation(context.Background(), redisClient, -1001234567890, 10, &conversation{Tone: PromptNegative, Source: "text", Turns: turns})
+
+	bot := &fakeFollowUpBot{}
+	reply := ""
+	handleFollowUp(newFollowUpContext("and now?", 10, &reply), bot, testBotID, newChatAllowlist([]int64{-1001234567890}), nil)
+	if reply != "Let's stop here, reply to another message with /opinion to start over 🙂" {
+		t.Errorf("reply = %q, want the turn limit notice", reply)
+	}
+	if len(bot.sent) != 0 {
+		t.Errorf("sent = %v, want nothing", bot.sent)
+	}
+}
+
+func TestHandleFollowUpIgnoredMessages(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{err: errors.New("should not be called")})
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+
+	reply := ""
+	notToBot := newFollowUpContext("why?", 10, &reply)
+	notToBot.message.ReplyTo.Sender = &tele.User{ID: 1}
+	noThread := newFollowUpContext("why?", 11, &reply)
+	otherChat := newFollowUpContext("why?", 10, &reply)
+	otherChat.chat = &tele.Chat{ID: -200, Type: tele.ChatGroup}
+	plain := newFollowUpContext("hello", 10, &reply)
+	plain.message.ReplyTo = nil
+
+	for name, c := range map[string]*MockContextWithReply{"reply to a user": notToBot, "no thread": noThread, "other chat": otherChat, "not a reply": plain} {
+		bot := &fakeFollowUpBot{}
+		if err := handleFollowUp(c, bot, testBotID, allowlist, nil); err != nil {
+			t.Errorf("%s: handleFollowUp() error: %v", name, err)
+		}
+		if len(bot.sent) != 0 || reply != "" {
+			t.Errorf("%s: expected no answer, got %v %q", name, bot.sent, reply)
+		}
+	}
+}
+
+func TestOpinionModeRemembersConversation(t *testing.T) {
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Nice link.")}})
+
+	mockCtx := &MockStoreContext{MockContextWithReply: *newAnalysisMockContext(nil, new(string))}
+	result := opinionMode(nil).Process(mockCtx, "look https://example.com")
+	if !result.OK() {
+		t.Fatalf("Process() = %q, %s", result.Answer, result.Category)
+	}
+
+	conv := pendingConversation(mockCtx)
+	if conv == nil || conv.URL != "https://example.com" || conv.Source != "https://example.com" || conv.Turns[0].Text != "Nice link." {
+		t.Fatalf("pending conversation = %+v", conv)
+	}
+	if _, ok := tonePersonas[conv.Tone]; !ok {
+		t.Errorf("conversation tone %q has no persona", conv.Tone)
+	}
+
+	// Refusals don't start a thread
+	refused := &MockStoreContext{MockContextWithReply: *newAnalysisMockContext(nil, new(string))}
+	opinionMode(nil).Process(refused, "no link here")
+	if pendingConversation(refused) != nil {
+		t.Error("refusal should not start a conversation")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	tele "gopkg.in/telebot.v3"
+)
+
+// documentMaxBytes is the largest document downloaded for analysis
+var documentMaxBytes int64 = 10 << 20
+
+// documentMaxChars is how much of the extracted text is sent to the LLM
+const documentMaxChars = 20000
+
+// documentKind is how the text of a document is extracted
+type documentKind string
+
+const (
+	documentPDF  documentKind = "pdf"
+	documentText documentKind = "text"
+	documentHTML documentKind = "html"
+)
+
+var errDocumentNoText = errors.New("document has no text")
+
+// replyDocument is a document attached to the replied message
+type replyDocument struct {
+	File     tele.File
+	Kind     documentKind
+	FileName string
+	Caption  string
+}
+
+var (
+	htmlDropRegex = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlTagRegex  = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacesRegex   = regexp.MustCompile(`[ \t\r\f\v]+`)
+	newlinesRegex = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// findReplyDocument returns the PDF or text-like document of the message
+func findReplyDocument(msg *tele.Message) (replyDocument, bool) {
+	if msg == nil || msg.Document == nil {
+		return replyDocument{}, false
+	}
+
+	kind, ok := documentKindOf(msg.Document.MIME, msg.Document.FileName)
+	if !ok {
+		return replyDocument{}, false
+	}
+	return replyDocument{
+		File:     msg.Document.File,
+		Kind:     kind,
+		FileName: msg.Document.FileName,
+		Caption:  msg.Caption,
+	}, true
+}
+
+// documentKindOf detects the document kind from its MIME type, falling back to the file extension
+func documentKindOf(mimeType string, fileName string) (documentKind, bool) {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0])) {
+	case "application/pdf":
+		return documentPDF, true
+	case "text/html":
+		return documentHTML, true
+	case "text/plain", "text/markdown", "text/x-markdown":
+		return documentText, true
+	}
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".pdf":
+		return documentPDF, true
+	case ".html", ".htm":
+		return documentHTML, true
+	case ".txt", ".md", ".markdown":
+		return documentText, true
+	}
+	return "", false
+}
+
+// extractDocumentText extracts plain text from the document content
+func extractDocumentText(data []byte, kind documentKind) (string, error) {
+	var text string
+	switch kind {
+	case documentPDF:
+		content, err := extractPDFText(data)
+		if err != nil {
+			return "", err
+		}
+		text = content
+	case documentHTML:
+		text = htmlDropRegex.ReplaceAllString(string(data), " ")
+		text = html.UnescapeString(htmlTagRegex.ReplaceAllString(text, "\n"))
+	case documentText:
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("document is not valid UTF-8")
+		}
+		text = string(data)
+	default:
+		return "", fmt.Errorf("unsupported document kind %q", kind)
+	}
+
+	text = normalizeDocumentText(text)
+	if text == "" {
+		return "", errDocumentNoText
+	}
+	return text, nil
+}
+
+// extractPDFText extracts the plain text of all PDF pages.
+// The PDF library panics on some malformed files, so panics are turned into errors.
+func extractPDFText(data []byte) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to parse PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open PDF: %w", err)
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("failed to extract PDF text: %w", err)
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract PDF text: %w", err)
+	}
+	return string(content), nil
+}
+
+// normalizeDocumentText collapses runs of spaces and blank lines
+func normalizeDocumentText(text string) string {
+	lines := strings.Split(spacesRegex.ReplaceAllString(text, " "), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(newlinesRegex.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// trimDocumentText cuts the text to maxChars characters, marking the cut
+func trimDocumentText(text string, maxChars int) string {
+	if utf8.RuneCountInString(text) <= maxChars {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:maxChars]) + "\n[...]"
+}
+
+// fetchDocumentText downloads the document and extracts its text
+func fetchDocumentText(c tele.Context, doc replyDocument) (string, error) {
+	data, err := downloadLimited(c, &doc.File, documentMaxBytes)
+	if err != nil {
+		return "", err
+	}
+	return extractDocumentText(data, doc.Kind)
+}
+
+// getDocumentOpinion downloads the replied document, extracts its text and returns an opinion about it
+// Returns the opinion, or the reply and category of the failure
+func getDocumentOpinion(c tele.Context, doc replyDocument, tone PromptType, language string) analysisResult {
+	text, err := fetchDocumentText(c, doc)
+	if err != nil {
+		logJSONContext(requestContext(c), "warn", "Document rejected", map[string]interface{}{
+			"user":      getUserInfo(c),
+			"chat":      getChatInfo(c),
+			"file_name": doc.FileName,
+			"file_size": doc.File.FileSize,
+			"kind":      doc.Kind,
+			"error":     err.Error(),
+		})
+		switch {
+		case errors.Is(err, errFileTooLarge):
+			return failed(resultTooLarge, localize(language, msgDocumentTooLarge, documentMaxBytes>>20), err)
+		case errors.Is(err, errDocumentNoText):
+			return failed(resultNoText, localize(language, msgDocumentNoText), err)
+		default:
+			return failed(resultFetchFailed, localize(language, msgDocumentReadFailed), err)
+		}
+	}
+
+	analysis, err := analyzeDocumentWithLLM(requestContext(c), trimDocumentText(text, documentMaxChars), doc.FileName, doc.Caption, tone, language)
+	if err != nil {
+		return llmFailed(language, tone, err, msgTired)
+	}
+
+	return answered(analysis)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// readFixture reads a file from testdata
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+	return data
+}
+
+func TestDocumentKindOf(t *testing.T) {
+	tests := []struct {
+		mime     string
+		fileName string
+		expected documentKind
+		ok       bool
+	}{
+		{"application/pdf", "paper.pdf", documentPDF, true},
+		{"application/octet-stream", "paper.PDF", documentPDF, true},
+		{"text/plain; charset=utf-8", "notes", documentText, true},
+		{"", "README.md", documentText, true},
+		{"text/html", "page", documentHTML, true},
+		{"", "page.htm", documentHTML, true},
+		{"application/zip", "archive.zip", "", false},
+		{"image/png", "screenshot.png", "", false},
+	}
+
+	for _, tt := range tests {
+		kind, ok := documentKindOf(tt.mime, tt.fileName)
+		if kind != tt.expected || ok != tt.ok {
+			t.Errorf("documentKindOf(%q, %q) = %q, %v, want %q, %v", tt.mime, tt.fileName, kind, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestFindReplyDocument(t *testing.T) {
+	msg := &tele.Message{
+		Document: &tele.Document{File: tele.File{FileID: "doc"}, MIME: "application/pdf", FileName: "paper.pdf"},
+		Caption:  "thoughts?",
+	}
+	doc, ok := findReplyDocument(msg)
+	if !ok || doc.Kind != documentPDF || doc.FileName != "paper.pdf" || doc.Caption != "thoughts?" {
+		t.Errorf("findReplyDocument() = %+v, %v", doc, ok)
+	}
+
+	if _, ok := findReplyDocument(&tele.Message{Text: "hello"}); ok {
+		t.Error("findReplyDocument() found a document in a text message")
+	}
+}
+
+func TestExtractDocumentTextFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		kind    documentKind
+	}{
+		{"sample.pdf", documentPDF},
+		{"sample.txt", documentText},
+		{"sample.md", documentText},
+		{"sample.html", documentHTML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			text, err := extractDocumentText(readFixture(t, tt.fixture), tt.kind)
+			if err != nil {
+				t.Fatalf("extractDocumentText() error: %v", err)
+			}
+			if !strings.Contains(text, "Remote work makes teams") || !strings.Contains(text, "Offices are obsolete.") {
+				t.Errorf("extracted text = %q", text)
+			}
+			if strings.Contains(text, "  ") || strings.Contains(text, "\n\n\n") {
+				t.Errorf("extracted text is not normalized: %q", text)
+			}
+		})
+	}
+}
+
+func TestExtractDocumentTextHTMLDropsMarkup(t *testing.T) {
+	text, err := extractDocumentText(readFixture(t, "sample.html"), documentHTML)
+	if err != nil {
+		t.Fatalf("extractDocumentText() error: %v", err)
+	}
+	for _, unwanted := range []string{"<p>", "console.log", "color: red", "Ignored title"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("extracted text contains %q: %q", unwanted, text)
+		}
+	}
+	if !strings.Contains(text, "more productive & happier") {
+		t.Errorf("entities are not unescaped: %q", text)
+	}
+}
+
+func TestExtractDocumentTextErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		kind documentKind
+	}{
+		{"Broken PDF", []byte("%PDF-1.4 garbage"), documentPDF},
+		{"Invalid UTF-8", []byte{0xff, 0xfe, 0xfd}, documentText},
+		{"Empty text", []byte(" \n\t "), documentText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := extractDocumentText(tt.data, tt.kind); err == nil {
+				t.Error("extractDocumentText() expected error, got nil")
+			}
+		})
+	}
+
+	if _, err := extractDocumentText([]byte("<p> </p>"), documentHTML); !errors.Is(err, errDocumentNoText) {
+		t.Errorf("empty HTML error = %v, want errDocumentNoText", err)
+	}
+}
+
+func TestTrimDocumentText(t *testing.T) {
+	if trimDocumentText("short", 10) != "short" {
+		t.Error("trimDocumentText() changed a short text")
+	}
+	if result := trimDocumentText(strings.Repeat("ы", 20), 5); result != "ыыыыы\n[...]" {
+		t.Errorf("trimDocumentText() = %q", result)
+	}
+}
+
+func TestFetchDocumentText(t *testing.T) {
+	calls := useFakeDownload(t, readFixture(t, "sample.pdf"), nil)
+
+	text, err := fetchDocumentText(nil, replyDocument{Kind: documentPDF})
+	if err != nil || !strings.Contains(text, "Offices are obsolete.") {
+		t.Errorf("fetchDocumentText() = %q, %v", text, err)
+	}
+
+	// Declared size over the cap is rejected before downloading
+	_, err = fetchDocumentText(nil, replyDocument{File: tele.File{FileSize: documentMaxBytes + 1}, Kind: documentPDF})
+	if !errors.Is(err, errFileTooLarge) {
+		t.Errorf("fetchDocumentText() error = %v, want errFileTooLarge", err)
+	}
+	if *calls != 1 {
+		t.Errorf("downloads = %d, want 1", *calls)
+	}
+}
+
+func TestGetDocumentOpinion(t *testing.T) {
+	silenceStdout(t)
+	useFakeDownload(t, readFixture(t, "sample.md"), nil)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Bold claims about offices.")}}
+	useFakeLLMProvider(t, fake)
+
+	mockCtx := newAnalysisMockContext(nil, new(string))
+	result := getDocumentOpinion(mockCtx, replyDocument{Kind: documentText, FileName: "remote.md"}, PromptPositive, "")
+	if !result.OK() || result.Answer != "Bold claims about offices." {
+		t.Fatalf("getDocumentOpinion() = %q, %s", result.Answer, result.Category)
+	}
+	if sent := fake.contents[0].Parts[0].Text; !strings.HasPrefix(sent, `Document "remote.md":`) || !strings.Contains(sent, "Offices are obsolete.") {
+		t.Errorf("sent text = %q", sent)
+	}
+}
+
+func TestGetDocumentOpinionErrors(t *testing.T) {
+	silenceStdout(t)
+	mockCtx := newAnalysisMockContext(nil, new(string))
+
+	tests := []struct {
+		name        string
+		doc         replyDocument
+		content     []byte
+		downloadErr error
+		category    resultCategory
+		expected    string
+	}{
+		{"Too large", replyDocument{File: tele.File{FileSize: 50 << 20}, Kind: documentPDF}, nil, nil, resultTooLarge, "This document is too big for me, the limit is 10 MB 🐘"},
+		{"No text", replyDocument{Kind: documentText}, []byte("   "), nil, resultNoText, "I couldn't find any text in this document 🤷"},
+		{"Download failed", replyDocument{Kind: documentPDF}, nil, errors.New("telegram down"), resultFetchFailed, "I couldn't read this document, try again later 😴"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeDownload(t, tt.content, tt.downloadErr)
+			result := getDocumentOpinion(mockCtx, tt.doc, PromptPositive, "")
+			if result.Category != tt.category || result.Answer != tt.expected {
+				t.Errorf("getDocumentOpinion() = %q, %s, want %q, %s", result.Answer, result.Category, tt.expected, tt.category)
+			}
+		})
+	}
+}
+
+func TestHandleOpinionCommandDocumentReply(t *testing.T) {
+	silenceStdout(t)
+	useFakeDownload(t, readFixture(t, "sample.txt"), nil)
+	useFakeLLMProvider(t, &fakeLLMProvider{err: errors.New("boom")})
+
+	reply := ""
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	mockCtx.message.ReplyTo = &tele.Message{ID: 41, Document: &tele.Document{MIME: "text/plain", FileName: "notes.txt"}}
+
+	if err := handleOpinionCommand(mockCtx, newChatAllowlist([]int64{-1001234567890}), nil, 1, nil); err != nil {
+		t.Fatalf("handleOpinionCommand returned error: %v", err)
+	}
+	if reply != "I'm tired dude, next time 😴" {
+		t.Errorf("reply = %q, want the LLM failure instead of 'no text'", reply)
+	}
+}
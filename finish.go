@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Classes of LLM answers stopped before their end, matched with errors.Is
+var (
+	errLLMBlocked    = errors.New("blocked by the safety filters")
+	errLLMRecitation = errors.New("stopped for reciting its sources")
+	errLLMTruncated  = errors.New("cut off at the output token limit")
+	errLLMStopped    = errors.New("stopped early")
+)
+
+// Failures of the URL context tool to read the analyzed link
+var (
+	errURLUnsafe      = errors.New("refused as unsafe")
+	errURLUnavailable = errors.New("could not be retrieved")
+)
+
+// llmFinishError is returned when the prompt is blocked or the model stops for
+// another reason than the end of its answer
+type llmFinishError struct {
+	Reason     string   // finish reason of the answer, or block reason of the prompt
+	Prompt     bool     // the prompt was blocked before anything was generated
+	Categories []string // harm categories that caused a block
+	Partial    string   // text streamed before the stop
+}
+
+func (e *llmFinishError) Error() string {
+	what := "answer"
+	if e.Prompt {
+		what = "prompt"
+	}
+	msg := fmt.Sprintf("LLM %s %s (%s)", what, e.Unwrap(), e.Reason)
+	if len(e.Categories) > 0 {
+		msg += ": " + strings.Join(e.Categories, ", ")
+	}
+	return msg
+}
+
+// Unwrap returns the class of the finish reason
+func (e *llmFinishError) Unwrap() error {
+	if e.Prompt {
+		return errLLMBlocked
+	}
+	switch genai.FinishReason(e.Reason) {
+	case genai.FinishReasonSafety, genai.FinishReasonBlocklist, genai.FinishReasonProhibitedContent,
+		genai.FinishReasonSPII, genai.FinishReasonImageSafety, genai.FinishReasonImageProhibitedContent:
+		return errLLMBlocked
+	case genai.FinishReasonRecitation, genai.FinishReasonImageRecitation:
+		return errLLMRecitation
+	case genai.FinishReasonMaxTokens:
+		return errLLMTruncated
+	default:
+		return errLLMStopped
+	}
+}
+
+// MetricLabel is the reason reported by brm_llm_finish_reasons_total
+func (e *llmFinishError) MetricLabel() string {
+	if e.Prompt {
+		return "prompt_" + strings.ToLower(e.Reason)
+	}
+	return strings.ToLower(e.Reason)
+}
+
+// finishError checks how the stream ended: nil when the prompt was accepted and the
+// answer was complete, or when the model did not report a reason
+func finishError(feedback *genai.GenerateContentResponsePromptFeedback, candidate *genai.Candidate, partial string) *llmFinishError {
+	if feedback != nil && feedback.BlockReason != "" && feedback.BlockReason != genai.BlockedReasonUnspecified {
+		return &llmFinishError{
+			Reason:     string(feedback.BlockReason),
+			Prompt:     true,
+			Categories: blockedCategories(feedback.SafetyRatings),
+			Partial:    partial,
+		}
+	}
+	if candidate == nil {
+		return nil
+	}
+	switch candidate.FinishReason {
+	case "", genai.FinishReasonUnspecified, genai.FinishReasonStop:
+		return nil
+	}
+	return &llmFinishError{
+		Reason:     string(candidate.FinishReason),
+		Categories: blockedCategories(candidate.SafetyRatings),
+		Partial:    partial,
+	}
+}
+
+// blockedCategories lists the harm categories whose rating blocked the content
+func blockedCategories(ratings []*genai.SafetyRating) []string {
+	var categories []string
+	for _, rating := range ratings {
+		if rating != nil && rating.Blocked {
+			categories = append(categories, strings.ToLower(strings.TrimPrefix(string(rating.Category), "HARM_CATEGORY_")))
+		}
+	}
+	return categories
+}
+
+// safetyRetry enables a second attempt in a softer tone when the safety filters block an opinion
+var safetyRetry = false
+
+// softerTones is the tone tried after a safety block; the positive tone has none
+var softerTones = map[PromptType]PromptType{
+	PromptBullshit: PromptNegative,
+	PromptNegative: PromptPositive,
+}
+
+// runLLMInTone runs the request built for the tone. When the answer is blocked by
+// the safety filters and SAFETY_RETRY is on, it is retried once in the softer tone;
+// blocked prompts are not retried since the content itself was refused.
+func runLLMInTone(ctx context.Context, tone PromptType, build func(tone PromptType) llmRequest) (llmResult, error) {
+	result, err := runLLM(ctx, build(tone))
+
+	var finishErr *llmFinishError
+	softer, ok := softerTones[tone]
+	if !safetyRetry || !ok || !errors.As(err, &finishErr) || finishErr.Prompt || !errors.Is(err, errLLMBlocked) {
+		return result, err
+	}
+
+	logJSONContext(ctx, "info", "Retrying blocked answer in a softer tone", map[string]interface{}{
+		"tone":       tone,
+		"retry_tone": softer,
+		"reason":     finishErr.Reason,
+		"categories": finishErr.Categories,
+	})
+	return runLLM(ctx, build(softer))
+}
+
+// urlRetrievalError is returned when the URL context tool could not read the analyzed link
+type urlRetrievalError struct {
+	URL    string
+	Status genai.URLRetrievalStatus
+}
+
+func (e *urlRetrievalError) Error() string {
+	return fmt.Sprintf("URL %s %s (%s)", e.URL, e.Unwrap(), e.Status)
+}
+
+// Unwrap returns the class of the retrieval status
+func (e *urlRetrievalError) Unwrap() error {
+	if e.Status == genai.URLRetrievalStatusUnsafe {
+		return errURLUnsafe
+	}
+	return errURLUnavailable
+}
+
+// retrievalError checks the URL context statuses of a request about url: nil when a
+// retrieval succeeded or none was reported
+func retrievalError(url string, statuses []genai.URLRetrievalStatus) *urlRetrievalError {
+	var err *urlRetrievalError
+	for _, status := range statuses {
+		switch status {
+		case genai.URLRetrievalStatusSuccess:
+			return nil
+		case genai.URLRetrievalStatusUnsafe, genai.URLRetrievalStatusError, genai.URLRetrievalStatusPaywall:
+			if err == nil || status == genai.URLRetrievalStatusUnsafe {
+				err = &urlRetrievalError{URL: url, Status: status}
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// stoppedChunk builds a final streamed chunk with text, a finish reason and safety ratings
+func stoppedChunk(text string, reason genai.FinishReason, blocked ...genai.HarmCategory) *genai.GenerateContentResponse {
+	chunk := textChunk(text)
+	if text == "" {
+		chunk.Candidates[0].Content = nil
+	}
+	chunk.Candidates[0].FinishReason = reason
+	for _, category := range blocked {
+		chunk.Candidates[0].SafetyRatings = append(chunk.Candidates[0].SafetyRatings, &genai.SafetyRating{Category: category, Blocked: true})
+	}
+	return chunk
+}
+
+func TestFinishError(t *testing.T) {
+	tests := []struct {
+		name      string
+		feedback  *genai.GenerateContentResponsePromptFeedback
+		candidate *genai.Candidate
+		class     error
+		label     string
+	}{
+		{"no reason", nil, &genai.Candidate{}, nil, ""},
+		{"stop", nil, &genai.Candidate{FinishReason: genai.FinishReasonStop}, nil, ""},
+		{"safety", nil, &genai.Candidate{FinishReason: genai.FinishReasonSafety}, errLLMBlocked, "safety"},
+		{"prohibited", nil, &genai.Candidate{FinishReason: genai.FinishReasonProhibitedContent}, errLLMBlocked, "prohibited_content"},
+		{"recitation", nil, &genai.Candidate{FinishReason: genai.FinishReasonRecitation}, errLLMRecitation, "recitation"},
+		{"max tokens", nil, &genai.Candidate{FinishReason: genai.FinishReasonMaxTokens}, errLLMTruncated, "max_tokens"},
+		{"other", nil, &genai.Candidate{FinishReason: genai.FinishReasonMalformedFunctionCall}, errLLMStopped, "malformed_function_call"},
+		{"prompt blocked", &genai.GenerateContentResponsePromptFeedback{BlockReason: genai.BlockedReasonSafety}, nil, errLLMBlocked, "prompt_safety"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := finishError(tt.feedback, tt.candidate, "")
+			if tt.class == nil {
+				if err != nil {
+					t.Errorf("finishError() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !errors.Is(err, tt.class) {
+				t.Fatalf("finishError() = %v, want %v", err, tt.class)
+			}
+			if got := err.MetricLabel(); got != tt.label {
+				t.Errorf("MetricLabel() = %q, want %q", got, tt.label)
+			}
+		})
+	}
+}
+
+func TestRunLLMFinishReasons(t *testing.T) {
+	silenceStdout(t)
+	fake := &fakeLLMProvider{}
+	useFakeLLMProvider(t, fake)
+
+	blocked := counterDelta(llmFinishReasonsTotal.WithLabelValues(llmModel, "negative", "safety"))
+	fake.chunks = []*genai.GenerateContentResponse{stoppedChunk("", genai.FinishReasonSafety, genai.HarmCategoryHarassment)}
+	_, err := runLLM(context.Background(), llmRequest{PromptType: "negative"})
+	var finishErr *llmFinishError
+	if !errors.As(err, &finishErr) || !errors.Is(err, errLLMBlocked) {
+		t.Fatalf("runLLM() error = %v, want a safety block", err)
+	}
+	if len(finishErr.Categories) != 1 || finishErr.Categories[0] != "harassment" {
+		t.Errorf("blocked categories = %v", finishErr.Categories)
+	}
+	if got := blocked(); got != 1 {
+		t.Errorf("safety finish reasons = %v, want 1", got)
+	}
+
+	// A truncated answer is an error, not a silently cut answer
+	fake.chunks = []*genai.GenerateContentResponse{textChunk("The first half"), stoppedChunk(" of the", genai.FinishReasonMaxTokens)}
+	result, err := runLLM(context.Background(), llmRequest{PromptType: "tldr_short"})
+	if !errors.Is(err, errLLMTruncated) || result.Text != "" {
+		t.Fatalf("runLLM() = %q, %v, want a truncation error", result.Text, err)
+	}
+	errors.As(err, &finishErr)
+	if finishErr.Partial != "The first half of the" {
+		t.Errorf("partial answer = %q", finishErr.Partial)
+	}
+
+	fake.chunks = []*genai.GenerateContentResponse{{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{BlockReason: genai.BlockedReasonProhibitedContent}}}
+	if _, err := runLLM(context.Background(), llmRequest{PromptType: "factcheck"}); !errors.As(err, &finishErr) || !finishErr.Prompt {
+		t.Errorf("runLLM() error = %v, want a blocked prompt", err)
+	}
+
+	stop := counterDelta(llmFinishReasonsTotal.WithLabelValues(llmModel, "factcheck", "stop"))
+	fake.chunks = []*genai.GenerateContentResponse{textChunk("All true"), stoppedChunk("", genai.FinishReasonStop)}
+	if result, err := runLLM(context.Background(), llmRequest{PromptType: "factcheck"}); err != nil || result.Text != "All true" {
+		t.Errorf("runLLM() = %q, %v", result.Text, err)
+	}
+	if got := stop(); got != 1 {
+		t.Errorf("stop finish reasons = %v, want 1", got)
+	}
+}
+
+func TestRunLLMInToneSafetyRetry(t *testing.T) {
+	silenceStdout(t)
+	fake := &fakeLLMProvider{}
+	useFakeLLMProvider(t, fake)
+	build := func(tone PromptType) llmRequest {
+		return llmRequest{Prompt: textPrompts[tone], PromptType: "text_" + string(tone)}
+	}
+	blockedOnce := func() {
+		fake.calls = 0
+		fake.queued = [][]*genai.GenerateContentResponse{{stoppedChunk("", genai.FinishReasonSafety)}}
+		fake.chunks = []*genai.GenerateContentResponse{textChunk("Not great, but fine")}
+	}
+
+	blockedOnce()
+	if _, err := runLLMInTone(context.Background(), PromptBullshit, build); !errors.Is(err, errLLMBlocked) || fake.calls != 1 {
+		t.Errorf("without SAFETY_RETRY: error %v after %d calls, want the block after 1", err, fake.calls)
+	}
+
+	original := safetyRetry
+	safetyRetry = true
+	t.Cleanup(func() { safetyRetry = original })
+
+	blockedOnce()
+	result, err := runLLMInTone(context.Background(), PromptBullshit, build)
+	if err != nil || result.Text != "Not great, but fine" || fake.calls != 2 {
+		t.Fatalf("retry = %q, %v after %d calls", result.Text, err, fake.calls)
+	}
+	if prompt := fake.config.SystemInstruction.Parts[0].Text; !strings.HasPrefix(prompt, textPrompts[PromptNegative]) {
+		t.Errorf("retry prompt = %q, want the negative tone", prompt)
+	}
+
+	// The positive tone has nothing softer
+	blockedOnce()
+	if _, err := runLLMInTone(context.Background(), PromptPositive, build); !errors.Is(err, errLLMBlocked) || fake.calls != 1 {
+		t.Errorf("positive tone: error %v after %d calls", err, fake.calls)
+	}
+}
+
+func TestGetSummaryTruncated(t *testing.T) {
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{stoppedChunk("Half a sum", genai.FinishReasonMaxTokens)}})
+
+	result := getSummary(context.Background(), "https://example.com/article", SummaryShort, "en")
+	if result.Category != resultTruncated || result.Answer != localize("en", msgTruncated) {
+		t.Errorf("getSummary() = %q, %s, want the truncation notice", result.Answer, result.Category)
+	}
+}
+
+func TestRunLLMURLRetrieval(t *testing.T) {
+	silenceStdout(t)
+	fake := &fakeLLMProvider{}
+	useFakeLLMProvider(t, fake)
+	retrieved := func(text string, statuses ...genai.URLRetrievalStatus) *genai.GenerateContentResponse {
+		chunk := textChunk(text)
+		metadata := &genai.URLContextMetadata{}
+		for _, status := range statuses {
+			metadata.URLMetadata = append(metadata.URLMetadata, &genai.URLMetadata{RetrievedURL: "https://example.com", URLRetrievalStatus: status})
+		}
+		chunk.Candidates[0].URLContextMetadata = metadata
+		return chunk
+	}
+	req := llmRequest{PromptType: "negative", URL: "https://example.com"}
+
+	fake.chunks = []*genai.GenerateContentResponse{retrieved("", genai.URLRetrievalStatusError, genai.URLRetrievalStatusUnsafe)}
+	if _, err := runLLM(context.Background(), req); !errors.Is(err, errURLUnsafe) {
+		t.Errorf("runLLM() error = %v, want an unsafe URL", err)
+	}
+
+	fake.chunks = []*genai.GenerateContentResponse{retrieved("", genai.URLRetrievalStatusPaywall)}
+	if _, err := runLLM(context.Background(), req); !errors.Is(err, errURLUnavailable) {
+		t.Errorf("runLLM() error = %v, want an unavailable URL", err)
+	}
+
+	// An answer the model gave without reading the link is kept
+	fake.chunks = []*genai.GenerateContentResponse{retrieved("Judging by the headline, weak", genai.URLRetrievalStatusPaywall)}
+	if result := processURLInTone(context.Background(), "https://example.com", PromptNegative, "en"); result.Category != resultOK || result.Answer != "Judging by the headline, weak" {
+		t.Errorf("processURLInTone() = %q, %s, want the answer despite the paywall", result.Answer, result.Category)
+	}
+
+	// A retrieval that succeeded on a retry is enough
+	fake.chunks = []*genai.GenerateContentResponse{retrieved("Read it", genai.URLRetrievalStatusError, genai.URLRetrievalStatusSuccess)}
+	if result, err := runLLM(context.Background(), req); err != nil || result.Text != "Read it" {
+		t.Errorf("runLLM() = %q, %v", result.Text, err)
+	}
+}
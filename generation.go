@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// generationSettings are the generation parameters of an LLM request.
+// Unset fields are inherited from the less specific settings.
+type generationSettings struct {
+	Model           string            `json:"model,omitempty"`
+	ThinkingBudget  *int32            `json:"thinking_budget,omitempty"` // -1 lets the model decide
+	Temperature     *float32          `json:"temperature,omitempty"`
+	TopP            *float32          `json:"top_p,omitempty"`
+	MaxOutputTokens *int32            `json:"max_output_tokens,omitempty"`
+	Safety          map[string]string `json:"safety,omitempty"` // harm category -> block threshold
+}
+
+// Commands with their own generation settings; opinions cover links, texts, images,
+// documents and recordings
+var generationCommands = map[string][]string{
+	"opinion":   {string(PromptBullshit), string(PromptPositive), string(PromptNegative)},
+	"followup":  {string(PromptBullshit), string(PromptPositive), string(PromptNegative)},
+	"tldr":      {string(SummaryShort), string(SummaryMedium), string(SummaryBullets)},
+	"factcheck": nil,
+}
+
+// Safety categories and thresholds accepted in the settings
+var harmCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+	"civic_integrity":   genai.HarmCategoryCivicIntegrity,
+}
+
+var harmThresholds = map[string]genai.HarmBlockThreshold{
+	"block_low_and_above":    genai.HarmBlockThresholdBlockLowAndAbove,
+	"block_medium_and_above": genai.HarmBlockThresholdBlockMediumAndAbove,
+	"block_only_high":        genai.HarmBlockThresholdBlockOnlyHigh,
+	"block_none":             genai.HarmBlockThresholdBlockNone,
+	"off":                    genai.HarmBlockThresholdOff,
+}
+
+// llmSettings are the configured settings by "default", command ("tldr") or
+// command and tone or length ("opinion.negative", "tldr.bullets")
+var llmSettings = map[string]generationSettings{}
+
+// loadGenerationConfig reads the settings from the JSON file in LLM_SETTINGS_FILE, if set
+func loadGenerationConfig() (map[string]generationSettings, error) {
+	path := os.Getenv("LLM_SETTINGS_FILE")
+	if path == "" {
+		return map[string]generationSettings{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM_SETTINGS_FILE: %w", err)
+	}
+	return parseGenerationSettings(data)
+}
+
+// parseGenerationSettings decodes and validates the settings by key
+func parseGenerationSettings(data []byte) (map[string]generationSettings, error) {
+	settings := map[string]generationSettings{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("invalid LLM settings: %w", err)
+	}
+
+	for key, s := range settings {
+		if !validGenerationKey(key) {
+			return nil, fmt.Errorf("unknown LLM settings key %q, want default, a command or command.variant", key)
+		}
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("invalid LLM settings %q: %w", key, err)
+		}
+	}
+	return settings, nil
+}
+
+// validGenerationKey checks that key names default, a command or one of its variants
+func validGenerationKey(key string) bool {
+	if key == "default" {
+		return true
+	}
+	command, variant, hasVariant := strings.Cut(key, ".")
+	variants, ok := generationCommands[command]
+	if !ok || !hasVariant {
+		return ok
+	}
+	for _, v := range variants {
+		if v == variant {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the ranges accepted by the API
+func (s generationSettings) validate() error {
+	if s.ThinkingBudget != nil && *s.ThinkingBudget < -1 {
+		return fmt.Errorf("thinking_budget %d, want -1 or more", *s.ThinkingBudget)
+	}
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+		return fmt.Errorf("temperature %v, want 0 to 2", *s.Temperature)
+	}
+	if s.TopP != nil && (*s.TopP < 0 || *s.TopP > 1) {
+		return fmt.Errorf("top_p %v, want 0 to 1", *s.TopP)
+	}
+	if s.MaxOutputTokens != nil && *s.MaxOutputTokens <= 0 {
+		return fmt.Errorf("max_output_tokens %d, want a positive number", *s.MaxOutputTokens)
+	}
+	for category, threshold := range s.Safety {
+		if _, ok := harmCategories[category]; !ok {
+			return fmt.Errorf("unknown safety category %q", category)
+		}
+		if _, ok := harmThresholds[threshold]; !ok {
+			return fmt.Errorf("unknown safety threshold %q for %s", threshold, category)
+		}
+	}
+	return nil
+}
+
+// merge overlays the fields set in other
+func (s generationSettings) merge(other generationSettings) generationSettings {
+	if other.Model != "" {
+		s.Model = other.Model
+	}
+	if other.ThinkingBudget != nil {
+		s.ThinkingBudget = other.ThinkingBudget
+	}
+	if other.Temperature != nil {
+		s.Temperature = other.Temperature
+	}
+	if other.TopP != nil {
+		s.TopP = other.TopP
+	}
+	if other.MaxOutputTokens != nil {
+		s.MaxOutputTokens = other.MaxOutputTokens
+	}
+	if len(other.Safety) > 0 {
+		safety := make(map[string]string, len(s.Safety)+len(other.Safety))
+		for category, threshold := range s.Safety {
+			safety[category] = threshold
+		}
+		for category, threshold := range other.Safety {
+			safety[category] = threshold
+		}
+		s.Safety = safety
+	}
+	return s
+}
+
+// generationKey returns the command and the tone or length of a prompt type,
+// e.g. "image_negative" is an opinion in the negative tone
+func generationKey(promptType string) (string, string) {
+	if promptType == "factcheck" {
+		return "factcheck", ""
+	}
+	for _, command := range []string{"tldr", "followup"} {
+		if variant, ok := strings.CutPrefix(promptType, command+"_"); ok {
+			return command, variant
+		}
+	}
+	for _, media := range []string{"text_", "image_", "document_", "audio_"} {
+		promptType = strings.TrimPrefix(promptType, media)
+	}
+	return "opinion", promptType
+}
+
+// resolveGenerationSettings returns the settings of a prompt type: the built-in defaults,
+// overlaid with the default, command and command.variant settings
+func resolveGenerationSettings(promptType string) generationSettings {
+	resolved := generationSettings{Model: llmModel, ThinkingBudget: genai.Ptr(int32(1024))}
+	command, variant := generationKey(promptType)
+	for _, key := range []string{"default", command, command + "." + variant} {
+		if s, ok := llmSettings[key]; ok {
+			resolved = resolved.merge(s)
+		}
+	}
+	return resolved
+}
+
+// apply sets the sampling settings on the request config
+func (s generationSettings) apply(config *genai.GenerateContentConfig) {
+	config.ThinkingConfig = &genai.ThinkingConfig{ThinkingBudget: s.ThinkingBudget}
+	config.Temperature = s.Temperature
+	config.TopP = s.TopP
+	if s.MaxOutputTokens != nil {
+		config.MaxOutputTokens = *s.MaxOutputTokens
+	}
+
+	categories := make([]string, 0, len(s.Safety))
+	for category := range s.Safety {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		config.SafetySettings = append(config.SafetySettings, &genai.SafetySetting{
+			Category:  harmCategories[category],
+			Threshold: harmThresholds[s.Safety[category]],
+		})
+	}
+}
+
+// logFields returns the fields set in s for log entries
+func (s generationSettings) logFields() map[string]interface{} {
+	fields := map[string]interface{}{}
+	if s.Model != "" {
+		fields["model"] = s.Model
+	}
+	if s.ThinkingBudget != nil {
+		fields["thinking_budget"] = *s.ThinkingBudget
+	}
+	if s.Temperature != nil {
+		fields["temperature"] = *s.Temperature
+	}
+	if s.TopP != nil {
+		fields["top_p"] = *s.TopP
+	}
+	if s.MaxOutputTokens != nil {
+		fields["max_output_tokens"] = *s.MaxOutputTokens
+	}
+	if len(s.Safety) > 0 {
+		fields["safety"] = s.Safety
+	}
+	return fields
+}
+
+// generationSettingsLog returns the configured settings by key for the startup log
+func generationSettingsLog(settings map[string]generationSettings) map[string]interface{} {
+	fields := make(map[string]interface{}, len(settings))
+	for key, s := range settings {
+		fields[key] = s.logFields()
+	}
+	return fields
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// useLLMSettings replaces the generation settings for the duration of the test
+func useLLMSettings(t *testing.T, settings map[string]generationSettings) {
+	t.Helper()
+	original := llmSettings
+	llmSettings = settings
+	t.Cleanup(func() { llmSettings = original })
+}
+
+func TestParseGenerationSettings(t *testing.T) {
+	settings, err := parseGenerationSettings([]byte(`{
+		"default": {"temperature": 1, "safety": {"harassment": "block_only_high"}},
+		"tldr": {"model": "gemini-flash-lite-latest", "thinking_budget": 0, "max_output_tokens": 512},
+		"opinion.negative": {"top_p": 0.9, "thinking_budget": -1}
+	}`))
+	if err != nil {
+		t.Fatalf("parseGenerationSettings() error: %v", err)
+	}
+	if got := settings["tldr"]; got.Model != "gemini-flash-lite-latest" || *got.ThinkingBudget != 0 || *got.MaxOutputTokens != 512 {
+		t.Errorf("tldr settings = %+v", got)
+	}
+	if got := settings["opinion.negative"]; *got.TopP != 0.9 || *got.ThinkingBudget != -1 {
+		t.Errorf("opinion.negative settings = %+v", got)
+	}
+
+	for _, invalid := range []string{
+		`{"default": {"temperature": 2.5}}`,
+		`{"default": {"top_p": -0.1}}`,
+		`{"default": {"thinking_budget": -2}}`,
+		`{"default": {"max_output_tokens": 0}}`,
+		`{"default": {"temprature": 1}}`,
+		`{"default": {"safety": {"violence": "block_none"}}}`,
+		`{"default": {"safety": {"harassment": "block_all"}}}`,
+		`{"summary": {"temperature": 1}}`,
+		`{"opinion.neutral": {"temperature": 1}}`,
+		`{"factcheck.short": {"temperature": 1}}`,
+		`[]`,
+	} {
+		if _, err := parseGenerationSettings([]byte(invalid)); err == nil {
+			t.Errorf("parseGenerationSettings(%s) should fail", invalid)
+		}
+	}
+}
+
+func TestLoadGenerationConfig(t *testing.T) {
+	t.Setenv("LLM_SETTINGS_FILE", "")
+	if settings, err := loadGenerationConfig(); err != nil || len(settings) != 0 {
+		t.Errorf("loadGenerationConfig() without a file = %v, %v", settings, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "llm.json")
+	if err := os.WriteFile(path, []byte(`{"factcheck": {"temperature": 0.2}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LLM_SETTINGS_FILE", path)
+	settings, err := loadGenerationConfig()
+	if err != nil {
+		t.Fatalf("loadGenerationConfig() error: %v", err)
+	}
+	if got := settings["factcheck"].Temperature; got == nil || *got != 0.2 {
+		t.Errorf("factcheck temperature = %v, want 0.2", got)
+	}
+
+	t.Setenv("LLM_SETTINGS_FILE", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := loadGenerationConfig(); err == nil {
+		t.Error("loadGenerationConfig() with a missing file should fail")
+	}
+}
+
+func TestGenerationKey(t *testing.T) {
+	tests := []struct {
+		promptType string
+		command    string
+		variant    string
+	}{
+		{"negative", "opinion", "negative"},
+		{"image_bullshit", "opinion", "bullshit"},
+		{"audio_positive", "opinion", "positive"},
+		{"tldr_bullets", "tldr", "bullets"},
+		{"followup_negative", "followup", "negative"},
+		{"factcheck", "factcheck", ""},
+	}
+	for _, tt := range tests {
+		command, variant := generationKey(tt.promptType)
+		if command != tt.command || variant != tt.variant {
+			t.Errorf("generationKey(%q) = %q, %q, want %q, %q", tt.promptType, command, variant, tt.command, tt.variant)
+		}
+	}
+}
+
+func TestResolveGenerationSettings(t *testing.T) {
+	useLLMSettings(t, map[string]generationSettings{
+		"default":          {Temperature: genai.Ptr(float32(1)), Safety: map[string]string{"harassment": "block_only_high"}},
+		"opinion":          {MaxOutputTokens: genai.Ptr(int32(800))},
+		"opinion.negative": {Temperature: genai.Ptr(float32(1.5)), Safety: map[string]string{"hate_speech": "block_none"}},
+	})
+
+	got := resolveGenerationSettings("document_negative")
+	if got.Model != llmModel || *got.ThinkingBudget != 1024 {
+		t.Errorf("built-in defaults = %q, %d", got.Model, *got.ThinkingBudget)
+	}
+	if *got.Temperature != 1.5 || *got.MaxOutputTokens != 800 {
+		t.Errorf("temperature %v, max tokens %v, want the tone over the command over the default", *got.Temperature, *got.MaxOutputTokens)
+	}
+	if len(got.Safety) != 2 || got.Safety["harassment"] != "block_only_high" || got.Safety["hate_speech"] != "block_none" {
+		t.Errorf("safety = %v, want both categories", got.Safety)
+	}
+
+	got = resolveGenerationSettings("tldr_short")
+	if *got.Temperature != 1 || got.MaxOutputTokens != nil || len(got.Safety) != 1 {
+		t.Errorf("tldr settings = %+v, want only the default", got)
+	}
+	if len(llmSettings["default"].Safety) != 1 {
+		t.Error("resolveGenerationSettings() modified the configured safety settings")
+	}
+}
+
+func TestRunLLMUsesGenerationSettings(t *testing.T) {
+	buf := useLogger(t, loggingConfig{Level: slog.LevelInfo, Format: "json"})
+	useLLMSettings(t, map[string]generationSettings{
+		"factcheck": {
+			Model:           "gemini-pro-latest",
+			ThinkingBudget:  genai.Ptr(int32(-1)),
+			Temperature:     genai.Ptr(float32(0.2)),
+			TopP:            genai.Ptr(float32(0.8)),
+			MaxOutputTokens: genai.Ptr(int32(2048)),
+			Safety:          map[string]string{"dangerous_content": "block_low_and_above", "harassment": "off"},
+		},
+	})
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Checked")}}
+	useFakeLLMProvider(t, fake)
+
+	if _, err := runLLM(context.Background(), llmRequest{PromptType: "factcheck"}); err != nil {
+		t.Fatalf("runLLM() error: %v", err)
+	}
+	if fake.model != "gemini-pro-latest" {
+		t.Errorf("model = %q", fake.model)
+	}
+	config := fake.config
+	if *config.ThinkingConfig.ThinkingBudget != -1 || *config.Temperature != 0.2 || *config.TopP != 0.8 || config.MaxOutputTokens != 2048 {
+		t.Errorf("config = thinking %d, temperature %v, top_p %v, max tokens %d", *config.ThinkingConfig.ThinkingBudget, *config.Temperature, *config.TopP, config.MaxOutputTokens)
+	}
+	if len(config.SafetySettings) != 2 ||
+		*config.SafetySettings[0] != (genai.SafetySetting{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockThresholdBlockLowAndAbove}) ||
+		*config.SafetySettings[1] != (genai.SafetySetting{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockThresholdOff}) {
+		t.Errorf("safety settings = %v", config.SafetySettings)
+	}
+
+	var logged map[string]interface{}
+	for _, entry := range logLines(t, buf) {
+		if entry["message"] == "Starting LLM analysis" {
+			logged = entry
+		}
+	}
+	if logged["model"] != "gemini-pro-latest" || logged["thinking_budget"] != float64(-1) || logged["max_output_tokens"] != float64(2048) || logged["safety"] == nil {
+		t.Errorf("logged settings = %v", logged)
+	}
+
+	if _, err := runLLM(context.Background(), llmRequest{PromptType: "negative"}); err != nil {
+		t.Fatalf("runLLM() error: %v", err)
+	}
+	if fake.model != llmModel || fake.config.Temperature != nil || fake.config.SafetySettings != nil {
+		t.Errorf("other commands use %q with temperature %v and safety %v", fake.model, fake.config.Temperature, fake.config.SafetySettings)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Build information, set with -ldflags "-X main.buildVersion=... -X main.buildCommit=... -X main.buildDate=..."
+var (
+	buildVersion = "dev"
+	buildCommit  = ""
+	buildDate    = ""
+)
+
+// adminListen is the address of the health and admin HTTP server, configured with ADMIN_LISTEN
+var adminListen = ":8080"
+
+// healthCheckTimeout bounds each readiness check
+var healthCheckTimeout = 3 * time.Second
+
+// processStart is used to report the uptime
+var processStart = time.Now()
+
+// componentCheck verifies that a dependency of the bot is usable
+type componentCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// componentStatus is the result of one check in the /readyz response
+type componentStatus struct {
+	Status    string `json:"status"` // "ok" or "error"
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// readinessChecks returns the checks behind /readyz: Telegram, the Redis store and the LLM provider
+func readinessChecks(bot *tele.Bot) []componentCheck {
+	return []componentCheck{
+		{Name: "telegram", Check: func(ctx context.Context) error {
+			_, err := bot.Raw("getMe", nil)
+			return err
+		}},
+		{Name: "store", Check: func(ctx context.Context) error {
+			if redisClient == nil {
+				return errors.New("not configured")
+			}
+			return redisClient.Ping(ctx).Err()
+		}},
+		{Name: "provider", Check: func(ctx context.Context) error {
+			_, err := newLLMProvider(ctx)
+			return err
+		}},
+	}
+}
+
+// runChecks runs the checks in parallel and reports whether all of them passed
+func runChecks(ctx context.Context, checks []componentCheck) (map[string]componentStatus, bool) {
+	results := make(map[string]componentStatus, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check componentCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := runCheck(checkCtx, check)
+			status := componentStatus{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = "error"
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[check.Name] = status
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, status := range results {
+		if status.Status != "ok" {
+			ready = false
+		}
+	}
+	return results, ready
+}
+
+// runCheck runs a check, giving up when ctx expires even if the check ignores it
+func runCheck(ctx context.Context, check componentCheck) error {
+	done := make(chan error, 1)
+	go func() { done <- check.Check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+}
+
+// versionInfo describes the running build
+func versionInfo() map[string]string {
+	info := map[string]string{
+		"version":    buildVersion,
+		"commit":     buildCommit,
+		"build_date": buildDate,
+		"go_version": runtime.Version(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info["commit"] == "":
+				info["commit"] = setting.Value
+			case setting.Key == "vcs.time" && info["build_date"] == "":
+				info["build_date"] = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// newAdminMux serves /healthz (the process is alive), /readyz (dependencies are
+// usable), /version (build information) and /metrics (Prometheus)
+func newAdminMux(checks []componentCheck) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":         "ok",
+			"uptime_seconds": int64(time.Since(processStart).Seconds()),
+		})
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		results, ready := runChecks(r.Context(), checks)
+		status, code := "ok", http.StatusOK
+		switch {
+		case inflight.Draining():
+			status, code = "shutting_down", http.StatusServiceUnavailable
+		case !ready:
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]interface{}{
+			"status": status,
+			"checks": results,
+		})
+	})
+
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, versionInfo())
+	})
+
+	mux.Handle("/metrics", metricsHandler())
+
+	return mux
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logJSON("warn", "Failed to write JSON response", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// startAdminServer listens on addr and serves handler in the background
+func startAdminServer(addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	server := &http.Server{
+		Addr:              listener.Addr().String(),
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logJSON("error", "Admin server stopped", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	logJSON("info", "Admin server started", map[string]interface{}{
+		"listen": server.Addr,
+	})
+	return server, nil
+}
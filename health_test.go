@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// readyzResponse is the body of /readyz
+type readyzResponse struct {
+	Status string                     `json:"status"`
+	Checks map[string]componentStatus `json:"checks"`
+}
+
+// getAdmin requests path from the admin mux and decodes the JSON body into v
+func getAdmin(t *testing.T, handler http.Handler, path string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s Content-Type = %q, want application/json", path, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s returned invalid JSON %q: %v", path, rec.Body.String(), err)
+	}
+	return rec.Code
+}
+
+func passingCheck(name string) componentCheck {
+	return componentCheck{Name: name, Check: func(ctx context.Context) error { return nil }}
+}
+
+func TestHealthzIsAlwaysOK(t *testing.T) {
+	failing := componentCheck{Name: "store", Check: func(ctx context.Context) error { return errors.New("down") }}
+	mux := newAdminMux([]componentCheck{failing})
+
+	var body map[string]interface{}
+	if code := getAdmin(t, mux, "/healthz", &body); code != http.StatusOK {
+		t.Errorf("/healthz status = %d, want 200", code)
+	}
+	if body["status"] != "ok" {
+		t.Errorf("/healthz status field = %v, want ok", body["status"])
+	}
+	if _, ok := body["uptime_seconds"]; !ok {
+		t.Error("/healthz should report uptime_seconds")
+	}
+}
+
+func TestReadyzAllChecksPass(t *testing.T) {
+	useRequestTracker(t)
+	mux := newAdminMux([]componentCheck{passingCheck("telegram"), passingCheck("store"), passingCheck("provider")})
+
+	var body readyzResponse
+	if code := getAdmin(t, mux, "/readyz", &body); code != http.StatusOK {
+		t.Errorf("/readyz status = %d, want 200", code)
+	}
+	if body.Status != "ok" {
+		t.Errorf("status = %q, want ok", body.Status)
+	}
+	for _, name := range []string{"telegram", "store", "provider"} {
+		if body.Checks[name].Status != "ok" {
+			t.Errorf("check %s = %+v, want ok", name, body.Checks[name])
+		}
+	}
+}
+
+func TestReadyzReportsFailingCheck(t *testing.T) {
+	useRequestTracker(t)
+	failing := componentCheck{Name: "store", Check: func(ctx context.Context) error { return errors.New("connection refused") }}
+	mux := newAdminMux([]componentCheck{passingCheck("telegram"), failing})
+
+	var body readyzResponse
+	if code := getAdmin(t, mux, "/readyz", &body); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz status = %d, want 503", code)
+	}
+	if body.Status != "unavailable" {
+		t.Errorf("status = %q, want unavailable", body.Status)
+	}
+	if got := body.Checks["store"]; got.Status != "error" || got.Error != "connection refused" {
+		t.Errorf("store check = %+v, want the error", got)
+	}
+	if body.Checks["telegram"].Status != "ok" {
+		t.Errorf("telegram check = %+v, want ok", body.Checks["telegram"])
+	}
+}
+
+func TestReadyzTimesOutHangingCheck(t *testing.T) {
+	useRequestTracker(t)
+	original := healthCheckTimeout
+	healthCheckTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { healthCheckTimeout = original })
+
+	release := make(chan struct{})
+	defer close(release)
+	hanging := componentCheck{Name: "provider", Check: func(ctx context.Context) error {
+		<-release // ignores ctx on purpose
+		return nil
+	}}
+	mux := newAdminMux([]componentCheck{hanging})
+
+	start := time.Now()
+	var body readyzResponse
+	if code := getAdmin(t, mux, "/readyz", &body); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz status = %d, want 503", code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("/readyz took %v, want it bounded by the check timeout", elapsed)
+	}
+	if got := body.Checks["provider"]; got.Status != "error" || !strings.Contains(got.Error, "timed out") {
+		t.Errorf("provider check = %+v, want a timeout", got)
+	}
+}
+
+func TestReadyzWhileShuttingDown(t *testing.T) {
+	tracker := useRequestTracker(t)
+	tracker.Drain(0, 0)
+	mux := newAdminMux([]componentCheck{passingCheck("telegram")})
+
+	var body readyzResponse
+	if code := getAdmin(t, mux, "/readyz", &body); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz status = %d, want 503", code)
+	}
+	if body.Status != "shutting_down" {
+		t.Errorf("status = %q, want shutting_down", body.Status)
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	originalVersion, originalCommit := buildVersion, buildCommit
+	buildVersion, buildCommit = "1.2.3", "abc123"
+	t.Cleanup(func() { buildVersion, buildCommit = originalVersion, originalCommit })
+
+	var body map[string]string
+	if code := getAdmin(t, newAdminMux(nil), "/version", &body); code != http.StatusOK {
+		t.Errorf("/version status = %d, want 200", code)
+	}
+	if body["version"] != "1.2.3" || body["commit"] != "abc123" {
+		t.Errorf("/version = %v, want the build variables", body)
+	}
+	if !strings.HasPrefix(body["go_version"], "go") {
+		t.Errorf("go_version = %q, want the Go runtime version", body["go_version"])
+	}
+}
+
+func TestReadinessChecks(t *testing.T) {
+	mr := useMiniredis(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{})
+
+	var telegramUp atomic.Bool
+	telegramUp.Store(true)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !telegramUp.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"ok":false,"error_code":401,"description":"Unauthorized"}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"brm"}}`)
+	}))
+	defer api.Close()
+
+	bot, err := tele.NewBot(tele.Settings{Token: "test-token", URL: api.URL, Offline: true})
+	if err != nil {
+		t.Fatalf("NewBot() error: %v", err)
+	}
+	checks := readinessChecks(bot)
+
+	results, ready := runChecks(context.Background(), checks)
+	if !ready {
+		t.Fatalf("runChecks() ready = false with all dependencies up: %+v", results)
+	}
+
+	telegramUp.Store(false)
+	mr.Close()
+	newLLMProvider = func(ctx context.Context) (llmProvider, error) {
+		return nil, errors.New("GOOGLE_API_KEY is not set")
+	}
+
+	results, ready = runChecks(context.Background(), checks)
+	if ready {
+		t.Fatal("runChecks() ready = true with all dependencies down")
+	}
+	for _, name := range []string{"telegram", "store", "provider"} {
+		if results[name].Status != "error" || results[name].Error == "" {
+			t.Errorf("check %s = %+v, want an error", name, results[name])
+		}
+	}
+}
+
+func TestStartAdminServer(t *testing.T) {
+	silenceStdout(t)
+	server, err := startAdminServer("127.0.0.1:0", newAdminMux(nil))
+	if err != nil {
+		t.Fatalf("startAdminServer() error: %v", err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz status = %d, want 200", resp.StatusCode)
+	}
+
+	if _, err := startAdminServer("bad address", newAdminMux(nil)); err == nil {
+		t.Error("startAdminServer() with an invalid address should fail")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v3"
+)
+
+// imageMaxBytes is the largest image downloaded for analysis
+var imageMaxBytes int64 = 10 << 20
+
+// supportedImageTypes are the image formats accepted by the multimodal model
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+var errImageUnsupported = errors.New("unsupported image type")
+
+// replyImage is an image attached to the replied message
+type replyImage struct {
+	File    tele.File
+	MIME    string // declared MIME type, verified again after download
+	Caption string
+}
+
+// downloadFile fetches a file through the Bot API file endpoint
+var downloadFile = func(c tele.Context, file *tele.File) (io.ReadCloser, error) {
+	return c.Bot().File(file)
+}
+
+var errFileTooLarge = errors.New("file is too large")
+
+// downloadLimited downloads a file, failing with errFileTooLarge if it exceeds maxBytes
+func downloadLimited(c tele.Context, file *tele.File, maxBytes int64) (data []byte, err error) {
+	if file.FileSize > maxBytes {
+		return nil, errFileTooLarge
+	}
+
+	_, span := tracer.Start(requestContext(c), "telegram.downloadFile", trace.WithAttributes(
+		attribute.Int64("telegram.file.size", file.FileSize),
+	))
+	defer func() { endSpan(span, err) }()
+
+	reader, err := downloadFile(c, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer reader.Close()
+
+	data, err = io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
+
+// findReplyImage returns the photo or image document of the message.
+// Telegram sends several photo sizes; the largest one is kept when the message is decoded.
+func findReplyImage(msg *tele.Message) (replyImage, bool) {
+	if msg == nil {
+		return replyImage{}, false
+	}
+	if msg.Photo != nil {
+		return replyImage{File: msg.Photo.File, MIME: "image/jpeg", Caption: msg.Caption}, true
+	}
+	if msg.Document != nil && strings.HasPrefix(msg.Document.MIME, "image/") {
+		return replyImage{File: msg.Document.File, MIME: msg.Document.MIME, Caption: msg.Caption}, true
+	}
+	return replyImage{}, false
+}
+
+// downloadImage downloads the image, enforcing the size limit and checking
+// that the content is one of the supported formats. It returns the data and its MIME type.
+func downloadImage(c tele.Context, image replyImage) ([]byte, string, error) {
+	if !supportedImageTypes[image.MIME] {
+		return nil, "", errImageUnsupported
+	}
+
+	data, err := downloadLimited(c, &image.File, imageMaxBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Don't trust the declared type; HEIC is not sniffed, so it is accepted as declared
+	mimeType := http.DetectContentType(data)
+	if !supportedImageTypes[mimeType] {
+		if image.MIME != "image/heic" && image.MIME != "image/heif" {
+			return nil, "", errImageUnsupported
+		}
+		mimeType = image.MIME
+	}
+
+	return data, mimeType, nil
+}
+
+// getImageOpinion downloads the replied image and returns an opinion about it
+// Returns the opinion, or the reply and category of the failure
+func getImageOpinion(c tele.Context, image replyImage, tone PromptType, language string) analysisResult {
+	data, mimeType, err := downloadImage(c, image)
+	if err != nil {
+		logJSONContext(requestContext(c), "warn", "Image rejected", map[string]interface{}{
+			"user":      getUserInfo(c),
+			"chat":      getChatInfo(c),
+			"file_size": image.File.FileSize,
+			"mime":      image.MIME,
+			"error":     err.Error(),
+		})
+		switch {
+		case errors.Is(err, errFileTooLarge):
+			return failed(resultTooLarge, localize(language, msgImageTooLarge, imageMaxBytes>>20), err)
+		case errors.Is(err, errImageUnsupported):
+			return failed(resultUnsupported, localize(language, msgImageUnsupported), err)
+		default:
+			return failed(resultFetchFailed, localize(language, msgImageDownloadFailed), err)
+		}
+	}
+
+	analysis, err := analyzeImageWithLLM(requestContext(c), data, mimeType, image.Caption, tone, language)
+	if err != nil {
+		return llmFailed(language, tone, err, msgTired)
+	}
+
+	return answered(analysis)
+}
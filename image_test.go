@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// pngBytes encodes a tiny PNG image
+func pngBytes(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.White)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// useFakeDownload serves data (or err) for every file download during the test
+func useFakeDownload(t *testing.T, data []byte, err error) *int {
+	t.Helper()
+	calls := 0
+	original := downloadFile
+	downloadFile = func(c tele.Context, file *tele.File) (io.ReadCloser, error) {
+		calls++
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	t.Cleanup(func() { downloadFile = original })
+	return &calls
+}
+
+func TestFindReplyImage(t *testing.T) {
+	photo := &tele.Photo{File: tele.File{FileID: "photo", FileSize: 100}}
+	imageDoc := &tele.Document{File: tele.File{FileID: "doc"}, MIME: "image/png"}
+	pdfDoc := &tele.Document{File: tele.File{FileID: "pdf"}, MIME: "application/pdf"}
+
+	tests := []struct {
+		name     string
+		msg      *tele.Message
+		expected string
+		mime     string
+	}{
+		{"Photo", &tele.Message{Photo: photo, Caption: "look"}, "photo", "image/jpeg"},
+		{"Image document", &tele.Message{Document: imageDoc}, "doc", "image/png"},
+		{"Other document", &tele.Message{Document: pdfDoc}, "", ""},
+		{"Text", &tele.Message{Text: "hello"}, "", ""},
+		{"Nil", nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image, ok := findReplyImage(tt.msg)
+			if ok != (tt.expected != "") {
+				t.Fatalf("findReplyImage() ok = %v", ok)
+			}
+			if image.File.FileID != tt.expected || image.MIME != tt.mime {
+				t.Errorf("findReplyImage() = %+v, want %s %s", image, tt.expected, tt.mime)
+			}
+		})
+	}
+}
+
+func TestDownloadImage(t *testing.T) {
+	data := pngBytes(t)
+
+	tests := []struct {
+		name         string
+		image        replyImage
+		content      []byte
+		expectedErr  error
+		expectedMIME string
+		downloads    int
+	}{
+		{"Valid PNG", replyImage{MIME: "image/png"}, data, nil, "image/png", 1},
+		{"Declared JPEG is sniffed as PNG", replyImage{MIME: "image/jpeg"}, data, nil, "image/png", 1},
+		{"Declared size too large", replyImage{File: tele.File{FileSize: 11 << 20}, MIME: "image/png"}, data, errFileTooLarge, "", 0},
+		{"Actual size too large", replyImage{MIME: "image/png"}, append(data, make([]byte, 10<<20)...), errFileTooLarge, "", 1},
+		{"Unsupported declared type", replyImage{MIME: "image/gif"}, data, errImageUnsupported, "", 0},
+		{"Content is not an image", replyImage{MIME: "image/png"}, []byte("<html>nope</html>"), errImageUnsupported, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := useFakeDownload(t, tt.content, nil)
+
+			result, mimeType, err := downloadImage(nil, tt.image)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("downloadImage() error = %v, want %v", err, tt.expectedErr)
+			}
+			if mimeType != tt.expectedMIME {
+				t.Errorf("mime = %q, want %q", mimeType, tt.expectedMIME)
+			}
+			if err == nil && !bytes.Equal(result, tt.content) {
+				t.Error("downloadImage() returned different data")
+			}
+			if *calls != tt.downloads {
+				t.Errorf("downloads = %d, want %d", *calls, tt.downloads)
+			}
+		})
+	}
+}
+
+func TestGetImageOpinion(t *testing.T) {
+	silenceStdout(t)
+	useFakeDownload(t, pngBytes(t), nil)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Nice screenshot, weak argument.")}}
+	useFakeLLMProvider(t, fake)
+
+	mockCtx := newAnalysisMockContext(nil, new(string))
+	result := getImageOpinion(mockCtx, replyImage{MIME: "image/jpeg", Caption: "what do you think"}, PromptPositive, "")
+	if !result.OK() || result.Answer != "Nice screenshot, weak argument." {
+		t.Fatalf("getImageOpinion() = %q, %s", result.Answer, result.Category)
+	}
+
+	parts := fake.contents[0].Parts
+	if len(parts) != 2 || parts[0].InlineData == nil || parts[0].InlineData.MIMEType != "image/png" {
+		t.Errorf("expected an inline PNG part followed by the caption, got %+v", parts)
+	}
+	if parts[1].Text != "what do you think" {
+		t.Errorf("caption part = %q", parts[1].Text)
+	}
+}
+
+func TestGetImageOpinionErrors(t *testing.T) {
+	silenceStdout(t)
+	mockCtx := newAnalysisMockContext(nil, new(string))
+
+	tests := []struct {
+		name        string
+		image       replyImage
+		downloadErr error
+		category    resultCategory
+		expected    string
+	}{
+		{"Too large", replyImage{File: tele.File{FileSize: 50 << 20}, MIME: "image/png"}, nil, resultTooLarge, "This image is too big for me, the limit is 10 MB 🐘"},
+		{"Unsupported", replyImage{MIME: "image/gif"}, nil, resultUnsupported, "I can only look at JPEG, PNG, WebP or HEIC images 🖼"},
+		{"Download failed", replyImage{MIME: "image/png"}, errors.New("telegram down"), resultFetchFailed, "I couldn't download the image, try again later 😴"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeDownload(t, nil, tt.downloadErr)
+			result := getImageOpinion(mockCtx, tt.image, PromptPositive, "")
+			if result.Category != tt.category || result.Answer != tt.expected {
+				t.Errorf("getImageOpinion() = %q, %s, want %q, %s", result.Answer, result.Category, tt.expected, tt.category)
+			}
+		})
+	}
+}
+
+func TestHandleOpinionCommandPhotoReply(t *testing.T) {
+	silenceStdout(t)
+	calls := useFakeDownload(t, nil, errors.New("telegram down"))
+
+	reply := ""
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	mockCtx.message.ReplyTo = &tele.Message{ID: 41, Photo: &tele.Photo{File: tele.File{FileID: "photo"}}}
+
+	if err := handleOpinionCommand(mockCtx, newChatAllowlist([]int64{-1001234567890}), nil, 1, nil); err != nil {
+		t.Fatalf("handleOpinionCommand returned error: %v", err)
+	}
+	if *calls != 1 {
+		t.Errorf("downloads = %d, want 1", *calls)
+	}
+	if !strings.Contains(reply, "download the image") {
+		t.Errorf("reply = %q, want download error instead of 'no text'", reply)
+	}
+
+	// Modes without image support keep asking for text
+	handleTLDRCommand(mockCtx, newChatAllowlist([]int64{-1001234567890}), nil, 1, SummaryShort)
+	if reply != "The replied message has no text to analyze" {
+		t.Errorf("/tldr reply = %q, want no text", reply)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v3"
+)
+
+// defaultLanguage is used for bot-side strings when no language is known
+const defaultLanguage = "en"
+
+// replyLanguageKey stores the language of the current request in the update context
+const replyLanguageKey = "language"
+
+// languageNames are the languages the LLM can be asked to answer in, by ISO 639-1 code
+var languageNames = map[string]string{
+	"en": "English",
+	"de": "German",
+	"fr": "French",
+	"es": "Spanish",
+	"it": "Italian",
+	"pt": "Portuguese",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"ru": "Russian",
+	"uk": "Ukrainian",
+	"el": "Greek",
+	"ar": "Arabic",
+	"he": "Hebrew",
+	"zh": "Chinese",
+	"ja": "Japanese",
+	"ko": "Korean",
+}
+
+// Common words used to tell Latin-script languages apart
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "of", "to", "in", "that", "this", "with", "for", "you", "it", "not", "was"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "auf", "für", "ich", "sie", "zu", "den"},
+	"fr": {"le", "la", "les", "et", "est", "une", "des", "pas", "que", "pour", "dans", "avec", "sur", "qui", "ce"},
+	"es": {"el", "los", "las", "es", "una", "por", "para", "con", "que", "del", "pero", "como", "muy", "está", "y"},
+	"it": {"il", "lo", "gli", "una", "che", "non", "per", "con", "sono", "della", "di", "è", "questo", "anche", "ma"},
+	"pt": {"o", "os", "uma", "não", "que", "com", "para", "por", "mais", "como", "mas", "do", "da", "é", "isso"},
+	"nl": {"de", "het", "een", "en", "is", "niet", "van", "dat", "met", "voor", "op", "ik", "zijn", "maar", "ook"},
+	"pl": {"i", "w", "nie", "na", "się", "jest", "że", "to", "z", "do", "jak", "ale", "tak", "czy", "co"},
+}
+
+// minDetectLetters is the number of letters needed to guess a language
+const minDetectLetters = 12
+
+// fixedChatLanguages forces the reply language in some chats, configured with CHAT_LANGUAGES
+var fixedChatLanguages = map[int64]string{}
+
+// detectTitleLanguage enables fetching the linked page title when the replied text is
+// too short to detect its language; enabled from DETECT_TITLE_LANGUAGE in main
+var detectTitleLanguage = false
+
+var (
+	titleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	wordRegex  = regexp.MustCompile(`[\p{L}']+`)
+)
+
+// pageTitleClient fetches page titles for language detection. The links come from
+// users, so it connects to public addresses only, also when following redirects,
+// and ignores proxy settings that would hide the resolved address.
+var pageTitleClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("stopped after 5 redirects")
+		}
+		return nil
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, home of some cloud metadata endpoints
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress checks that addr is not a loopback, private, link-local (including the
+// 169.254.169.254 metadata endpoint), multicast or unspecified address
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// dialPublicOnly refuses connections to non-public addresses; it runs after DNS
+// resolution, for every connection
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(addr) {
+		return fmt.Errorf("refusing to connect to non-public address %s", addr)
+	}
+	return nil
+}
+
+// detectLanguage guesses the ISO 639-1 code of the text; it returns "" if unsure
+func detectLanguage(text string) string {
+	scripts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["cyrillic"]++
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			scripts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+	for _, code := range []string{"el", "ar", "he", "ko", "zh"} {
+		if scripts[code] > letters/2 {
+			return code
+		}
+	}
+	if letters < minDetectLetters {
+		return ""
+	}
+
+	if scripts["cyrillic"] > letters/2 {
+		if strings.ContainsAny(strings.ToLower(text), "іїєґ") {
+			return "uk"
+		}
+		return "ru"
+	}
+	if scripts["latin"] > letters/2 {
+		return detectLatinLanguage(text)
+	}
+	return ""
+}
+
+// detectLatinLanguage scores Latin-script text by common words
+func detectLatinLanguage(text string) string {
+	words := wordRegex.FindAllString(strings.ToLower(text), -1)
+	scores := make(map[string]int)
+	for _, word := range words {
+		for code, list := range stopwords {
+			for _, stopword := range list {
+				if word == stopword {
+					scores[code]++
+				}
+			}
+		}
+	}
+
+	codes := make([]string, 0, len(scores))
+	for code := range scores {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if scores[codes[i]] != scores[codes[j]] {
+			return scores[codes[i]] > scores[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+
+	// Require a clear winner
+	if len(codes) == 0 || scores[codes[0]] < 2 || (len(codes) > 1 && scores[codes[0]] == scores[codes[1]]) {
+		return ""
+	}
+	return codes[0]
+}
+
+// fetchPageTitle downloads the beginning of the page and returns its title
+func fetchPageTitle(ctx context.Context, url string) (title string, err error) {
+	ctx, span := tracer.Start(ctx, "fetch_page_title", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("url.full", url),
+	))
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := pageTitleClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256<<10))
+	if err != nil {
+		return "", err
+	}
+	matches := titleRegex.FindSubmatch(body)
+	if matches == nil {
+		return "", nil
+	}
+	return strings.TrimSpace(html.UnescapeString(string(matches[1]))), nil
+}
+
+// languageInstruction tells the LLM which language to answer in
+func languageInstruction(language string) string {
+	name, ok := languageNames[language]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" Answer in %s.", name)
+}
+
+// messageLanguage detects the language of the message text, ignoring links
+func messageLanguage(msg *tele.Message) string {
+	if msg == nil {
+		return ""
+	}
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+	return detectLanguage(urlRegex.ReplaceAllString(text, " "))
+}
+
+// resolveReplyLanguage picks the reply language: the chat's fixed language or the
+// language of the replied message
+func resolveReplyLanguage(chatID int64, msg *tele.Message) string {
+	if language, ok := fixedChatLanguages[chatID]; ok {
+		return language
+	}
+	return messageLanguage(msg)
+}
+
+// titleLanguage detects the language of the linked page from its title, for messages too
+// short to detect; it fetches the page, so it runs only for rate-limited requests
+func titleLanguage(ctx context.Context, msg *tele.Message) string {
+	if !detectTitleLanguage || msg == nil {
+		return ""
+	}
+
+	url := extractURLInSpan(ctx, msg.Text+" "+msg.Caption)
+	if url == "" {
+		return ""
+	}
+	title, err := fetchPageTitle(ctx, url)
+	if err != nil {
+		logJSONContext(ctx, "debug", "Failed to fetch page title", map[string]interface{}{
+			"url":   url,
+			"error": err.Error(),
+		})
+		return ""
+	}
+	return detectLanguage(title)
+}
+
+// replyLanguage returns the language resolved for the current request
+func replyLanguage(c tele.Context) string {
+	language, _ := c.Get(replyLanguageKey).(string)
+	return language
+}
+
+// chatLanguage returns the fixed language of the chat, or "" if none is configured
+func chatLanguage(chatID int64) string {
+	return fixedChatLanguages[chatID]
+}
+
+// userLanguage picks the language of bot notices: the chat's fixed language or
+// the sender's Telegram language, if it is a known one
+func userLanguage(c tele.Context) string {
+	if chat := c.Chat(); chat != nil {
+		if language := chatLanguage(chat.ID); language != "" {
+			return language
+		}
+	}
+	sender := c.Sender()
+	if sender == nil {
+		return ""
+	}
+	language, _, _ := strings.Cut(strings.ToLower(sender.LanguageCode), "-")
+	if _, ok := languageNames[language]; !ok {
+		return ""
+	}
+	return language
+}
+
+// parseChatLanguages parses CHAT_LANGUAGES, a comma-separated list of chat_id:language pairs
+func parseChatLanguages(s string) (map[int64]string, error) {
+	languages := make(map[int64]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		chatPart, language, ok := strings.Cut(part, ":")
+		chatIDs := parseAllowedChatIDs(chatPart)
+		language = strings.ToLower(strings.TrimSpace(language))
+		if !ok || len(chatIDs) != 1 {
+			return nil, fmt.Errorf("invalid chat language %q, expected chat_id:language", part)
+		}
+		if _, known := languageNames[language]; !known {
+			return nil, fmt.Errorf("unsupported language %q for chat %d", language, chatIDs[0])
+		}
+		languages[chatIDs[0]] = language
+	}
+	return languages, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"This is the best article about the future of work that I have read", "en"},
+		{"Это лучшая статья о будущем работы, которую я читал", "ru"},
+		{"Це найкраща стаття про майбутнє роботи, яку я читав", "uk"},
+		{"Das ist der beste Artikel über die Zukunft der Arbeit, den ich gelesen habe", "de"},
+		{"C'est le meilleur article sur le futur du travail que j'ai lu dans la semaine", "fr"},
+		{"Es el mejor artículo sobre el futuro del trabajo que he leído, y con datos", "es"},
+		{"これは仕事の未来についての最高の記事です", "ja"},
+		{"这是关于工作未来的最好的文章", "zh"},
+		{"Αυτό είναι το καλύτερο άρθρο", "el"},
+		{"ok", ""},
+		{"", ""},
+		{"1234 5678 !!!", ""},
+	}
+
+	for _, tt := range tests {
+		if result := detectLanguage(tt.text); result != tt.expected {
+			t.Errorf("detectLanguage(%q) = %q, want %q", tt.text, result, tt.expected)
+		}
+	}
+}
+
+func TestLanguageInstruction(t *testing.T) {
+	if result := languageInstruction("ru"); result != " Answer in Russian." {
+		t.Errorf("languageInstruction(ru) = %q", result)
+	}
+	if result := languageInstruction(""); result != "" {
+		t.Errorf("languageInstruction(\"\") = %q, want empty", result)
+	}
+	if result := languageInstruction("xx"); result != "" {
+		t.Errorf("languageInstruction(xx) = %q, want empty", result)
+	}
+}
+
+func TestParseChatLanguages(t *testing.T) {
+	languages, err := parseChatLanguages("-100123:ru, -100456:DE")
+	if err != nil {
+		t.Fatalf("parseChatLanguages() error: %v", err)
+	}
+	if languages[-100123] != "ru" || languages[-100456] != "de" {
+		t.Errorf("parseChatLanguages() = %v", languages)
+	}
+
+	if languages, err := parseChatLanguages(""); err != nil || len(languages) != 0 {
+		t.Errorf("parseChatLanguages(\"\") = %v, %v", languages, err)
+	}
+
+	for _, invalid := range []string{"-100123", "-100123:xx", "chat:ru"} {
+		if _, err := parseChatLanguages(invalid); err == nil {
+			t.Errorf("parseChatLanguages(%q) expected error, got nil", invalid)
+		}
+	}
+}
+
+// usePageTitleClient replaces the page title client, which refuses the loopback test servers
+func usePageTitleClient(t *testing.T, client *http.Client) {
+	t.Helper()
+	original := pageTitleClient
+	pageTitleClient = client
+	t.Cleanup(func() { pageTitleClient = original })
+}
+
+func TestFetchPageTitle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "<html><head><TITLE>\n Будущее работы &amp; офисы </TITLE></head><body></body></html>")
+	}))
+	defer server.Close()
+	usePageTitleClient(t, server.Client())
+
+	title, err := fetchPageTitle(context.Background(), server.URL)
+	if err != nil || title != "Будущее работы & офисы" {
+		t.Errorf("fetchPageTitle() = %q, %v", title, err)
+	}
+	if _, err := fetchPageTitle(context.Background(), server.URL+"/missing"); err == nil {
+		t.Error("fetchPageTitle() for a missing page expected error, got nil")
+	}
+}
+
+func TestResolveReplyLanguage(t *testing.T) {
+	originalFixed := fixedChatLanguages
+	defer func() { fixedChatLanguages = originalFixed }()
+	fixedChatLanguages = map[int64]string{-100: "ru"}
+
+	tests := []struct {
+		name     string
+		chatID   int64
+		msg      *tele.Message
+		expected string
+	}{
+		{"Fixed chat language", -100, &tele.Message{Text: "This is the best article about the future of work"}, "ru"},
+		{"Message language", -200, &tele.Message{Text: "Это лучшая статья о будущем работы https://example.com"}, "ru"},
+		{"Caption language", -200, &tele.Message{Caption: "Das ist der beste Artikel über die Zukunft der Arbeit"}, "de"},
+		{"Bare link", -200, &tele.Message{Text: "https://example.com"}, ""},
+		{"Unknown", -200, &tele.Message{Text: "hm"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := resolveReplyLanguage(tt.chatID, tt.msg); result != tt.expected {
+				t.Errorf("resolveReplyLanguage() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTitleLanguage(t *testing.T) {
+	silenceStdout(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<title>Warum das Büro nicht stirbt und die Arbeit der Zukunft</title>")
+	}))
+	defer server.Close()
+
+	original := detectTitleLanguage
+	defer func() { detectTitleLanguage = original }()
+	msg := &tele.Message{Text: server.URL}
+
+	detectTitleLanguage = false
+	if result := titleLanguage(context.Background(), msg); result != "" {
+		t.Errorf("titleLanguage() with title detection disabled = %q, want empty", result)
+	}
+
+	// The default client refuses the loopback server
+	detectTitleLanguage = true
+	if result := titleLanguage(context.Background(), msg); result != "" {
+		t.Errorf("titleLanguage() for a loopback address = %q, want empty", result)
+	}
+
+	usePageTitleClient(t, server.Client())
+	if result := titleLanguage(context.Background(), msg); result != "de" {
+		t.Errorf("titleLanguage() = %q, want de", result)
+	}
+}
+
+func TestPageTitleClientRefusesPrivateAddresses(t *testing.T) {
+	fetched := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		fmt.Fprint(w, "<title>Internal dashboard</title>")
+	}))
+	defer target.Close()
+
+	if _, err := fetchPageTitle(context.Background(), target.URL); err == nil || fetched {
+		t.Errorf("fetchPageTitle() of a loopback address: error %v, fetched %v", err, fetched)
+	}
+
+	for _, address := range []string{
+		"127.0.0.1:80", "[::1]:80", "10.0.0.5:6379", "172.17.0.2:9090", "192.168.1.1:80",
+		"169.254.169.254:80", "[fd00:ec2::254]:80", "100.100.100.200:80", "0.0.0.0:80",
+		"[::ffff:127.0.0.1]:80", "[fe80::1]:80",
+	} {
+		if err := dialPublicOnly("tcp", address, nil); err == nil {
+			t.Errorf("dialPublicOnly(%s) should refuse the address", address)
+		}
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1:248:1893:25c8:1946]:443"} {
+		if err := dialPublicOnly("tcp", address, nil); err != nil {
+			t.Errorf("dialPublicOnly(%s) error: %v", address, err)
+		}
+	}
+}
+
+func TestRunLLMLanguageInstruction(t *testing.T) {
+	silenceStdout(t)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Ерунда.")}}
+	useFakeLLMProvider(t, fake)
+
+	if _, err := analyzeURLInTone(context.Background(), "https://example.com", PromptBullshit, "ru"); err != nil {
+		t.Fatalf("analyzeURLInTone() error: %v", err)
+	}
+	if system := fake.config.SystemInstruction.Parts[0].Text; !strings.HasSuffix(system, " Answer in Russian.") {
+		t.Errorf("system prompt = %q, want the language instruction", system)
+	}
+}
+
+func TestHandleAnalysisCommandLocalizedRateLimit(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	for i := 0; i < dailyRequestLimit; i++ {
+		consumeRateLimit(t.Context(), redisClient, 123456789, fmt.Sprintf("opinion:%d", i), 1)
+	}
+
+	reply := ""
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	mockCtx.message.ReplyTo.Text = "Это лучшая статья о будущем работы https://example.com"
+	handleOpinionCommand(mockCtx, allowlist, nil, 1, nil)
+
+	if reply != localizeCount("ru", msgRateLimited, dailyRequestLimit) {
+		t.Errorf("reply = %q, want the Russian rate limit message", reply)
+	}
+}
+
+func TestHandleAnalysisCommandFetchesTitleAfterRateLimit(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprint(w, "<title>Warum das Büro nicht stirbt und die Arbeit der Zukunft</title>")
+	}))
+	defer server.Close()
+	usePageTitleClient(t, server.Client())
+	original := detectTitleLanguage
+	defer func() { detectTitleLanguage = original }()
+	detectTitleLanguage = true
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	for i := 0; i < dailyRequestLimit; i++ {
+		consumeRateLimit(t.Context(), redisClient, 123456789, fmt.Sprintf("opinion:%d", i), 1)
+	}
+
+	reply := ""
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	mockCtx.message.ReplyTo.Text = server.URL
+	handleOpinionCommand(mockCtx, allowlist, nil, 1, nil)
+
+	if reply != localizeCount("en", msgRateLimited, dailyRequestLimit) || fetches != 0 {
+		t.Errorf("reply = %q after %d page fetches, want the rate limit without fetching", reply, fetches)
+	}
+}
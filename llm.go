@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/genai"
 )
 
@@ -36,6 +38,70 @@ var videoPrompts = map[PromptType]string{
 	PromptNegative: " If it's a video - rudely refuse to watch it and make a sarcastic comment about people who share videos instead of text.",
 }
 
+// Base prompts for plain-text messages without a link, one per tone
+var textPrompts = map[PromptType]string{
+	PromptBullshit: "Write a short summary why the text of the message is a bullshit. Don't write introduction or something else, just answer. Don't repeat the text. Keep the answer short and funny.",
+	PromptPositive: "Write a short summary with positive and well-argumented feedback about the text of the message. Don't write introduction, just answer. Don't repeat the text. Keep the answer short and encouraging.",
+	PromptNegative: "Write a short summary with argumented criticism about why the text of the message is not convincing. Don't write introduction, just answer. Don't repeat the text. Keep the answer short and constructive but critical.",
+}
+
+// Base prompts for images and screenshots, one per tone
+var imagePrompts = map[PromptType]string{
+	PromptBullshit: "Write a short summary why the content of the image is a bullshit. It's usually a screenshot of a post or an article - read it and argue against what it says. Don't write introduction or something else, just answer. Keep the answer short and funny.",
+	PromptPositive: "Write a short summary with positive and well-argumented feedback about the content of the image. It's usually a screenshot of a post or an article - read it and highlight the good aspects. Don't write introduction, just answer. Keep the answer short and encouraging.",
+	PromptNegative: "Write a short summary with argumented criticism about why the content of the image is not good. It's usually a screenshot of a post or an article - read it and point out its weaknesses. Don't write introduction, just answer. Keep the answer short and constructive but critical.",
+}
+
+// Base prompts for documents (PDF, text, HTML), one per tone
+var documentPrompts = map[PromptType]string{
+	PromptBullshit: "Write a short summary why the document from the message is a bullshit. Don't write introduction or something else, just answer. Don't retell the document. Keep the answer short and funny.",
+	PromptPositive: "Write a short summary with positive and well-argumented feedback about the document from the message. Don't write introduction, just answer. Don't retell the document. Keep the answer short and encouraging.",
+	PromptNegative: "Write a short summary with argumented criticism about why the document from the message is not good. Don't write introduction, just answer. Don't retell the document. Keep the answer short and constructive but critical.",
+}
+
+// Base prompts for voice messages, audio files and video notes, one per tone
+var audioPrompts = map[PromptType]string{
+	PromptBullshit: "Write a short summary why what is said in the recording is a bullshit. Don't write introduction or something else, just answer. Don't retell the recording. Keep the answer short and funny.",
+	PromptPositive: "Write a short summary with positive and well-argumented feedback about what is said in the recording. Don't write introduction, just answer. Don't retell the recording. Keep the answer short and encouraging.",
+	PromptNegative: "Write a short summary with argumented criticism about why what is said in the recording is not convincing. Don't write introduction, just answer. Don't retell the recording. Keep the answer short and constructive but critical.",
+}
+
+// SummaryLength controls the size and format of /tldr summaries
+type SummaryLength string
+
+const (
+	SummaryShort   SummaryLength = "short"
+	SummaryMedium  SummaryLength = "medium"
+	SummaryBullets SummaryLength = "bullets"
+)
+
+// Base prompt for neutral summaries, outside the random tone selection
+const summaryBasePrompt = "Write a neutral, factual summary of the content provided by a link. Don't give opinions, don't judge, don't write introduction, just answer. If it's a github project - describe what it does and how it is used. If it's a video - summarize it from its title and description."
+
+// Length instructions for summaries
+var summaryLengthPrompts = map[SummaryLength]string{
+	SummaryShort:   " Keep it to one or two sentences.",
+	SummaryMedium:  " Keep it to one short paragraph of about five sentences.",
+	SummaryBullets: " Format it as three to five short bullet points starting with \"•\".",
+}
+
+// parseSummaryLength parses a summary length name, case-insensitively
+func parseSummaryLength(s string) (SummaryLength, bool) {
+	length := SummaryLength(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := summaryLengthPrompts[length]; !ok {
+		return "", false
+	}
+	return length, true
+}
+
+// buildSummaryPrompt constructs the summary prompt for the given length
+func buildSummaryPrompt(length SummaryLength) string {
+	return summaryBasePrompt + summaryLengthPrompts[length]
+}
+
+// Prompt for /factcheck, neutral and grounded with Google Search
+const factCheckPrompt = "Identify up to five main factual claims in the content provided by a link. Verify each claim with Google Search and rate it as one of: ✅ True, ⚠️ Misleading, ❌ False, ❓ Unverified. For each claim write one line with the rating, the claim and a one-sentence explanation. Don't write introduction or conclusion, just answer. If the content has no factual claims, say so in one sentence."
+
 // selectPromptType randomly selects a prompt type based on probabilities
 func selectPromptType() PromptType {
 	r := rand.Float64() * 100
@@ -58,46 +124,165 @@ func buildPrompt(promptType PromptType) string {
 
 // analyzeURLWithLLM sends the URL to the LLM and returns the analysis
 func analyzeURLWithLLM(url string) (string, error) {
-	ctx := context.Background()
-	startTime := time.Now()
-
 	// Select prompt type based on probability
-	promptType := selectPromptType()
-	prompt := buildPrompt(promptType)
+	return analyzeURLInTone(inflight.Context(), url, selectPromptType(), "")
+}
 
-	logJSON("info", "Starting LLM analysis", map[string]interface{}{
-		"url":         url,
-		"model":       llmModel,
-		"prompt_type": string(promptType),
+// analyzeURLInTone sends the URL to the LLM and returns the analysis in the given tone and language
+func analyzeURLInTone(ctx context.Context, url string, promptType PromptType, language string) (string, error) {
+	result, err := runLLMInTone(ctx, promptType, func(tone PromptType) llmRequest {
+		return urlRequest(url, buildPrompt(tone), string(tone), language)
 	})
+	return result.Text, err
+}
 
-	if googleAPIKey == "" {
-		logJSON("error", "LLM API key not configured", nil)
-		return "", fmt.Errorf("GOOGLE_API_KEY not configured")
+// analyzeTextWithLLM sends a plain-text message to the LLM and returns the analysis in the given tone
+func analyzeTextWithLLM(ctx context.Context, text string, promptType PromptType, language string) (string, error) {
+	result, err := runLLMInTone(ctx, promptType, func(tone PromptType) llmRequest {
+		return llmRequest{
+			Prompt:     textPrompts[tone],
+			PromptType: "text_" + string(tone),
+			Language:   language,
+			Contents: []*genai.Content{
+				{
+					Role:  "user",
+					Parts: []*genai.Part{genai.NewPartFromText(text)},
+				},
+			},
+		}
+	})
+	return result.Text, err
+}
+
+// analyzeImageWithLLM sends an image, with its caption if any, to the LLM and returns the analysis in the given tone
+func analyzeImageWithLLM(ctx context.Context, data []byte, mimeType string, caption string, promptType PromptType, language string) (string, error) {
+	parts := []*genai.Part{genai.NewPartFromBytes(data, mimeType)}
+	if caption != "" {
+		parts = append(parts, genai.NewPartFromText(caption))
 	}
 
-	logJSON("debug", "Creating LLM client", map[string]interface{}{
-		"api_key_length": len(googleAPIKey),
+	result, err := runLLMInTone(ctx, promptType, func(tone PromptType) llmRequest {
+		return llmRequest{
+			Prompt:     imagePrompts[tone],
+			PromptType: "image_" + string(tone),
+			Language:   language,
+			Contents: []*genai.Content{
+				{
+					Role:  "user",
+					Parts: parts,
+				},
+			},
+		}
 	})
+	return result.Text, err
+}
 
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey: googleAPIKey,
-	})
-	if err != nil {
-		logJSON("error", "Failed to create LLM client", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return "", fmt.Errorf("failed to create LLM client: %w", err)
+// analyzeDocumentWithLLM sends the extracted text of a document to the LLM and returns the analysis in the given tone
+func analyzeDocumentWithLLM(ctx context.Context, text string, fileName string, caption string, promptType PromptType, language string) (string, error) {
+	parts := []*genai.Part{genai.NewPartFromText(fmt.Sprintf("Document %q:\n\n%s", fileName, text))}
+	if caption != "" {
+		parts = append(parts, genai.NewPartFromText(caption))
 	}
 
-	logJSON("debug", "LLM client created successfully", nil)
+	result, err := runLLMInTone(ctx, promptType, func(tone PromptType) llmRequest {
+		return llmRequest{
+			Prompt:     documentPrompts[tone],
+			PromptType: "document_" + string(tone),
+			Language:   language,
+			Contents: []*genai.Content{
+				{
+					Role:  "user",
+					Parts: parts,
+				},
+			},
+		}
+	})
+	return result.Text, err
+}
 
-	var tools []*genai.Tool
-	tools = append(tools, &genai.Tool{
-		URLContext: &genai.URLContext{},
+// analyzeAudioWithLLM sends a recording to the multimodal LLM as an inline part and returns the analysis in the given tone
+func analyzeAudioWithLLM(ctx context.Context, data []byte, mimeType string, promptType PromptType, language string) (string, error) {
+	result, err := runLLMInTone(ctx, promptType, func(tone PromptType) llmRequest {
+		return llmRequest{
+			Prompt:     audioPrompts[tone],
+			PromptType: "audio_" + string(tone),
+			Language:   language,
+			Contents: []*genai.Content{
+				{
+					Role:  "user",
+					Parts: []*genai.Part{genai.NewPartFromBytes(data, mimeType)},
+				},
+			},
+		}
 	})
+	return result.Text, err
+}
+
+// summarizeURLWithLLM sends the URL to the LLM and returns a neutral summary in the language
+func summarizeURLWithLLM(ctx context.Context, url string, length SummaryLength, language string) (string, error) {
+	return generateForURL(ctx, url, buildSummaryPrompt(length), "tldr_"+string(length), language)
+}
+
+// generateForURL runs the prompt against the URL content and returns the streamed response.
+// promptType is used in logs and metrics; language may be empty to let the model choose.
+func generateForURL(ctx context.Context, url string, prompt string, promptType string, language string) (string, error) {
+	result, err := runLLM(ctx, urlRequest(url, prompt, promptType, language))
+	return result.Text, err
+}
 
-	contents := []*genai.Content{
+// urlRequest builds the request for a prompt about the URL content
+func urlRequest(url string, prompt string, promptType string, language string) llmRequest {
+	return llmRequest{
+		URL:        url,
+		Prompt:     prompt,
+		PromptType: promptType,
+		Language:   language,
+		Contents:   urlContents(url),
+		Tools:      []*genai.Tool{{URLContext: &genai.URLContext{}}},
+	}
+}
+
+// factCheckURLWithLLM asks the LLM to rate the main claims of the URL content,
+// grounded with Google Search
+func factCheckURLWithLLM(ctx context.Context, url string, language string) (llmResult, error) {
+	return runLLM(ctx, llmRequest{
+		URL:        url,
+		Prompt:     factCheckPrompt,
+		PromptType: "factcheck",
+		Language:   language,
+		Contents:   urlContents(url),
+		Tools: []*genai.Tool{
+			{URLContext: &genai.URLContext{}},
+			{GoogleSearch: &genai.GoogleSearch{}},
+		},
+	})
+}
+
+// llmRequest describes a single LLM request
+type llmRequest struct {
+	URL        string // analyzed URL, for logging
+	Prompt     string // system instruction
+	PromptType string // for logs and metrics
+	Language   string // ISO 639-1 code of the answer, empty to let the model choose
+	Contents   []*genai.Content
+	Tools      []*genai.Tool
+}
+
+// llmResult is the collected output of a streamed LLM request
+type llmResult struct {
+	Text    string
+	Sources []groundingSource
+}
+
+// groundingSource is a web page the model used to ground its answer
+type groundingSource struct {
+	Title string
+	URI   string
+}
+
+// urlContents builds the user message containing only the URL
+func urlContents(url string) []*genai.Content {
+	return []*genai.Content{
 		{
 			Role: "user",
 			Parts: []*genai.Part{
@@ -105,48 +290,140 @@ func analyzeURLWithLLM(url string) (string, error) {
 			},
 		},
 	}
+}
+
+// runLLM streams the request through the provider and collects the text and grounding sources
+func runLLM(ctx context.Context, req llmRequest) (llmResult, error) {
+	settings := resolveGenerationSettings(req.PromptType)
+	degraded := degradedByBudget(ctx)
+	if degraded {
+		// Near a budget cap: the cheaper model, if configured, without thinking
+		if budget.FallbackModel != "" {
+			settings.Model = budget.FallbackModel
+		}
+		settings.ThinkingBudget = genai.Ptr(int32(0))
+	}
+	model := settings.Model
+
+	ctx, span := tracer.Start(ctx, "llm.generate", trace.WithAttributes(
+		attribute.String("llm.model", model),
+		attribute.Bool("llm.degraded", degraded),
+		attribute.String("llm.prompt_type", req.PromptType),
+		attribute.String("llm.language", req.Language),
+		attribute.String("url.full", req.URL),
+	))
+	if settings.Temperature != nil {
+		span.SetAttributes(attribute.Float64("llm.temperature", float64(*settings.Temperature)))
+	}
+	if settings.MaxOutputTokens != nil {
+		span.SetAttributes(attribute.Int("llm.max_output_tokens", int(*settings.MaxOutputTokens)))
+	}
+	startTime := time.Now()
+
+	fields := settings.logFields()
+	fields["url"] = req.URL
+	fields["prompt_type"] = req.PromptType
+	fields["degraded"] = degraded
+	logJSONContext(ctx, "info", "Starting LLM analysis", fields)
+
+	provider, err := newLLMProvider(ctx)
+	if err != nil {
+		endSpan(span, err)
+		return llmResult{}, err
+	}
 
 	config := &genai.GenerateContentConfig{
-		ThinkingConfig: &genai.ThinkingConfig{
-			ThinkingBudget: genai.Ptr[int32](1024),
-		},
-		Tools: tools,
+		Tools: req.Tools,
 		SystemInstruction: &genai.Content{
 			Parts: []*genai.Part{
-				genai.NewPartFromText(prompt),
+				genai.NewPartFromText(req.Prompt + languageInstruction(req.Language)),
 			},
 		},
 	}
+	settings.apply(config)
 
-	logJSON("debug", "Starting LLM stream request", map[string]interface{}{
-		"model":           llmModel,
-		"thinking_budget": 1024,
-		"tools_count":     len(tools),
-		"prompt_type":     string(promptType),
+	logJSONContext(ctx, "debug", "Starting LLM stream request", map[string]interface{}{
+		"model":           model,
+		"thinking_budget": *settings.ThinkingBudget,
+		"tools_count":     len(req.Tools),
+		"prompt_type":     req.PromptType,
+		"language":        req.Language,
 	})
 
 	var result strings.Builder
+	var sources []groundingSource
+	seenSources := make(map[string]bool)
 	chunkCount := 0
-	for streamResult, err := range client.Models.GenerateContentStream(ctx, llmModel, contents, config) {
+	var usage *genai.GenerateContentResponseUsageMetadata
+	var feedback *genai.GenerateContentResponsePromptFeedback
+	var stopped *genai.Candidate // the candidate carrying the finish reason
+	var retrievals []genai.URLRetrievalStatus
+	finish := func(err error) {
+		recordLLMRequest(model, req.PromptType, time.Since(startTime), chunkCount, usage, err)
+		accountUsage(ctx, model, usage)
+		span.SetAttributes(attribute.Int("llm.chunks", chunkCount))
+		if usage != nil {
+			span.SetAttributes(
+				attribute.Int("llm.usage.prompt_tokens", int(usage.PromptTokenCount)),
+				attribute.Int("llm.usage.output_tokens", int(usage.CandidatesTokenCount)),
+				attribute.Int("llm.usage.thoughts_tokens", int(usage.ThoughtsTokenCount)),
+			)
+		}
+		endSpan(span, err)
+	}
+	for streamResult, err := range provider.GenerateContentStream(ctx, model, req.Contents, config) {
 		if err != nil {
-			logJSON("error", "LLM stream error", map[string]interface{}{
+			logJSONContext(ctx, "error", "LLM stream error", map[string]interface{}{
 				"error":      err.Error(),
 				"chunk":      chunkCount,
 				"elapsed_ms": time.Since(startTime).Milliseconds(),
 			})
-			return "", fmt.Errorf("stream error: %w", err)
+			err = fmt.Errorf("stream error: %w", err)
+			finish(err)
+			return llmResult{}, err
 		}
 
 		chunkCount++
-		
-		logJSON("debug", "Received LLM chunk", map[string]interface{}{
-			"chunk_number":    chunkCount,
-			"candidates":      len(streamResult.Candidates),
-			"elapsed_ms":      time.Since(startTime).Milliseconds(),
+		if chunkCount == 1 {
+			span.AddEvent("first_chunk")
+		}
+		if streamResult.UsageMetadata != nil {
+			usage = streamResult.UsageMetadata
+		}
+		if streamResult.PromptFeedback != nil {
+			feedback = streamResult.PromptFeedback
+		}
+		if len(streamResult.Candidates) > 0 && streamResult.Candidates[0] != nil {
+			candidate := streamResult.Candidates[0]
+			if candidate.FinishReason != "" {
+				stopped = candidate
+			}
+			if candidate.URLContextMetadata != nil {
+				for _, metadata := range candidate.URLContextMetadata.URLMetadata {
+					if metadata != nil {
+						retrievals = append(retrievals, metadata.URLRetrievalStatus)
+					}
+				}
+			}
+		}
+
+		logJSONContext(ctx, "debug", "Received LLM chunk", map[string]interface{}{
+			"chunk_number": chunkCount,
+			"candidates":   len(streamResult.Candidates),
+			"elapsed_ms":   time.Since(startTime).Milliseconds(),
 		})
 
+		if len(streamResult.Candidates) > 0 {
+			for _, source := range groundingSources(streamResult.Candidates[0].GroundingMetadata) {
+				if !seenSources[source.URI] {
+					seenSources[source.URI] = true
+					sources = append(sources, source)
+				}
+			}
+		}
+
 		if len(streamResult.Candidates) == 0 || streamResult.Candidates[0].Content == nil || len(streamResult.Candidates[0].Content.Parts) == 0 {
-			logJSON("debug", "Empty chunk, skipping", map[string]interface{}{
+			logJSONContext(ctx, "debug", "Empty chunk, skipping", map[string]interface{}{
 				"chunk_number": chunkCount,
 			})
 			continue
@@ -155,34 +432,112 @@ func analyzeURLWithLLM(url string) (string, error) {
 		parts := streamResult.Candidates[0].Content.Parts
 		for i, part := range parts {
 			result.WriteString(part.Text)
-			logJSON("debug", "Processing part", map[string]interface{}{
-				"chunk":       chunkCount,
-				"part_index":  i,
-				"text_length": len(part.Text),
+			logJSONContext(ctx, "debug", "Processing part", map[string]interface{}{
+				"chunk":        chunkCount,
+				"part_index":   i,
+				"text_length":  len(part.Text),
 				"text_preview": truncateString(part.Text, 50),
 			})
 		}
 	}
 
 	response := result.String()
+	if stopped != nil {
+		span.SetAttributes(attribute.String("llm.finish_reason", string(stopped.FinishReason)))
+	}
+	if finishErr := finishError(feedback, stopped, response); finishErr != nil {
+		logJSONContext(ctx, "warn", "LLM answer stopped", map[string]interface{}{
+			"url":            req.URL,
+			"reason":         finishErr.Reason,
+			"prompt_blocked": finishErr.Prompt,
+			"categories":     finishErr.Categories,
+			"partial_length": len(finishErr.Partial),
+			"elapsed_ms":     time.Since(startTime).Milliseconds(),
+		})
+		recordFinishReason(model, req.PromptType, finishErr.MetricLabel())
+		finish(finishErr)
+		return llmResult{}, finishErr
+	}
+	if stopped != nil {
+		recordFinishReason(model, req.PromptType, strings.ToLower(string(stopped.FinishReason)))
+	}
+	// A failed retrieval only fails the request when the model had nothing to say
+	if retrievalErr := retrievalError(req.URL, retrievals); req.URL != "" && retrievalErr != nil {
+		span.SetAttributes(attribute.String("llm.url_retrieval_status", string(retrievalErr.Status)))
+		logJSONContext(ctx, "warn", "LLM could not retrieve the URL", map[string]interface{}{
+			"url":        req.URL,
+			"status":     retrievalErr.Status,
+			"answered":   response != "",
+			"elapsed_ms": time.Since(startTime).Milliseconds(),
+		})
+		if response == "" {
+			finish(retrievalErr)
+			return llmResult{}, retrievalErr
+		}
+	}
+
 	if response == "" {
-		logJSON("error", "LLM returned empty response", map[string]interface{}{
-			"url":        url,
+		logJSONContext(ctx, "error", "LLM returned empty response", map[string]interface{}{
+			"url":        req.URL,
 			"chunks":     chunkCount,
 			"elapsed_ms": time.Since(startTime).Milliseconds(),
 		})
-		return "", fmt.Errorf("no response from LLM")
+		err := fmt.Errorf("no response from LLM")
+		finish(err)
+		return llmResult{}, err
 	}
 
-	logJSON("success", "LLM analysis completed", map[string]interface{}{
-		"url":             url,
-		"response_length": len(response),
-		"chunks":          chunkCount,
-		"elapsed_ms":      time.Since(startTime).Milliseconds(),
+	logJSONContext(ctx, "success", "LLM analysis completed", map[string]interface{}{
+		"url":              req.URL,
+		"response_length":  len(response),
+		"sources":          len(sources),
+		"chunks":           chunkCount,
+		"elapsed_ms":       time.Since(startTime).Milliseconds(),
 		"response_preview": truncateString(response, 100),
 	})
 
-	return response, nil
+	finish(nil)
+	return llmResult{Text: response, Sources: sources}, nil
+}
+
+// groundingSources extracts the web sources from grounding metadata
+func groundingSources(metadata *genai.GroundingMetadata) []groundingSource {
+	if metadata == nil {
+		return nil
+	}
+
+	var sources []groundingSource
+	for _, chunk := range metadata.GroundingChunks {
+		if chunk == nil || chunk.Web == nil || chunk.Web.URI == "" {
+			continue
+		}
+		title := chunk.Web.Title
+		if title == "" {
+			title = chunk.Web.Domain
+		}
+		sources = append(sources, groundingSource{Title: title, URI: chunk.Web.URI})
+	}
+	return sources
+}
+
+// formatCitations appends the grounding sources to the text as a numbered list under a localized heading
+func formatCitations(text string, sources []groundingSource, language string) string {
+	if len(sources) == 0 {
+		return text
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(text, "\n"))
+	sb.WriteString("\n\n")
+	sb.WriteString(localize(language, msgFactCheckSources))
+	for i, source := range sources {
+		title := source.Title
+		if title == "" {
+			title = source.URI
+		}
+		sb.WriteString(fmt.Sprintf("\n[%d] %s - %s", i+1, title, source.URI))
+	}
+	return sb.String()
 }
 
 // truncateString truncates a string to maxLen characters
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"strings"
@@ -686,3 +687,65 @@ func TestPromptTypeCasting(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSummaryLength(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected SummaryLength
+		ok       bool
+	}{
+		{"short", SummaryShort, true},
+		{"Medium", SummaryMedium, true},
+		{" bullets ", SummaryBullets, true},
+		{"long", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		result, ok := parseSummaryLength(tt.input)
+		if result != tt.expected || ok != tt.ok {
+			t.Errorf("parseSummaryLength(%q) = %q, %v, want %q, %v", tt.input, result, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestBuildSummaryPrompt(t *testing.T) {
+	for _, length := range []SummaryLength{SummaryShort, SummaryMedium, SummaryBullets} {
+		t.Run(string(length), func(t *testing.T) {
+			prompt := buildSummaryPrompt(length)
+
+			if !strings.HasPrefix(prompt, summaryBasePrompt) {
+				t.Error("summary prompt should start with the base prompt")
+			}
+			if !strings.HasSuffix(prompt, summaryLengthPrompts[length]) {
+				t.Error("summary prompt should end with the length instruction")
+			}
+			if !strings.Contains(prompt, "neutral") {
+				t.Error("summary prompt should ask for a neutral tone")
+			}
+		})
+	}
+}
+
+func TestSummaryPromptIsNotATone(t *testing.T) {
+	for _, pt := range []PromptType{PromptBullshit, PromptPositive, PromptNegative} {
+		if strings.Contains(buildPrompt(pt), summaryBasePrompt) {
+			t.Errorf("opinion prompt %s should not contain the summary prompt", pt)
+		}
+	}
+}
+
+func TestSummarizeURLWithLLMNoAPIKey(t *testing.T) {
+	originalKey := googleAPIKey
+	defer func() { googleAPIKey = originalKey }()
+	googleAPIKey = ""
+
+	result, err := summarizeURLWithLLM(context.Background(), "https://example.com", SummaryShort, "")
+
+	if err == nil || !strings.Contains(err.Error(), "GOOGLE_API_KEY not configured") {
+		t.Errorf("summarizeURLWithLLM without API key: error = %v, want GOOGLE_API_KEY not configured", err)
+	}
+	if result != "" {
+		t.Errorf("summarizeURLWithLLM without API key: result = %q, want empty string", result)
+	}
+}
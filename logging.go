@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log levels; success and request are info events that keep their own names
+const (
+	levelDebug   = slog.LevelDebug
+	levelInfo    = slog.LevelInfo
+	levelSuccess = slog.LevelInfo + 1
+	levelRequest = slog.LevelInfo + 2
+	levelWarn    = slog.LevelWarn
+	levelError   = slog.LevelError
+	levelFatal   = slog.LevelError + 4
+)
+
+// levelNames maps the level names used in the logs to slog levels
+var levelNames = map[string]slog.Level{
+	"debug":   levelDebug,
+	"info":    levelInfo,
+	"success": levelSuccess,
+	"request": levelRequest,
+	"warn":    levelWarn,
+	"error":   levelError,
+	"fatal":   levelFatal,
+}
+
+// redactedValue replaces the values of redacted log fields
+const redactedValue = "[REDACTED]"
+
+// loggingConfig configures the bot logger
+type loggingConfig struct {
+	Level       slog.Level
+	Format      string   // json or text
+	DebugSample int      // log one of every DebugSample debug lines
+	Redact      []string // field name patterns whose values are hidden
+}
+
+// defaultLoggingConfig prints every level as JSON, like the logs before LOG_* existed
+var defaultLoggingConfig = loggingConfig{Level: levelDebug, Format: "json", DebugSample: 1}
+
+// logger writes the bot logs; setupLogging replaces it with the configured one
+var logger = newLogger(defaultLoggingConfig, stdoutWriter{})
+
+// stdoutWriter writes to the current os.Stdout, so output can be redirected after the logger is created
+type stdoutWriter struct{}
+
+func (stdoutWriter) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// loadLoggingConfig reads the logger configuration from LOG_LEVEL, LOG_FORMAT,
+// LOG_DEBUG_SAMPLE and LOG_REDACT
+func loadLoggingConfig() (loggingConfig, error) {
+	cfg := defaultLoggingConfig
+
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		level, ok := levelNames[strings.ToLower(v)]
+		if !ok {
+			return cfg, fmt.Errorf("invalid LOG_LEVEL %q", v)
+		}
+		cfg.Level = level
+	}
+
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		switch format := strings.ToLower(v); format {
+		case "json", "text":
+			cfg.Format = format
+		default:
+			return cfg, fmt.Errorf("invalid LOG_FORMAT %q", v)
+		}
+	}
+
+	var err error
+	if cfg.DebugSample, err = parseIntEnv("LOG_DEBUG_SAMPLE", cfg.DebugSample); err != nil {
+		return cfg, err
+	}
+
+	for _, pattern := range strings.Split(os.Getenv("LOG_REDACT"), ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return cfg, fmt.Errorf("invalid LOG_REDACT pattern %q", pattern)
+		}
+		cfg.Redact = append(cfg.Redact, pattern)
+	}
+
+	return cfg, nil
+}
+
+// setupLogging installs the configured logger, also as the slog default
+func setupLogging(cfg loggingConfig) {
+	logger = newLogger(cfg, stdoutWriter{})
+	slog.SetDefault(logger)
+}
+
+// newLogger creates a logger writing to w with the field names of the log pipeline:
+// timestamp, level and message
+func newLogger(cfg loggingConfig, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       cfg.Level,
+		ReplaceAttr: replaceLogAttr(cfg.Redact),
+	}
+
+	var handler slog.Handler
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	handler = traceHandler{handler}
+	if cfg.DebugSample > 1 {
+		handler = &samplingHandler{Handler: handler, every: uint64(cfg.DebugSample), seen: new(atomic.Uint64)}
+	}
+	return slog.New(handler)
+}
+
+// replaceLogAttr renames the built-in attributes and hides the redacted fields,
+// also inside map values such as the user and chat info
+func replaceLogAttr(redact []string) func([]string, slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 {
+			switch a.Key {
+			case slog.TimeKey:
+				return slog.String("timestamp", a.Value.Time().Format(time.RFC3339))
+			case slog.LevelKey:
+				return slog.String("level", levelName(a.Value.Any().(slog.Level)))
+			case slog.MessageKey:
+				return slog.Attr{Key: "message", Value: a.Value}
+			}
+		}
+		if len(redact) == 0 {
+			return a
+		}
+		if isRedacted(redact, a.Key) {
+			return slog.String(a.Key, redactedValue)
+		}
+		if m, ok := a.Value.Any().(map[string]interface{}); ok {
+			return slog.Any(a.Key, redactMap(redact, m))
+		}
+		return a
+	}
+}
+
+// levelName returns the name of a level as written in the logs
+func levelName(level slog.Level) string {
+	for name, l := range levelNames {
+		if l == level {
+			return name
+		}
+	}
+	return strings.ToLower(level.String())
+}
+
+// isRedacted checks if a field name matches one of the redaction patterns
+func isRedacted(redact []string, key string) bool {
+	for _, pattern := range redact {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redactMap returns a copy of m with the redacted fields hidden
+func redactMap(redact []string, m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if isRedacted(redact, k) {
+			out[k] = redactedValue
+		} else if nested, ok := v.(map[string]interface{}); ok {
+			out[k] = redactMap(redact, nested)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// traceHandler adds the trace and span IDs of the record context, so log lines
+// can be matched with traces
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// samplingHandler logs one of every `every` debug records, such as the per-chunk
+// lines of LLM streams; other levels are never dropped
+type samplingHandler struct {
+	slog.Handler
+	every uint64
+	seen  *atomic.Uint64
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < levelInfo && (h.seen.Add(1)-1)%h.every != 0 {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), every: h.every, seen: h.seen}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), every: h.every, seen: h.seen}
+}
+
+// logJSON outputs a structured log line with the fields of data
+func logJSON(level string, message string, data map[string]interface{}) {
+	logJSONContext(context.Background(), level, message, data)
+}
+
+// logJSONContext is logJSON with the trace and span IDs of ctx
+func logJSONContext(ctx context.Context, level string, message string, data map[string]interface{}) {
+	lvl, ok := levelNames[level]
+	if !ok {
+		lvl = levelInfo
+	}
+	if !logger.Enabled(ctx, lvl) {
+		return
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, slog.Any(k, data[k]))
+	}
+	logger.LogAttrs(ctx, lvl, message, attrs...)
+}
+
+// logFatal outputs a fatal log line and exits the program
+func logFatal(message string, data map[string]interface{}) {
+	logJSON("fatal", message, data)
+	os.Exit(1)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// useLogger replaces the logger with one writing to a buffer
+func useLogger(t *testing.T, cfg loggingConfig) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	original := logger
+	logger = newLogger(cfg, &buf)
+	t.Cleanup(func() { logger = original })
+	return &buf
+}
+
+// logLines parses the JSON log lines in buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLoggerMinimumLevel(t *testing.T) {
+	cfg := defaultLoggingConfig
+	cfg.Level = levelWarn
+	buf := useLogger(t, cfg)
+
+	for _, level := range []string{"debug", "info", "success", "request", "warn", "error"} {
+		logJSON(level, "Test message", nil)
+	}
+
+	var levels []string
+	for _, entry := range logLines(t, buf) {
+		levels = append(levels, entry["level"].(string))
+	}
+	if strings.Join(levels, ",") != "warn,error" {
+		t.Errorf("levels = %v, want [warn error]", levels)
+	}
+}
+
+func TestLoggerTextFormat(t *testing.T) {
+	cfg := defaultLoggingConfig
+	cfg.Format = "text"
+	buf := useLogger(t, cfg)
+
+	logJSON("success", "Reply sent", map[string]interface{}{"chat_id": -100})
+
+	line := buf.String()
+	for _, want := range []string{"timestamp=", "level=success", `message="Reply sent"`, "chat_id=-100"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("text log %q does not contain %q", line, want)
+		}
+	}
+}
+
+func TestLoggerDebugSampling(t *testing.T) {
+	cfg := defaultLoggingConfig
+	cfg.DebugSample = 3
+	buf := useLogger(t, cfg)
+
+	for i := 0; i < 7; i++ {
+		logJSON("debug", "Received LLM chunk", nil)
+	}
+	logJSON("info", "Not sampled", nil)
+	logJSON("info", "Not sampled", nil)
+
+	debug, info := 0, 0
+	for _, entry := range logLines(t, buf) {
+		switch entry["level"] {
+		case "debug":
+			debug++
+		case "info":
+			info++
+		}
+	}
+	if debug != 3 || info != 2 {
+		t.Errorf("logged %d debug and %d info lines, want 3 and 2", debug, info)
+	}
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	cfg := defaultLoggingConfig
+	cfg.Redact = []string{"username", "first_name", "*_preview"}
+	buf := useLogger(t, cfg)
+
+	logJSON("info", "Sending reply", map[string]interface{}{
+		"user": map[string]interface{}{
+			"username":   "alice",
+			"first_name": "Alice",
+			"user_id":    123,
+		},
+		"answer_preview": "Secret answer",
+		"message_id":     41,
+	})
+
+	entry := logLines(t, buf)[0]
+	user := entry["user"].(map[string]interface{})
+	if user["username"] != redactedValue || user["first_name"] != redactedValue {
+		t.Errorf("user = %v, want the names redacted", user)
+	}
+	if user["user_id"] != float64(123) {
+		t.Errorf("user_id = %v, want it kept", user["user_id"])
+	}
+	if entry["answer_preview"] != redactedValue {
+		t.Errorf("answer_preview = %v, want it redacted", entry["answer_preview"])
+	}
+	if entry["message_id"] != float64(41) || entry["message"] != "Sending reply" {
+		t.Errorf("log entry = %v, want the other fields kept", entry)
+	}
+}
+
+func TestLoggerKeepsTraceIDs(t *testing.T) {
+	buf := useLogger(t, defaultLoggingConfig)
+	useSpanRecorder(t)
+	ctx, span := tracer.Start(context.Background(), "test")
+	defer span.End()
+
+	logJSONContext(ctx, "debug", "Traced", nil)
+
+	entry := logLines(t, buf)[0]
+	if entry["trace_id"] != span.SpanContext().TraceID().String() {
+		t.Errorf("trace_id = %v, want %s", entry["trace_id"], span.SpanContext().TraceID())
+	}
+}
+
+func TestLoadLoggingConfig(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "")
+	t.Setenv("LOG_DEBUG_SAMPLE", "")
+	t.Setenv("LOG_REDACT", "")
+
+	cfg, err := loadLoggingConfig()
+	if err != nil {
+		t.Fatalf("loadLoggingConfig() error: %v", err)
+	}
+	if cfg.Level != levelDebug || cfg.Format != "json" || cfg.DebugSample != 1 || len(cfg.Redact) != 0 {
+		t.Errorf("default config = %+v", cfg)
+	}
+
+	t.Setenv("LOG_LEVEL", "WARN")
+	t.Setenv("LOG_FORMAT", "text")
+	t.Setenv("LOG_DEBUG_SAMPLE", "10")
+	t.Setenv("LOG_REDACT", "username, *_preview")
+	cfg, err = loadLoggingConfig()
+	if err != nil {
+		t.Fatalf("loadLoggingConfig() error: %v", err)
+	}
+	if cfg.Level != levelWarn || cfg.Format != "text" || cfg.DebugSample != 10 {
+		t.Errorf("config = %+v", cfg)
+	}
+	if strings.Join(cfg.Redact, ",") != "username,*_preview" {
+		t.Errorf("Redact = %v, want [username *_preview]", cfg.Redact)
+	}
+
+	for name, value := range map[string]string{
+		"LOG_LEVEL":        "verbose",
+		"LOG_FORMAT":       "xml",
+		"LOG_DEBUG_SAMPLE": "0",
+		"LOG_REDACT":       "[user",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loadLoggingConfig(); err == nil {
+				t.Errorf("loadLoggingConfig() with %s=%q should fail", name, value)
+			}
+		})
+	}
+}
@@ -2,12 +2,11 @@ package main
 
 import (
     "context"
-    "encoding/json"
-    "fmt"
-    "log"
     "os"
+    "os/signal"
     "strconv"
     "strings"
+    "syscall"
     "time"
 
     "github.com/joho/godotenv"
@@ -15,38 +14,68 @@ import (
     tele "gopkg.in/telebot.v3"
 )
 
-var redisClient *redis.Client
-
 func main() {
     // Load environment variables
-    if err := godotenv.Load(); err != nil {
+    envErr := godotenv.Load()
+
+    logCfg, err := loadLoggingConfig()
+    if err != nil {
+        logFatal("Invalid logging configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+    setupLogging(logCfg)
+
+    if envErr != nil {
         logJSON("info", "No .env file found, using system environment variables", nil)
     }
 
     // Initialize Redis client
-    redisAddr := os.Getenv("REDIS_ADDR")
-    if redisAddr == "" {
-        redisAddr = "localhost:6379"
+    redisCfg, err := loadRedisConfig()
+    if err != nil {
+        logFatal("Invalid Redis configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
     }
-    
-    redisClient = redis.NewClient(&redis.Options{
-        Addr:     redisAddr,
-        Password: os.Getenv("REDIS_PASSWORD"),
-        DB:       0,
-    })
-    
-    ctx := context.Background()
-    if err := redisClient.Ping(ctx).Err(); err != nil {
-        logJSON("warn", "Redis connection failed, continuing without cache", map[string]interface{}{
+    redisKeyPrefix = redisCfg.KeyPrefix
+
+    redisClient, err = newRedisClient(redisCfg)
+    if err != nil {
+        logFatal("Failed to create Redis client", map[string]interface{}{
             "error": err.Error(),
         })
-        redisClient = nil
-    } else {
-        logJSON("info", "Redis connected successfully", map[string]interface{}{
-            "address": redisAddr,
+    }
+
+    redisUnavailablePolicy, err = parseRedisUnavailablePolicy(os.Getenv("REDIS_UNAVAILABLE_POLICY"))
+    if err != nil {
+        logFatal("Invalid Redis configuration", map[string]interface{}{
+            "error": err.Error(),
         })
     }
 
+    redisHealthInterval, err := parseDurationEnv("REDIS_HEALTH_INTERVAL", 10*time.Second)
+    if err != nil {
+        logFatal("Invalid Redis configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+
+    ctx := context.Background()
+    if checkRedis(ctx, redisClient) {
+        logJSON("info", "Redis connected successfully", map[string]interface{}{
+            "addresses":  redisCfg.Addrs,
+            "mode":       redisCfg.mode(),
+            "db":         redisCfg.DB,
+            "tls":        redisCfg.TLS,
+            "key_prefix": redisCfg.KeyPrefix,
+        })
+    } else {
+        logJSON("warn", "Redis connection failed, will keep retrying in the background", map[string]interface{}{
+            "addresses": redisCfg.Addrs,
+            "interval":  redisHealthInterval.String(),
+            "policy":    redisUnavailablePolicy,
+        })
+    }
     botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
     if botToken == "" {
         logFatal("TELEGRAM_BOT_TOKEN environment variable is required", nil)
@@ -58,11 +87,14 @@ func main() {
         logJSON("warn", "GOOGLE_API_KEY not set, URL analysis will be disabled", nil)
     }
 
-    // Load allowed chat IDs
+    // Load bot owners (users who can manage the allowlist at runtime)
+    ownerUserIDs := parseOwnerUserIDs(os.Getenv("OWNER_USER_IDS"))
+
+    // Load allowed chat IDs (seed for the runtime allowlist)
     allowedChatsStr := os.Getenv("ALLOWED_CHAT_IDS")
-    if allowedChatsStr == "" {
+    if allowedChatsStr == "" && len(ownerUserIDs) == 0 {
         logFatal("ALLOWED_CHAT_IDS environment variable is required", map[string]interface{}{
-            "hint": "Provide comma-separated list of chat IDs",
+            "hint": "Provide comma-separated list of chat IDs, or OWNER_USER_IDS to manage chats at runtime",
         })
     }
 
@@ -72,251 +104,472 @@ func main() {
     }
 
     allowedChatIDs := parseAllowedChatIDs(allowedChatsStr)
+    allowlist := newChatAllowlist(allowedChatIDs)
+
+    loadAllowlist := func(ctx context.Context, client redis.UniversalClient) {
+        if err := allowlist.Load(ctx, client); err != nil {
+            logJSON("warn", "Failed to load stored allowlist", map[string]interface{}{
+                "error": err.Error(),
+            })
+        }
+    }
+    if rdb := activeRedis(); rdb != nil {
+        loadAllowlist(ctx, rdb)
+    }
+    redisConnectHooks = append(redisConnectHooks, loadAllowlist)
+    monitorCtx, stopMonitor := context.WithCancel(ctx)
+    go monitorRedis(monitorCtx, redisClient, redisHealthInterval)
     
     // Load excluded user IDs (users who bypass rate limiting)
     excludedUsersStr := os.Getenv("EXCLUDED_USER_IDS")
     excludedUserIDs := parseExcludedUserIDs(excludedUsersStr)
     
-    logJSON("info", "Configuration loaded", map[string]interface{}{
-        "allowed_chats":   allowedChatIDs,
-        "excluded_users":  excludedUserIDs,
-        "group_link":      groupLink,
-    })
+    // Onboarding of groups the bot is added to without authorization
+    accessRequestTTL, err := parseDurationEnv("ACCESS_REQUEST_TTL", 24*time.Hour)
+    if err != nil {
+        logFatal("Invalid onboarding configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+    leaveOnDeny, err := parseBoolEnv("LEAVE_ON_DENY", false)
+    if err != nil {
+        logFatal("Invalid onboarding configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+    accessRequestList := newAccessRequests(accessRequestTTL)
 
-    pref := tele.Settings{
-        Token:  botToken,
-        Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+    // Default length of /tldr summaries
+    tldrLength := SummaryShort
+    if v := os.Getenv("TLDR_LENGTH"); v != "" {
+        parsed, ok := parseSummaryLength(v)
+        if !ok {
+            logFatal("Invalid TLDR_LENGTH", map[string]interface{}{
+                "value": v,
+                "hint":  "Use short, medium or bullets",
+            })
+        }
+        tldrLength = parsed
     }
 
-    bot, err := tele.NewBot(pref)
+    // Opinions about long plain-text messages, enabled per chat
+    textMinLength, err := parseIntEnv("TEXT_ANALYSIS_MIN_LENGTH", 280)
     if err != nil {
-        logFatal("Failed to create bot", map[string]interface{}{
+        logFatal("Invalid text analysis configuration", map[string]interface{}{
             "error": err.Error(),
         })
     }
+    texts := &textAnalysis{
+        chatIDs:   parseAllowedChatIDs(os.Getenv("TEXT_ANALYSIS_CHAT_IDS")),
+        minLength: textMinLength,
+    }
 
-    logJSON("info", "Bot started successfully", map[string]interface{}{
-        "bot_username": bot.Me.Username,
-        "bot_id":       bot.Me.ID,
-    })
-
-    // Handle /opinion command
-    bot.Handle("/opinion", func(c tele.Context) error {
-        logRequest(c, "/opinion")
-        return handleOpinionCommand(c, allowedChatIDs, excludedUserIDs)
-    })
+    // Size limit of photos and image documents sent to the LLM
+    imageMaxMB, err := parseIntEnv("IMAGE_MAX_SIZE_MB", 10)
+    if err != nil {
+        logFatal("Invalid image configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+    imageMaxBytes = int64(imageMaxMB) << 20
 
-    logJSON("info", "Bot is running and waiting for messages", nil)
-    bot.Start()
-}
+    // Size limit of PDF and text documents; Bot API downloads are limited to 20 MB
+    documentMaxMB, err := parseIntEnv("DOCUMENT_MAX_SIZE_MB", 10)
+    if err != nil {
+        logFatal("Invalid document configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+    documentMaxBytes = int64(documentMaxMB) << 20
 
-func handleOpinionCommand(c tele.Context, allowedChatIDs []int64, excludedUserIDs []int64) error {
-    // Check if in allowed group
-    if !isAllowedChat(c, allowedChatIDs) {
-        chatType := string(c.Chat().Type)
-        var message string
-        
-        if chatType == "private" {
-            message = fmt.Sprintf("🤖 This bot is in early alpha and works only in the group: %s", os.Getenv("GROUP_LINK"))
-        } else {
-            message = fmt.Sprintf("🤖 This bot is in early alpha and works only in authorized groups. Join us at: %s", os.Getenv("GROUP_LINK"))
+    // Voice messages, audio files and video notes
+    audioMaxDuration, err = parseDurationEnv("AUDIO_MAX_DURATION", audioMaxDuration)
+    if err == nil {
+        audioCost, err = parseIntEnv("AUDIO_COST", audioCost)
+    }
+    if err != nil {
+        logFatal("Invalid audio configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+    transcriberName := "llm"
+    if command := os.Getenv("TRANSCRIBER_COMMAND"); command != "" {
+        commandTranscriber, err := newCommandTranscriber(command, 2*time.Minute)
+        if err != nil {
+            logFatal("Invalid audio configuration", map[string]interface{}{
+                "error": err.Error(),
+            })
         }
-        
-        logJSON("warn", "Unauthorized chat access attempt", map[string]interface{}{
-            "user":      getUserInfo(c),
-            "chat":      getChatInfo(c),
-            "chat_type": chatType,
+        audioTranscriber = commandTranscriber
+        transcriberName = "command"
+    }
+
+    // Follow-up threads on opinions
+    conversationMaxTurns, err = parseIntEnv("CONVERSATION_MAX_TURNS", conversationMaxTurns)
+    if err == nil {
+        conversationTTL, err = parseDurationEnv("CONVERSATION_TTL", conversationTTL)
+    }
+    if err != nil {
+        logFatal("Invalid conversation configuration", map[string]interface{}{
+            "error": err.Error(),
         })
-        
-        return c.Reply(message)
     }
 
-    // Check if this is a reply to another message
-    if c.Message().ReplyTo == nil {
-        logJSON("warn", "Command used without reply", map[string]interface{}{
-            "user":    getUserInfo(c),
-            "chat":    getChatInfo(c),
-            "command": "/opinion",
+    // Reply language: fixed per chat, otherwise detected from the message or the page title
+    fixedChatLanguages, err = parseChatLanguages(os.Getenv("CHAT_LANGUAGES"))
+    if err == nil {
+        detectTitleLanguage, err = parseBoolEnv("DETECT_TITLE_LANGUAGE", false)
+    }
+    if err != nil {
+        logFatal("Invalid language configuration", map[string]interface{}{
+            "error": err.Error(),
         })
-        return c.Reply("Please use /opinion as a reply to a message")
     }
 
-    // Check if we've already processed this message
-    messageID := c.Message().ReplyTo.ID
-    userID := c.Sender().ID
-    ctx := context.Background()
-    
-    alreadyProcessed := false
-    if redisClient != nil {
-        cacheKey := fmt.Sprintf("opinion:%d:%d", c.Chat().ID, messageID)
-        exists, err := redisClient.Exists(ctx, cacheKey).Result()
-        if err == nil && exists > 0 {
-            logJSON("info", "Duplicate opinion request detected", map[string]interface{}{
-                "user":       getUserInfo(c),
-                "chat":       getChatInfo(c),
-                "message_id": messageID,
-            })
-            alreadyProcessed = true
-            return c.Reply("I've already answered, try to use search")
-        }
+    // Webhook mode: Telegram posts updates to WEBHOOK_URL instead of long polling
+    webhook, err := loadWebhookConfig()
+    if err != nil {
+        logFatal("Invalid webhook configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
     }
-    
-    // Rate limiting: only apply to NEW messages (not already processed) and non-excluded users
-    if !alreadyProcessed && redisClient != nil && !isExcludedUser(userID, excludedUserIDs) {
-        rateLimitKey := fmt.Sprintf("ratelimit:%d", userID)
-        now := time.Now()
-        timeRange := now.Add(-24 * time.Hour)
-        
-        // Remove old entries (older than timeRange)
-        redisClient.ZRemRangeByScore(ctx, rateLimitKey, "0", fmt.Sprintf("%d", timeRange.Unix()))
-        
-        // Count recent attempts
-        count, err := redisClient.ZCount(ctx, rateLimitKey, fmt.Sprintf("%d", timeRange.Unix()), "+inf").Result()
-        if err == nil && count >= 5 {
-            logJSON("warn", "Rate limit exceeded", map[string]interface{}{
-                "user":  getUserInfo(c),
-                "chat":  getChatInfo(c),
-                "count": count,
-            })
-            return c.Reply("⚠️ You've reached the limit of 5 opinions per day for new messages. Already analyzed messages can still be searched.")
-        }
-        
-        // Add current attempt to rate limit tracking
-        redisClient.ZAdd(ctx, rateLimitKey, redis.Z{
-            Score:  float64(now.Unix()),
-            Member: fmt.Sprintf("%d:%d", c.Chat().ID, messageID),
+
+    // Health, readiness and version endpoints
+    if listen := os.Getenv("ADMIN_LISTEN"); listen != "" {
+        adminListen = listen
+    }
+
+    // Time in-flight requests get to finish after SIGTERM; 0 cancels them right away
+    shutdownGracePeriod, err = parseNonNegativeDurationEnv("SHUTDOWN_GRACE_PERIOD", shutdownGracePeriod)
+    if err != nil {
+        logFatal("Invalid shutdown configuration", map[string]interface{}{
+            "error": err.Error(),
         })
-        // Set expiration to 2 days
-        redisClient.Expire(ctx, rateLimitKey, 48*time.Hour)
     }
-    
-    // Get the text from the replied message
-    originalText := c.Message().ReplyTo.Text
-    if originalText == "" {
-        logJSON("warn", "Replied message has no text", map[string]interface{}{
-            "user": getUserInfo(c),
-            "chat": getChatInfo(c),
+
+    // Price table for the LLM usage accounting
+    modelPrices, err = parseModelPrices(os.Getenv("LLM_PRICES"))
+    if err != nil {
+        logFatal("Invalid LLM price configuration", map[string]interface{}{
+            "error": err.Error(),
         })
-        return c.Reply("The replied message has no text to analyze")
     }
 
-    logJSON("info", "Processing opinion request", map[string]interface{}{
-        "user":        getUserInfo(c),
-        "chat":        getChatInfo(c),
-        "text_length": len(originalText),
-    })
+    // Spending caps, degraded to a cheaper setup near a cap
+    budget, err = loadBudgetConfig()
+    if err != nil {
+        logFatal("Invalid budget configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
 
-    // Process the message through the opinion function
-    opinion, success := getOpinion(originalText)
+    // Model, thinking and sampling settings per command and tone
+    llmSettings, err = loadGenerationConfig()
+    if err != nil {
+        logFatal("Invalid LLM settings configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
 
-    // Store in Redis that we've processed this message (only if successful)
-    if success && redisClient != nil {
-        cacheKey := fmt.Sprintf("opinion:%d:%d", c.Chat().ID, messageID)
-        // Store for 30 days
-        err := redisClient.Set(ctx, cacheKey, time.Now().Unix(), 30*24*time.Hour).Err()
+    // Opinions blocked by the safety filters may be retried in a softer tone
+    safetyRetry, err = parseBoolEnv("SAFETY_RETRY", false)
+    if err != nil {
+        logFatal("Invalid LLM settings configuration", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+
+    // Traces are exported over OTLP when OTEL_EXPORTER_OTLP_ENDPOINT is set
+    shutdownTracing := func(context.Context) error { return nil }
+    if tracingEnabled() {
+        shutdownTracing, err = setupTracing(ctx)
         if err != nil {
-            logJSON("warn", "Failed to cache opinion result", map[string]interface{}{
+            logFatal("Invalid tracing configuration", map[string]interface{}{
                 "error": err.Error(),
             })
         }
     }
 
-    // Reply logic:
-    // - If success (new answer with URL processed) -> reply to original message
-    // - If not success (no URL or error) -> reply to command message
-    
-    logJSON("debug", "Preparing to send reply", map[string]interface{}{
-        "success":         success,
-        "opinion_length":  len(opinion),
-        "opinion_preview": truncateString(opinion, 100),
+    logJSON("info", "Configuration loaded", map[string]interface{}{
+        "allowed_chats":      allowlist.List(),
+        "excluded_users":     excludedUserIDs,
+        "owner_users":        ownerUserIDs,
+        "access_request_ttl": accessRequestTTL.String(),
+        "leave_on_deny":      leaveOnDeny,
+        "tldr_length":        tldrLength,
+        "text_chats":         texts.chatIDs,
+        "text_min_length":    texts.minLength,
+        "image_max_size_mb":  imageMaxMB,
+        "doc_max_size_mb":    documentMaxMB,
+        "audio_max_duration": audioMaxDuration.String(),
+        "audio_cost":         audioCost,
+        "transcriber":        transcriberName,
+        "conversation_turns": conversationMaxTurns,
+        "llm_prices":         modelPrices,
+        "budget_caps":        budget.Caps,
+        "budget_degrade_at":  budget.DegradeAt,
+        "budget_fallback":    budget.FallbackModel,
+        "llm_settings":       generationSettingsLog(llmSettings),
+        "safety_retry":       safetyRetry,
+        "conversation_ttl":   conversationTTL.String(),
+        "chat_languages":     fixedChatLanguages,
+        "title_language":     detectTitleLanguage,
+        "webhook_url":        webhook.PublicURL,
+        "shutdown_grace":     shutdownGracePeriod.String(),
+        "admin_listen":       adminListen,
+        "tracing":            tracingEnabled(),
+        "version":            buildVersion,
+        "group_link":         groupLink,
     })
-    
-    if success {
-        logJSON("success", "Replying to original message", map[string]interface{}{
-            "user":               getUserInfo(c),
-            "chat":               getChatInfo(c),
-            "original_msg_id":    c.Message().ReplyTo.ID,
-            "command_msg_id":     c.Message().ID,
-            "chat_id":            c.Chat().ID,
+
+    pref := tele.Settings{
+        Token:  botToken,
+        Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+    }
+
+    bot, err := tele.NewBot(pref)
+    if err != nil {
+        logFatal("Failed to create bot", map[string]interface{}{
+            "error": err.Error(),
         })
-        // Success: reply to the original message (the one with URL)
-        _, err := c.Bot().Send(c.Chat(), opinion, &tele.SendOptions{
-            ReplyTo:               c.Message().ReplyTo,
-            DisableWebPagePreview: true,
+    }
+
+    webhookAPI, err := newWebhookAPI(bot)
+    if err != nil {
+        logFatal("Failed to create webhook client", map[string]interface{}{
+            "error": err.Error(),
         })
+    }
+
+    var poller *webhookPoller
+    if webhook.Enabled() {
+        poller, err = startWebhook(webhookAPI, webhook)
         if err != nil {
-            logJSON("error", "Failed to reply to original message", map[string]interface{}{
+            logFatal("Failed to start webhook", map[string]interface{}{
                 "error": err.Error(),
             })
         }
-        return err
-    } else {
-        logJSON("info", "Replying to command message", map[string]interface{}{
-            "user":           getUserInfo(c),
-            "chat":           getChatInfo(c),
-            "command_msg_id": c.Message().ID,
+        bot.Poller = poller
+    } else if err := webhookAPI.RemoveWebhook(); err != nil {
+        // A webhook left over from webhook mode would make long polling fail
+        logJSON("warn", "Failed to delete webhook", map[string]interface{}{
+            "error": err.Error(),
         })
-        // Error or no URL: reply to the command message
-        return c.Reply(opinion, &tele.SendOptions{
-            DisableWebPagePreview: true,
+    }
+
+    logJSON("info", "Bot started successfully", map[string]interface{}{
+        "bot_username": bot.Me.Username,
+        "bot_id":       bot.Me.ID,
+    })
+
+    // Track in-flight requests so shutdown can wait for them
+    bot.Use(trackRequests(inflight))
+
+    adminServer, err := startAdminServer(adminListen, newAdminMux(readinessChecks(bot)))
+    if err != nil {
+        logFatal("Failed to start admin server", map[string]interface{}{
+            "error": err.Error(),
         })
     }
-}
 
-// logRequest logs information about incoming requests
-func logRequest(c tele.Context, command string) {
-    logJSON("request", "Command received", map[string]interface{}{
-        "command":    command,
-        "user":       getUserInfo(c),
-        "chat":       getChatInfo(c),
-        "message_id": c.Message().ID,
+    // Register commands; /help and the Telegram menu are generated from the registry
+    registry := newCommandRegistry(ownerUserIDs)
+    registry.Register(opinionCommand(allowlist, excludedUserIDs, texts))
+    registry.Register(tldrCommand(allowlist, excludedUserIDs, tldrLength))
+    registry.Register(factcheckCommand(allowlist, excludedUserIDs))
+    registry.Register(botCommand{
+        Name:        "help",
+        Description: "List available commands",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleHelpCommand(c, registry)
+        },
+    })
+    registry.Register(botCommand{
+        Name:        "start",
+        Description: "Introduction and list of commands",
+        Scopes:      []commandScope{scopePrivate},
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleStartCommand(c, registry)
+        },
+    })
+
+    // Owner commands for managing the allowlist and reviewing spend
+    registry.Register(botCommand{
+        Name:        "allowchat",
+        Description: "Allow a chat: /allowchat [chat_id]",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        OwnerOnly:   true,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleAllowChatCommand(c, allowlist)
+        },
+    })
+    registry.Register(botCommand{
+        Name:        "denychat",
+        Description: "Deny a chat: /denychat [chat_id]",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        OwnerOnly:   true,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleDenyChatCommand(c, allowlist)
+        },
+    })
+    registry.Register(botCommand{
+        Name:        "listchats",
+        Description: "List allowed chats",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        OwnerOnly:   true,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleListChatsCommand(c, allowlist)
+        },
+    })
+    registry.Register(botCommand{
+        Name:        "usage",
+        Description: "LLM token spend: /usage [days]",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        OwnerOnly:   true,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleUsageCommand(c)
+        },
+    })
+    registry.Register(botCommand{
+        Name:        "budget",
+        Description: "LLM spending caps: /budget [scope] [usd|off|default]",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        OwnerOnly:   true,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleBudgetCommand(c)
+        },
+    })
+
+    registry.Install(bot)
+    if err := registry.SyncMenu(bot); err != nil {
+        logJSON("warn", "Failed to update Telegram command menu", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+
+    // Replies to the bot's opinions continue the conversation
+    bot.Handle(tele.OnText, func(c tele.Context) error {
+        return handleFollowUp(c, bot, bot.Me.ID, allowlist, excludedUserIDs)
+    })
+
+    // Access requests from groups the bot was added to
+    bot.Handle(tele.OnAddedToGroup, func(c tele.Context) error {
+        return handleAddedToGroup(c, bot, allowlist, accessRequestList, ownerUserIDs)
+    })
+    bot.Handle(&tele.Btn{Unique: accessApproveUnique}, func(c tele.Context) error {
+        return handleAccessDecision(c, bot, allowlist, accessRequestList, ownerUserIDs, true, leaveOnDeny)
+    })
+    bot.Handle(&tele.Btn{Unique: accessDenyUnique}, func(c tele.Context) error {
+        return handleAccessDecision(c, bot, allowlist, accessRequestList, ownerUserIDs, false, leaveOnDeny)
     })
-}
 
-// logJSON outputs structured JSON logs
-func logJSON(level string, message string, data map[string]interface{}) {
-    logEntry := map[string]interface{}{
-        "timestamp": time.Now().Format(time.RFC3339),
-        "level":     level,
-        "message":   message,
+    // SIGINT/SIGTERM stop the poller; a second signal exits immediately
+    signals := make(chan os.Signal, 2)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+    go func() {
+        sig := <-signals
+        logJSON("info", "Shutdown signal received", map[string]interface{}{
+            "signal":       sig.String(),
+            "grace_period": shutdownGracePeriod.String(),
+        })
+        go func() {
+            <-signals
+            logFatal("Forced shutdown", nil)
+        }()
+        bot.Stop()
+    }()
+
+    logJSON("info", "Bot is running and waiting for messages", nil)
+    bot.Start()
+
+    // Let in-flight requests finish; stragglers are cancelled and asked to retry
+    if !inflight.Drain(shutdownGracePeriod, shutdownCancelWait) {
+        logJSON("warn", "Some requests did not finish before shutdown", nil)
     }
-    
-    if data != nil {
-        for k, v := range data {
-            logEntry[k] = v
-        }
+
+    if poller != nil {
+        stopWebhook(webhookAPI)
     }
-    
-    jsonBytes, err := json.Marshal(logEntry)
-    if err != nil {
-        log.Printf("Error marshaling log: %v", err)
-        return
+
+    shutdownCtx, cancelShutdown := context.WithTimeout(ctx, 5*time.Second)
+    defer cancelShutdown()
+    if err := adminServer.Shutdown(shutdownCtx); err != nil {
+        logJSON("warn", "Failed to shut down admin server", map[string]interface{}{
+            "error": err.Error(),
+        })
     }
-    
-    fmt.Println(string(jsonBytes))
+    if err := shutdownTracing(shutdownCtx); err != nil {
+        logJSON("warn", "Failed to flush traces", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+
+    stopMonitor()
+    if err := redisClient.Close(); err != nil {
+        logJSON("warn", "Failed to close Redis connection", map[string]interface{}{
+            "error": err.Error(),
+        })
+    }
+    logJSON("info", "Bot stopped", nil)
 }
 
-// logFatal outputs structured JSON fatal log and exits the program
-func logFatal(message string, data map[string]interface{}) {
-    logEntry := map[string]interface{}{
-        "timestamp": time.Now().Format(time.RFC3339),
-        "level":     "fatal",
-        "message":   message,
+// opinionCommand describes the /opinion command
+func opinionCommand(allowlist *chatAllowlist, excludedUserIDs []int64, texts *textAnalysis) botCommand {
+    return botCommand{
+        Name:        "opinion",
+        Description: "Share an opinion about the link in the replied message",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        NeedsReply:  true,
+        Cost:        1,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleOpinionCommand(c, allowlist, excludedUserIDs, cmd.Cost, texts)
+        },
     }
-    
-    if data != nil {
-        for k, v := range data {
-            logEntry[k] = v
-        }
+}
+
+// tldrCommand describes the /tldr command
+func tldrCommand(allowlist *chatAllowlist, excludedUserIDs []int64, defaultLength SummaryLength) botCommand {
+    return botCommand{
+        Name:        "tldr",
+        Description: "Neutral summary of the linked content: /tldr [short|medium|bullets]",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        NeedsReply:  true,
+        Cost:        1,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleTLDRCommand(c, allowlist, excludedUserIDs, cmd.Cost, defaultLength)
+        },
     }
-    
-    jsonBytes, err := json.Marshal(logEntry)
-    if err != nil {
-        log.Fatalf("Error marshaling fatal log: %v", err)
+}
+
+// factcheckCommand describes the /factcheck command; grounding with search costs more
+func factcheckCommand(allowlist *chatAllowlist, excludedUserIDs []int64) botCommand {
+    return botCommand{
+        Name:        "factcheck",
+        Description: "Rate the claims of the linked content with sources",
+        Scopes:      []commandScope{scopeGroup, scopePrivate},
+        NeedsReply:  true,
+        Cost:        2,
+        Handler: func(c tele.Context, cmd botCommand) error {
+            return handleAnalysisCommand(c, allowlist, excludedUserIDs, cmd.Cost, factcheckMode)
+        },
     }
-    
-    fmt.Println(string(jsonBytes))
-    os.Exit(1)
+}
+
+// handleOpinionCommand handles /opinion; cost is the number of rate-limit units a new request consumes,
+// texts enables plain-text analysis in some chats and may be nil
+func handleOpinionCommand(c tele.Context, allowlist *chatAllowlist, excludedUserIDs []int64, cost int, texts *textAnalysis) error {
+    return handleAnalysisCommand(c, allowlist, excludedUserIDs, cost, opinionMode(texts))
+}
+
+// logRequest logs information about incoming requests
+func logRequest(c tele.Context, command string) {
+    logJSON("request", "Command received", map[string]interface{}{
+        "command":    command,
+        "user":       getUserInfo(c),
+        "chat":       getChatInfo(c),
+        "message_id": c.Message().ID,
+    })
 }
 
 // parseAllowedChatIDs parses comma-separated chat IDs from environment variable
@@ -332,7 +585,10 @@ func parseAllowedChatIDs(chatsStr string) []int64 {
         
         chatID, err := strconv.ParseInt(part, 10, 64)
         if err != nil {
-            log.Printf("Warning: invalid chat ID '%s': %v", part, err)
+            logJSON("warn", "Invalid chat ID", map[string]interface{}{
+                "value": part,
+                "error": err.Error(),
+            })
             continue
         }
         
@@ -342,17 +598,9 @@ func parseAllowedChatIDs(chatsStr string) []int64 {
     return chatIDs
 }
 
-// isAllowedChat checks if the current chat is in the allowed list
-func isAllowedChat(c tele.Context, allowedChatIDs []int64) bool {    
-    currentChatID := c.Chat().ID
-    
-    for _, allowedID := range allowedChatIDs {
-        if currentChatID == allowedID {
-            return true
-        }
-    }
-    
-    return false
+// isAllowedChat checks if the current chat is in the live allowlist
+func isAllowedChat(c tele.Context, allowlist *chatAllowlist) bool {
+    return allowlist.Contains(c.Chat().ID)
 }
 
 // getUserInfo returns formatted user information
@@ -400,7 +648,10 @@ func parseExcludedUserIDs(usersStr string) []int64 {
         
         userID, err := strconv.ParseInt(part, 10, 64)
         if err != nil {
-            log.Printf("Warning: invalid excluded user ID '%s': %v", part, err)
+            logJSON("warn", "Invalid excluded user ID", map[string]interface{}{
+                "value": part,
+                "error": err.Error(),
+            })
             continue
         }
         
@@ -140,10 +140,10 @@ func TestParseExcludedUserIDs(t *testing.T) {
 
 func TestIsExcludedUser(t *testing.T) {
 	tests := []struct {
-		name       string
-		envValue   string
-		userID     int64
-		expected   bool
+		name     string
+		envValue string
+		userID   int64
+		expected bool
 	}{
 		{
 			name:     "User is excluded",
@@ -306,9 +306,12 @@ func TestLogJSONDataValues(t *testing.T) {
 
 // MockContext implements a minimal mock for tele.Context
 type MockContext struct {
-	chat    *tele.Chat
-	sender  *tele.User
-	message *tele.Message
+	chat     *tele.Chat
+	sender   *tele.User
+	message  *tele.Message
+	args     []string
+	callback *tele.Callback
+	bot      *tele.Bot
 }
 
 func (m *MockContext) Chat() *tele.Chat {
@@ -324,50 +327,50 @@ func (m *MockContext) Message() *tele.Message {
 }
 
 // Implement other required interface methods with stubs
-func (m *MockContext) Bot() *tele.Bot                                       { return nil }
-func (m *MockContext) Update() tele.Update                                  { return tele.Update{} }
-func (m *MockContext) Callback() *tele.Callback                             { return nil }
-func (m *MockContext) Query() *tele.Query                                   { return nil }
-func (m *MockContext) InlineResult() *tele.InlineResult                     { return nil }
-func (m *MockContext) ShippingQuery() *tele.ShippingQuery                   { return nil }
-func (m *MockContext) PreCheckoutQuery() *tele.PreCheckoutQuery             { return nil }
-func (m *MockContext) Poll() *tele.Poll                                     { return nil }
-func (m *MockContext) PollAnswer() *tele.PollAnswer                         { return nil }
-func (m *MockContext) ChatMember() *tele.ChatMemberUpdate                   { return nil }
-func (m *MockContext) ChatJoinRequest() *tele.ChatJoinRequest               { return nil }
-func (m *MockContext) Migration() (int64, int64)                            { return 0, 0 }
-func (m *MockContext) Topic() *tele.Topic                                   { return nil }
-func (m *MockContext) Recipient() tele.Recipient                            { return nil }
-func (m *MockContext) Text() string                                         { return "" }
-func (m *MockContext) Entities() tele.Entities                              { return nil }
-func (m *MockContext) Data() string                                         { return "" }
-func (m *MockContext) Args() []string                                       { return nil }
-func (m *MockContext) Send(what interface{}, opts ...interface{}) error     { return nil }
-func (m *MockContext) SendAlbum(a tele.Album, opts ...interface{}) error    { return nil }
-func (m *MockContext) Reply(what interface{}, opts ...interface{}) error    { return nil }
-func (m *MockContext) Forward(msg tele.Editable, opts ...interface{}) error { return nil }
-func (m *MockContext) ForwardTo(to tele.Recipient, opts ...interface{}) error { return nil }
-func (m *MockContext) Edit(what interface{}, opts ...interface{}) error     { return nil }
-func (m *MockContext) EditCaption(caption string, opts ...interface{}) error { return nil }
-func (m *MockContext) EditOrSend(what interface{}, opts ...interface{}) error { return nil }
+func (m *MockContext) Bot() *tele.Bot                                          { return m.bot }
+func (m *MockContext) Update() tele.Update                                     { return tele.Update{} }
+func (m *MockContext) Callback() *tele.Callback                                { return m.callback }
+func (m *MockContext) Query() *tele.Query                                      { return nil }
+func (m *MockContext) InlineResult() *tele.InlineResult                        { return nil }
+func (m *MockContext) ShippingQuery() *tele.ShippingQuery                      { return nil }
+func (m *MockContext) PreCheckoutQuery() *tele.PreCheckoutQuery                { return nil }
+func (m *MockContext) Poll() *tele.Poll                                        { return nil }
+func (m *MockContext) PollAnswer() *tele.PollAnswer                            { return nil }
+func (m *MockContext) ChatMember() *tele.ChatMemberUpdate                      { return nil }
+func (m *MockContext) ChatJoinRequest() *tele.ChatJoinRequest                  { return nil }
+func (m *MockContext) Migration() (int64, int64)                               { return 0, 0 }
+func (m *MockContext) Topic() *tele.Topic                                      { return nil }
+func (m *MockContext) Recipient() tele.Recipient                               { return nil }
+func (m *MockContext) Text() string                                            { return "" }
+func (m *MockContext) Entities() tele.Entities                                 { return nil }
+func (m *MockContext) Data() string                                            { return "" }
+func (m *MockContext) Args() []string                                          { return m.args }
+func (m *MockContext) Send(what interface{}, opts ...interface{}) error        { return nil }
+func (m *MockContext) SendAlbum(a tele.Album, opts ...interface{}) error       { return nil }
+func (m *MockContext) Reply(what interface{}, opts ...interface{}) error       { return nil }
+func (m *MockContext) Forward(msg tele.Editable, opts ...interface{}) error    { return nil }
+func (m *MockContext) ForwardTo(to tele.Recipient, opts ...interface{}) error  { return nil }
+func (m *MockContext) Edit(what interface{}, opts ...interface{}) error        { return nil }
+func (m *MockContext) EditCaption(caption string, opts ...interface{}) error   { return nil }
+func (m *MockContext) EditOrSend(what interface{}, opts ...interface{}) error  { return nil }
 func (m *MockContext) EditOrReply(what interface{}, opts ...interface{}) error { return nil }
-func (m *MockContext) Delete() error                                        { return nil }
-func (m *MockContext) DeleteAfter(d time.Duration) *time.Timer              { return nil }
-func (m *MockContext) Notify(action tele.ChatAction) error                  { return nil }
-func (m *MockContext) Ship(what ...interface{}) error                       { return nil }
-func (m *MockContext) Accept(errorMessage ...string) error                  { return nil }
-func (m *MockContext) Answer(resp *tele.QueryResponse) error                { return nil }
-func (m *MockContext) Respond(resp ...*tele.CallbackResponse) error         { return nil }
-func (m *MockContext) Get(key string) interface{}                           { return nil }
-func (m *MockContext) Set(key string, val interface{})                      {}
-func (m *MockContext) ThreadID() int                                        { return 0 }
+func (m *MockContext) Delete() error                                           { return nil }
+func (m *MockContext) DeleteAfter(d time.Duration) *time.Timer                 { return nil }
+func (m *MockContext) Notify(action tele.ChatAction) error                     { return nil }
+func (m *MockContext) Ship(what ...interface{}) error                          { return nil }
+func (m *MockContext) Accept(errorMessage ...string) error                     { return nil }
+func (m *MockContext) Answer(resp *tele.QueryResponse) error                   { return nil }
+func (m *MockContext) Respond(resp ...*tele.CallbackResponse) error            { return nil }
+func (m *MockContext) Get(key string) interface{}                              { return nil }
+func (m *MockContext) Set(key string, val interface{})                         {}
+func (m *MockContext) ThreadID() int                                           { return 0 }
 
 func TestIsAllowedChat(t *testing.T) {
 	tests := []struct {
-		name       string
-		envValue   string
-		chatID     int64
-		expected   bool
+		name     string
+		envValue string
+		chatID   int64
+		expected bool
 	}{
 		{
 			name:     "Chat is allowed",
@@ -411,14 +414,14 @@ func TestIsAllowedChat(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			os.Setenv("ALLOWED_CHAT_IDS", tt.envValue)
 			defer os.Unsetenv("ALLOWED_CHAT_IDS")
-			
+
 			allowedChatIds := parseAllowedChatIDs(tt.envValue)
 
 			mockCtx := &MockContext{
 				chat: &tele.Chat{ID: tt.chatID},
 			}
 
-			result := isAllowedChat(mockCtx, allowedChatIds)
+			result := isAllowedChat(mockCtx, newChatAllowlist(allowedChatIds))
 
 			if result != tt.expected {
 				t.Errorf("isAllowedChat() with chatID=%d and env=%q = %v, want %v",
@@ -895,7 +898,7 @@ func TestIsAllowedChatEdgeCases(t *testing.T) {
 				chat: &tele.Chat{ID: tt.chatID},
 			}
 
-			result := isAllowedChat(mockCtx, allowedChatIds)
+			result := isAllowedChat(mockCtx, newChatAllowlist(allowedChatIds))
 
 			if result != tt.expected {
 				t.Errorf("isAllowedChat() with chatID=%d and env=%q = %v, want %v",
@@ -1474,7 +1477,7 @@ func TestHandleOpinionCommandUnauthorizedChat(t *testing.T) {
 				},
 			}
 
-			err := handleOpinionCommand(mockCtx, parseAllowedChatIDs(os.Getenv("ALLOWED_CHAT_IDS")), parseExcludedUserIDs(os.Getenv("EXCLUDED_USER_IDS")))
+			err := handleOpinionCommand(mockCtx, newChatAllowlist(parseAllowedChatIDs(os.Getenv("ALLOWED_CHAT_IDS"))), parseExcludedUserIDs(os.Getenv("EXCLUDED_USER_IDS")), 1, nil)
 
 			w.Close()
 			os.Stdout = oldStdout
@@ -1507,7 +1510,7 @@ func (m *MockContextWithReply) Reply(what interface{}, opts ...interface{}) erro
 	return nil
 }
 
-// TestHandleOpinionCommandNoReply tests handleOpinionCommand when message has no reply
+// TestHandleOpinionCommandNoReply tests the /opinion command when message has no reply
 func TestHandleOpinionCommandNoReply(t *testing.T) {
 	// Save and restore environment
 	originalAllowedChats := os.Getenv("ALLOWED_CHAT_IDS")
@@ -1548,7 +1551,8 @@ func TestHandleOpinionCommandNoReply(t *testing.T) {
 		},
 	}
 
-	err := handleOpinionCommand(mockCtx, parseAllowedChatIDs(os.Getenv("ALLOWED_CHAT_IDS")), parseExcludedUserIDs(os.Getenv("EXCLUDED_USER_IDS")))
+	cmd := opinionCommand(newChatAllowlist(parseAllowedChatIDs(os.Getenv("ALLOWED_CHAT_IDS"))), parseExcludedUserIDs(os.Getenv("EXCLUDED_USER_IDS")), nil)
+	err := newCommandRegistry(nil).dispatch(cmd)(mockCtx)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -1611,7 +1615,7 @@ func TestHandleOpinionCommandEmptyReplyText(t *testing.T) {
 		},
 	}
 
-	err := handleOpinionCommand(mockCtx, parseAllowedChatIDs(os.Getenv("ALLOWED_CHAT_IDS")), parseExcludedUserIDs(os.Getenv("EXCLUDED_USER_IDS")))
+	err := handleOpinionCommand(mockCtx, newChatAllowlist(parseAllowedChatIDs(os.Getenv("ALLOWED_CHAT_IDS"))), parseExcludedUserIDs(os.Getenv("EXCLUDED_USER_IDS")), 1, nil)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -1629,3 +1633,76 @@ func TestHandleOpinionCommandEmptyReplyText(t *testing.T) {
 		t.Errorf("Reply message = %q, want %q", replyMessage, expectedMessage)
 	}
 }
+
+// TestHandleOpinionCommandRedisUnavailablePolicy tests rate limiting behaviour while Redis is down
+func TestHandleOpinionCommandRedisUnavailablePolicy(t *testing.T) {
+	originalClient := redisClient
+	originalPolicy := redisUnavailablePolicy
+	defer func() {
+		redisClient = originalClient
+		redisUnavailablePolicy = originalPolicy
+	}()
+	redisClient = nil
+
+	tests := []struct {
+		name          string
+		policy        string
+		excluded      string
+		expectedReply string
+	}{
+		{
+			name:          "Fail open skips rate limiting",
+			policy:        redisFailOpen,
+			expectedReply: "The replied message has no text to analyze",
+		},
+		{
+			name:          "Fail closed rejects new requests",
+			policy:        redisFailClosed,
+			expectedReply: "⚠️ I can't check rate limits right now, please try again later.",
+		},
+		{
+			name:          "Fail closed still allows excluded users",
+			policy:        redisFailClosed,
+			excluded:      "123456789",
+			expectedReply: "The replied message has no text to analyze",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redisUnavailablePolicy = tt.policy
+
+			oldStdout := os.Stdout
+			_, w, _ := os.Pipe()
+			os.Stdout = w
+
+			replyMessage := ""
+			mockCtx := &MockContextWithReply{
+				MockContext: MockContext{
+					chat:   &tele.Chat{ID: -1001234567890, Type: tele.ChatGroup},
+					sender: &tele.User{ID: 123456789, Username: "testuser"},
+					message: &tele.Message{
+						ID:      42,
+						ReplyTo: &tele.Message{ID: 41},
+					},
+				},
+				replyFunc: func(what interface{}, opts ...interface{}) error {
+					replyMessage = what.(string)
+					return nil
+				},
+			}
+
+			err := handleOpinionCommand(mockCtx, newChatAllowlist([]int64{-1001234567890}), parseExcludedUserIDs(tt.excluded), 1, nil)
+
+			w.Close()
+			os.Stdout = oldStdout
+
+			if err != nil {
+				t.Errorf("handleOpinionCommand returned error: %v", err)
+			}
+			if replyMessage != tt.expectedReply {
+				t.Errorf("Reply message = %q, want %q", replyMessage, tt.expectedReply)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"path"
+	"strings"
+)
+
+// messageKey identifies a user-facing string in the locale files
+type messageKey string
+
+const (
+	msgNoText               messageKey = "no_text"                // replied message is empty
+	msgNoTextReply          messageKey = "no_text_reply"          // replied message has nothing to analyze
+	msgTired                messageKey = "tired"                  // the LLM request failed
+	msgRestarting           messageKey = "restarting"             // the bot is shutting down
+	msgRateLimited          messageKey = "rate_limited"           // plural, the count is the daily limit
+	msgRateLimitUnavailable messageKey = "rate_limit_unavailable" // Redis is down and the policy is fail_closed
+	msgNotAuthorizedPrivate messageKey = "not_authorized_private" // %s is the group link
+	msgNotAuthorizedGroup   messageKey = "not_authorized_group"   // %s is the group link
+	msgDuplicateOpinion     messageKey = "duplicate_opinion"      // message already has an opinion
+	msgDuplicateSummary     messageKey = "duplicate_summary"      // message already has a summary
+	msgDuplicateFactCheck   messageKey = "duplicate_factcheck"    // message already has a fact check
+	msgTLDRUsage            messageKey = "tldr_usage"             // unknown /tldr length
+	msgNoTextSummary        messageKey = "no_text_summary"        // /tldr on an empty message
+	msgNoLinkSummary        messageKey = "no_link_summary"        // /tldr on a message without a link
+	msgSummaryFailed        messageKey = "summary_failed"         // the summary request failed
+	msgNoTextFactCheck      messageKey = "no_text_factcheck"      // /factcheck on an empty message
+	msgNoLinkFactCheck      messageKey = "no_link_factcheck"      // /factcheck on a message without a link
+	msgFactCheckFailed      messageKey = "factcheck_failed"       // the fact check request failed
+	msgFactCheckSources     messageKey = "factcheck_sources"      // heading of the cited sources
+	msgBlocked              messageKey = "blocked"                // the safety filters blocked a /tldr or /factcheck answer
+	msgBlockedBullshit      messageKey = "blocked_bullshit"       // the safety filters blocked an answer, per tone
+	msgBlockedPositive      messageKey = "blocked_positive"
+	msgBlockedNegative      messageKey = "blocked_negative"
+	msgRecited              messageKey = "recited"          // a /tldr or /factcheck answer stopped for reciting the source
+	msgRecitedBullshit      messageKey = "recited_bullshit" // an answer stopped for reciting the source, per tone
+	msgRecitedPositive      messageKey = "recited_positive"
+	msgRecitedNegative      messageKey = "recited_negative"
+	msgTruncated            messageKey = "truncated"          // a /tldr or /factcheck answer hit the output token limit
+	msgTruncatedBullshit    messageKey = "truncated_bullshit" // an answer hit the output token limit, per tone
+	msgTruncatedPositive    messageKey = "truncated_positive"
+	msgTruncatedNegative    messageKey = "truncated_negative"
+	msgLLMTimeout           messageKey = "llm_timeout"            // the LLM request timed out
+	msgLLMQuota             messageKey = "llm_quota"              // the API quota is exhausted
+	msgFetchFailed          messageKey = "fetch_failed"           // the model could not open the link
+	msgBlockedDomain        messageKey = "blocked_domain"         // the link was refused as unsafe
+	msgImageTooLarge        messageKey = "image_too_large"        // %d is the limit in MB
+	msgImageUnsupported     messageKey = "image_unsupported"      // unknown image format
+	msgImageDownloadFailed  messageKey = "image_download_failed"  // Telegram download failed
+	msgDocumentTooLarge     messageKey = "document_too_large"     // %d is the limit in MB
+	msgDocumentNoText       messageKey = "document_no_text"       // nothing extracted from the document
+	msgDocumentReadFailed   messageKey = "document_read_failed"   // download or extraction failed
+	msgAudioTooLong         messageKey = "audio_too_long"         // %s is the duration limit
+	msgAudioTooLarge        messageKey = "audio_too_large"        // %d is the limit in MB
+	msgAudioDownloadFailed  messageKey = "audio_download_failed"  // Telegram download failed
+	msgTranscriptionFailed  messageKey = "transcription_failed"   // the transcriber failed
+	msgTranscriptEmpty      messageKey = "transcript_empty"       // the transcriber returned nothing
+	msgConversationLimit    messageKey = "conversation_limit"     // thread reached CONVERSATION_MAX_TURNS
+	msgCommandPrivateOnly   messageKey = "command_private_only"   // %s is the command name
+	msgCommandGroupsOnly    messageKey = "command_groups_only"    // %s is the command name
+	msgCommandNeedsReply    messageKey = "command_needs_reply"    // %s is the command name
+	msgHelpHeader           messageKey = "help_header"            // first line of /help
+	msgHelpNeedsReply       messageKey = "help_needs_reply"       // suffix of reply commands in /help
+	msgStartGreeting        messageKey = "start_greeting"         // /start introduction
+	msgOwnerOnly            messageKey = "owner_only"             // owner command used by someone else
+	msgAllowChatUsage       messageKey = "allowchat_usage"        // invalid /allowchat argument
+	msgDenyChatUsage        messageKey = "denychat_usage"         // invalid /denychat argument
+	msgChatAllowed          messageKey = "chat_allowed"           // %d is the chat ID
+	msgChatDenied           messageKey = "chat_denied"            // %d is the chat ID
+	msgNotPersisted         messageKey = "not_persisted"          // suffix when the allowlist store failed
+	msgNoAllowedChats       messageKey = "no_allowed_chats"       // empty /listchats
+	msgAllowedChats         messageKey = "allowed_chats"          // plural, the count is the number of chats
+	msgUsageHelp            messageKey = "usage_help"             // invalid /usage argument, %d is the maximum days
+	msgUsageUnavailable     messageKey = "usage_unavailable"      // the usage store is down
+	msgUsagePeriod          messageKey = "usage_period"           // plural, the count is the number of days
+	msgUsageEmpty           messageKey = "usage_empty"            // no usage in the period
+	msgUsageTotals          messageKey = "usage_totals"           // requests, prompt, thinking and output tokens, cost in USD
+	msgUsageTopChats        messageKey = "usage_top_chats"        // header of the chat ranking
+	msgUsageTopUsers        messageKey = "usage_top_users"        // header of the user ranking
+	msgUsageEntry           messageKey = "usage_entry"            // plural, the count is the requests; then the ID and cost in USD
+	msgBudgetExhausted      messageKey = "budget_exhausted"       // a spending cap is reached
+	msgBudgetHelp           messageKey = "budget_help"            // invalid /budget arguments
+	msgBudgetUnavailable    messageKey = "budget_unavailable"     // the budget store is down
+	msgBudgetStatus         messageKey = "budget_status"          // first line of /budget, %s is the state
+	msgBudgetOK             messageKey = "budget_ok"              // state within the caps
+	msgBudgetDegraded       messageKey = "budget_degraded"        // state near a cap
+	msgBudgetOver           messageKey = "budget_over"            // state past a cap
+	msgBudgetLimit          messageKey = "budget_limit"           // scope, spent and cap in USD
+	msgBudgetNoLimit        messageKey = "budget_no_limit"        // scope and spent in USD
+	msgBudgetUpdated        messageKey = "budget_updated"         // override field and value
+	msgAccessRequested      messageKey = "access_requested"       // sent to a new group
+	msgAccessGranted        messageKey = "access_granted"         // sent to an approved group
+	msgAccessRequest        messageKey = "access_request"         // owner notice: title, chat ID, username, user ID, TTL
+	msgAccessApproveButton  messageKey = "access_approve_button"  // inline button
+	msgAccessDenyButton     messageKey = "access_deny_button"     // inline button
+	msgAccessOwnersOnly     messageKey = "access_owners_only"     // button pressed by someone else
+	msgAccessInvalid        messageKey = "access_invalid"         // malformed button data
+	msgAccessExpiredNotice  messageKey = "access_expired_notice"  // button pressed after the TTL
+	msgAccessExpired        messageKey = "access_expired"         // %d is the chat ID
+	msgAccessApprovedNotice messageKey = "access_approved_notice" // button feedback
+	msgAccessApproved       messageKey = "access_approved"        // %d is the chat ID
+	msgAccessDeniedNotice   messageKey = "access_denied_notice"   // button feedback
+	msgAccessDenied         messageKey = "access_denied"          // %d is the chat ID
+)
+
+// commandDescriptionPrefix starts the keys of translated command descriptions; English
+// descriptions come from the command registry
+const commandDescriptionPrefix = "command_"
+
+// commandDescriptionKey is the key of a translated command description in /help
+func commandDescriptionKey(name string) messageKey {
+	return messageKey(commandDescriptionPrefix + name)
+}
+
+// messageKeys lists every key the code looks up; each locale must define all of them
+var messageKeys = []messageKey{
+	msgNoText, msgNoTextReply, msgTired, msgRestarting, msgRateLimited, msgRateLimitUnavailable,
+	msgNotAuthorizedPrivate, msgNotAuthorizedGroup,
+	msgDuplicateOpinion, msgDuplicateSummary, msgDuplicateFactCheck, msgTLDRUsage,
+	msgNoTextSummary, msgNoLinkSummary, msgSummaryFailed,
+	msgNoTextFactCheck, msgNoLinkFactCheck, msgFactCheckFailed, msgFactCheckSources,
+	msgBlocked, msgBlockedBullshit, msgBlockedPositive, msgBlockedNegative,
+	msgRecited, msgRecitedBullshit, msgRecitedPositive, msgRecitedNegative,
+	msgTruncated, msgTruncatedBullshit, msgTruncatedPositive, msgTruncatedNegative,
+	msgLLMTimeout, msgLLMQuota, msgFetchFailed, msgBlockedDomain,
+	msgImageTooLarge, msgImageUnsupported, msgImageDownloadFailed,
+	msgDocumentTooLarge, msgDocumentNoText, msgDocumentReadFailed,
+	msgAudioTooLong, msgAudioTooLarge, msgAudioDownloadFailed, msgTranscriptionFailed, msgTranscriptEmpty,
+	msgConversationLimit,
+	msgCommandPrivateOnly, msgCommandGroupsOnly, msgCommandNeedsReply,
+	msgHelpHeader, msgHelpNeedsReply, msgStartGreeting,
+	msgOwnerOnly, msgAllowChatUsage, msgDenyChatUsage, msgChatAllowed, msgChatDenied,
+	msgNotPersisted, msgNoAllowedChats, msgAllowedChats,
+	msgUsageHelp, msgUsageUnavailable, msgUsagePeriod, msgUsageEmpty, msgUsageTotals,
+	msgUsageTopChats, msgUsageTopUsers, msgUsageEntry,
+	msgBudgetExhausted, msgBudgetHelp, msgBudgetUnavailable, msgBudgetStatus,
+	msgBudgetOK, msgBudgetDegraded, msgBudgetOver, msgBudgetLimit, msgBudgetNoLimit, msgBudgetUpdated,
+	msgAccessRequested, msgAccessGranted, msgAccessRequest, msgAccessApproveButton, msgAccessDenyButton,
+	msgAccessOwnersOnly, msgAccessInvalid, msgAccessExpiredNotice, msgAccessExpired,
+	msgAccessApprovedNotice, msgAccessApproved, msgAccessDeniedNotice, msgAccessDenied,
+}
+
+// pluralForm is a CLDR plural category
+type pluralForm string
+
+const (
+	pluralOne   pluralForm = "one"
+	pluralFew   pluralForm = "few"
+	pluralMany  pluralForm = "many"
+	pluralOther pluralForm = "other"
+)
+
+// pluralRules maps a language to its plural rule for whole numbers; languages
+// without a rule use the English one
+var pluralRules = map[string]func(n int) pluralForm{
+	"en": englishPlural,
+	"de": englishPlural,
+	"ru": slavicPlural,
+}
+
+// englishPlural distinguishes one from everything else
+func englishPlural(n int) pluralForm {
+	if n == 1 {
+		return pluralOne
+	}
+	return pluralOther
+}
+
+// slavicPlural follows the East Slavic rule: 1, 21 -> one; 2-4, 22-24 -> few; the rest -> many
+func slavicPlural(n int) pluralForm {
+	if n < 0 {
+		n = -n
+	}
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return pluralOne
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return pluralFew
+	default:
+		return pluralMany
+	}
+}
+
+// pluralFormsOf returns the forms a plural message needs in the language
+func pluralFormsOf(language string) []pluralForm {
+	rule, ok := pluralRules[language]
+	if !ok {
+		rule = englishPlural
+	}
+	seen := make(map[pluralForm]bool)
+	var forms []pluralForm
+	for _, n := range []int{0, 1, 2, 5, 11, 21, 22, 25} {
+		if form := rule(n); !seen[form] {
+			seen[form] = true
+			forms = append(forms, form)
+		}
+	}
+	return forms
+}
+
+// localeMessage is a message in one locale; plain strings are stored as the "other" form
+type localeMessage map[pluralForm]string
+
+// UnmarshalJSON accepts either a string or an object of plural forms
+func (m *localeMessage) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = localeMessage{pluralOther: text}
+		return nil
+	}
+	var forms map[pluralForm]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return fmt.Errorf("expected a string or plural forms: %w", err)
+	}
+	*m = forms
+	return nil
+}
+
+// locale holds the user-facing strings of one language
+type locale struct {
+	Messages map[messageKey]localeMessage `json:"messages"`
+	Refusals []string                     `json:"refusals"` // replies to messages without a link
+}
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// locales maps a language code to its strings; English is the fallback
+var locales = mustLoadLocales(localeFiles)
+
+// loadLocales reads every locales/<language>.json file
+func loadLocales(fsys fs.FS) (map[string]*locale, error) {
+	files, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*locale)
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var loc locale
+		if err := json.Unmarshal(data, &loc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		result[strings.TrimSuffix(path.Base(file), ".json")] = &loc
+	}
+
+	if result[defaultLanguage] == nil {
+		return nil, fmt.Errorf("locale %q is missing", defaultLanguage)
+	}
+	return result, nil
+}
+
+// mustLoadLocales loads the embedded locales; they are part of the binary, so errors are fatal
+func mustLoadLocales(fsys fs.FS) map[string]*locale {
+	result, err := loadLocales(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// lookupMessage finds the message in the language, falling back to English
+func lookupMessage(language string, key messageKey) (localeMessage, bool) {
+	if loc, ok := locales[language]; ok {
+		if message, ok := loc.Messages[key]; ok {
+			return message, true
+		}
+	}
+	message, ok := locales[defaultLanguage].Messages[key]
+	return message, ok
+}
+
+// localize returns the message in the language, falling back to English
+func localize(language string, key messageKey, args ...interface{}) string {
+	message, ok := lookupMessage(language, key)
+	if !ok {
+		return string(key)
+	}
+	text := message[pluralOther]
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// localizeCount returns the plural form of the message for count, formatted with
+// count followed by args
+func localizeCount(language string, key messageKey, count int, args ...interface{}) string {
+	message, ok := lookupMessage(language, key)
+	if !ok {
+		return string(key)
+	}
+
+	rule, ok := pluralRules[language]
+	if !ok || locales[language] == nil || locales[language].Messages[key] == nil {
+		rule = englishPlural
+	}
+	text, ok := message[rule(count)]
+	if !ok {
+		text = message[pluralOther]
+	}
+	return fmt.Sprintf(text, append([]interface{}{count}, args...)...)
+}
+
+// localizeOr returns the message in the language, or fallback if no locale defines it
+func localizeOr(language string, key messageKey, fallback string) string {
+	if _, ok := lookupMessage(language, key); !ok {
+		return fallback
+	}
+	return localize(language, key)
+}
+
+// localizedRefusal returns a random refusal in the language, falling back to English
+func localizedRefusal(language string) string {
+	loc, ok := locales[language]
+	if !ok || len(loc.Refusals) == 0 {
+		loc = locales[defaultLanguage]
+	}
+	return loc.Refusals[rand.Intn(len(loc.Refusals))]
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+var formatVerbRegex = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z]`)
+
+func TestLocalesDefineEveryKey(t *testing.T) {
+	if len(locales) < 2 {
+		t.Fatalf("loaded %d locales, want English and at least one translation", len(locales))
+	}
+
+	known := make(map[messageKey]bool)
+	for _, key := range messageKeys {
+		known[key] = true
+	}
+
+	english := locales[defaultLanguage]
+	for language, loc := range locales {
+		for _, key := range messageKeys {
+			message, ok := loc.Messages[key]
+			if !ok {
+				t.Errorf("%s: message %q is missing", language, key)
+				continue
+			}
+
+			plural := len(english.Messages[key]) > 1
+			expectedForms := []pluralForm{pluralOther}
+			if plural {
+				expectedForms = pluralFormsOf(language)
+			}
+			for _, form := range expectedForms {
+				text, ok := message[form]
+				if !ok || text == "" {
+					t.Errorf("%s: message %q has no %q form", language, key, form)
+					continue
+				}
+				verbs := strings.Join(formatVerbRegex.FindAllString(text, -1), " ")
+				expected := strings.Join(formatVerbRegex.FindAllString(english.Messages[key][pluralOther], -1), " ")
+				if verbs != expected {
+					t.Errorf("%s: message %q uses %q, want the same verbs as English %q", language, key, verbs, expected)
+				}
+			}
+		}
+
+		for key := range loc.Messages {
+			if !known[key] && !strings.HasPrefix(string(key), commandDescriptionPrefix) {
+				t.Errorf("%s: message %q is not used by the bot", language, key)
+			}
+		}
+
+		if len(loc.Refusals) == 0 {
+			t.Errorf("%s: refusals are missing", language)
+		}
+	}
+}
+
+func TestLocalesTranslateCommandDescriptions(t *testing.T) {
+	commands := []string{"opinion", "tldr", "factcheck", "help", "start", "allowchat", "denychat", "listchats", "usage", "budget"}
+	for language, loc := range locales {
+		if language == defaultLanguage {
+			continue
+		}
+		for _, name := range commands {
+			if loc.Messages[commandDescriptionKey(name)][pluralOther] == "" {
+				t.Errorf("%s: description of /%s is missing", language, name)
+			}
+		}
+	}
+}
+
+func TestLoadLocalesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"Malformed JSON", fstest.MapFS{"locales/en.json": {Data: []byte(`{"messages": `)}}},
+		{"Invalid message", fstest.MapFS{"locales/en.json": {Data: []byte(`{"messages": {"tired": 42}}`)}}},
+		{"Missing English", fstest.MapFS{"locales/ru.json": {Data: []byte(`{"messages": {}}`)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadLocales(tt.files); err == nil {
+				t.Error("loadLocales() expected error, got nil")
+			}
+		})
+	}
+
+	loaded, err := loadLocales(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"messages": {"tired": "zzz", "rate_limited": {"one": "%d item", "other": "%d items"}}}`)},
+	})
+	if err != nil {
+		t.Fatalf("loadLocales() error: %v", err)
+	}
+	if message := loaded["en"].Messages[msgTired]; message[pluralOther] != "zzz" {
+		t.Errorf("plain message = %v, want the other form", message)
+	}
+	if message := loaded["en"].Messages[msgRateLimited]; message[pluralOne] != "%d item" {
+		t.Errorf("plural message = %v, want the one form", message)
+	}
+}
+
+func TestSlavicPlural(t *testing.T) {
+	tests := map[int]pluralForm{
+		0: pluralMany, 1: pluralOne, 2: pluralFew, 4: pluralFew, 5: pluralMany,
+		11: pluralMany, 12: pluralMany, 14: pluralMany, 21: pluralOne, 22: pluralFew, 25: pluralMany,
+		101: pluralOne, 111: pluralMany,
+	}
+	for n, expected := range tests {
+		if result := slavicPlural(n); result != expected {
+			t.Errorf("slavicPlural(%d) = %q, want %q", n, result, expected)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	if result := localize("de", msgTired); result != "Ich bin müde, nächstes Mal 😴" {
+		t.Errorf("localize(de) = %q", result)
+	}
+	if result := localize("fr", msgTired); result != "I'm tired dude, next time 😴" {
+		t.Errorf("localize(fr) = %q, want the English fallback", result)
+	}
+	if result := localize("ru", msgImageTooLarge, 10); result != "Картинка слишком большая, лимит 10 МБ 🐘" {
+		t.Errorf("localize(ru, image too large) = %q", result)
+	}
+	if result := localize("en", messageKey("missing")); result != "missing" {
+		t.Errorf("localize() for an unknown key = %q, want the key", result)
+	}
+	if result := localizeOr("en", messageKey("command_unknown"), "fallback"); result != "fallback" {
+		t.Errorf("localizeOr() = %q, want the fallback", result)
+	}
+}
+
+func TestLocalizeCount(t *testing.T) {
+	tests := []struct {
+		language string
+		count    int
+		expected string
+	}{
+		{"en", 1, "1 allowed chat:"},
+		{"en", 3, "3 allowed chats:"},
+		{"ru", 1, "1 разрешённый чат:"},
+		{"ru", 3, "3 разрешённых чата:"},
+		{"ru", 5, "5 разрешённых чатов:"},
+		{"ru", 21, "21 разрешённый чат:"},
+		{"de", 1, "1 erlaubter Chat:"},
+		{"de", 2, "2 erlaubte Chats:"},
+		{"fr", 2, "2 allowed chats:"},
+	}
+
+	for _, tt := range tests {
+		if result := localizeCount(tt.language, msgAllowedChats, tt.count); result != tt.expected {
+			t.Errorf("localizeCount(%s, %d) = %q, want %q", tt.language, tt.count, result, tt.expected)
+		}
+	}
+
+	if result := localizeCount("ru", msgRateLimited, 5); !strings.Contains(result, "5 запросов") {
+		t.Errorf("localizeCount(ru, rate limit) = %q", result)
+	}
+}
+
+func TestLocalizedRefusal(t *testing.T) {
+	valid := make(map[string]bool)
+	for _, refusal := range locales["ru"].Refusals {
+		valid[refusal] = true
+	}
+	for i := 0; i < 20; i++ {
+		if result := localizedRefusal("ru"); !valid[result] {
+			t.Errorf("localizedRefusal(ru) = %q, not a Russian refusal", result)
+		}
+	}
+
+	if result := localizedRefusal("xx"); result == "" {
+		t.Error("localizedRefusal() should fall back to English")
+	}
+}
+
+func TestGetOpinionInToneLocalizedRefusal(t *testing.T) {
+	valid := make(map[string]bool)
+	for _, refusal := range locales["de"].Refusals {
+		valid[refusal] = true
+	}
+
+	result := getOpinionInTone(context.Background(), "Keine Links hier", PromptNegative, "de")
+	if result.Category != resultNoURL || !valid[result.Answer] {
+		t.Errorf("getOpinionInTone() = %q, %s, want a German refusal", result.Answer, result.Category)
+	}
+}
+
+func TestUserLanguage(t *testing.T) {
+	original := fixedChatLanguages
+	defer func() { fixedChatLanguages = original }()
+	fixedChatLanguages = map[int64]string{-100: "de"}
+
+	tests := []struct {
+		name     string
+		chat     *tele.Chat
+		sender   *tele.User
+		expected string
+	}{
+		{"Chat setting wins", &tele.Chat{ID: -100}, &tele.User{LanguageCode: "ru"}, "de"},
+		{"Sender language", &tele.Chat{ID: -200}, &tele.User{LanguageCode: "ru"}, "ru"},
+		{"Regional sender language", &tele.Chat{ID: -200}, &tele.User{LanguageCode: "pt-BR"}, "pt"},
+		{"Unknown sender language", &tele.Chat{ID: -200}, &tele.User{LanguageCode: "xx"}, ""},
+		{"No sender", &tele.Chat{ID: -200}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtx := &MockContext{chat: tt.chat, sender: tt.sender}
+			if result := userLanguage(mockCtx); result != tt.expected {
+				t.Errorf("userLanguage() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
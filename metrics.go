@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genai"
+)
+
+// Command outcomes reported by brm_commands_total
+const (
+	outcomeSuccess      = "success"
+	outcomeUnauthorized = "unauthorized"
+	outcomeNoReply      = "no_reply"
+	outcomeDuplicate    = "duplicate"
+	outcomeRateLimited  = "rate_limited"
+	outcomeLLMError     = "llm_error"
+	outcomeRejected     = "rejected"  // nothing to analyze, or the media could not be used
+	outcomeCancelled    = "cancelled" // aborted by a shutdown
+	outcomeOverBudget   = "over_budget"
+	outcomeCached       = "cached" // past the budget, answered from the answer cached for the link
+)
+
+// metricsRegistry holds the bot metrics served on /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	commandsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brm_commands_total",
+		Help: "Commands handled, by command and outcome.",
+	}, []string{"command", "outcome"})
+
+	llmRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "brm_llm_request_duration_seconds",
+		Help:    "Duration of streamed LLM requests, by model, prompt type and status.",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	}, []string{"model", "prompt_type", "status"})
+
+	llmChunks = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "brm_llm_chunks",
+		Help:    "Streamed chunks per LLM request, by model and prompt type.",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100},
+	}, []string{"model", "prompt_type"})
+
+	llmTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brm_llm_tokens_total",
+		Help: "Tokens reported by the LLM, by model and type (prompt, candidates, thoughts, tool_use, cached).",
+	}, []string{"model", "type"})
+
+	llmFinishReasonsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brm_llm_finish_reasons_total",
+		Help: "LLM answers by model, prompt type and finish reason (stop, max_tokens, safety, ...); blocked prompts are prompt_<reason>.",
+	}, []string{"model", "prompt_type", "reason"})
+
+	cacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brm_cache_requests_total",
+		Help: "Lookups of already answered messages, by command and result (hit or miss).",
+	}, []string{"command", "result"})
+
+	redisErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brm_redis_errors_total",
+		Help: "Failed Redis commands, by command.",
+	}, []string{"command"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		commandsTotal,
+		llmRequestDuration,
+		llmChunks,
+		llmTokensTotal,
+		llmFinishReasonsTotal,
+		cacheRequestsTotal,
+		redisErrorsTotal,
+	)
+}
+
+// metricsHandler serves the registry in the Prometheus text format
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// recordCommand counts a handled command
+func recordCommand(command string, outcome string) {
+	commandsTotal.WithLabelValues(command, outcome).Inc()
+}
+
+// recordLLMRequest records the latency, chunks and token usage of an LLM request
+func recordLLMRequest(model string, promptType string, elapsed time.Duration, chunks int, usage *genai.GenerateContentResponseUsageMetadata, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	llmRequestDuration.WithLabelValues(model, promptType, status).Observe(elapsed.Seconds())
+	llmChunks.WithLabelValues(model, promptType).Observe(float64(chunks))
+
+	if usage == nil {
+		return
+	}
+	for tokenType, count := range map[string]int32{
+		"prompt":     usage.PromptTokenCount,
+		"candidates": usage.CandidatesTokenCount,
+		"thoughts":   usage.ThoughtsTokenCount,
+		"tool_use":   usage.ToolUsePromptTokenCount,
+		"cached":     usage.CachedContentTokenCount,
+	} {
+		if count > 0 {
+			llmTokensTotal.WithLabelValues(model, tokenType).Add(float64(count))
+		}
+	}
+}
+
+// recordFinishReason counts how an LLM answer ended
+func recordFinishReason(model string, promptType string, reason string) {
+	llmFinishReasonsTotal.WithLabelValues(model, promptType, reason).Inc()
+}
+
+// recordCacheLookup counts a lookup of an already answered message
+func recordCacheLookup(command string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequestsTotal.WithLabelValues(command, result).Inc()
+}
+
+// redisMetricsHook counts failed Redis commands; a missing key is not a failure
+type redisMetricsHook struct{}
+
+func (redisMetricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisMetricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			redisErrorsTotal.WithLabelValues(cmd.Name()).Inc()
+		}
+		return err
+	}
+}
+
+func (redisMetricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+
+		// Connection errors are returned without being set on the commands
+		var replyErr redis.Error
+		connErr := err
+		if errors.As(err, &replyErr) {
+			connErr = nil
+		}
+
+		for _, cmd := range cmds {
+			cmdErr := cmd.Err()
+			if cmdErr == nil {
+				cmdErr = connErr
+			}
+			if cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+				redisErrorsTotal.WithLabelValues(cmd.Name()).Inc()
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// counterDelta returns a function reporting how much the counter grew since the call
+func counterDelta(counter prometheus.Counter) func() float64 {
+	start := testutil.ToFloat64(counter)
+	return func() float64 { return testutil.ToFloat64(counter) - start }
+}
+
+// histogramCount returns the number of observations of a histogram
+func histogramCount(t *testing.T, observer prometheus.Observer) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := observer.(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatalf("failed to read histogram: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestCommandOutcomeMetrics(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{err: errors.New("quota exceeded")})
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	reply := ""
+
+	unauthorized := counterDelta(commandsTotal.WithLabelValues("tldr", outcomeUnauthorized))
+	handleTLDRCommand(newAnalysisMockContext(nil, &reply), newChatAllowlist(nil), nil, 1, SummaryShort)
+	if got := unauthorized(); got != 1 {
+		t.Errorf("unauthorized = %v, want 1", got)
+	}
+
+	llmError := counterDelta(commandsTotal.WithLabelValues("tldr", outcomeLLMError))
+	miss := counterDelta(cacheRequestsTotal.WithLabelValues("tldr", "miss"))
+	handleTLDRCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, SummaryShort)
+	if got := llmError(); got != 1 {
+		t.Errorf("llm_error = %v, want 1 (reply %q)", got, reply)
+	}
+	if got := miss(); got != 1 {
+		t.Errorf("cache misses = %v, want 1", got)
+	}
+
+	duplicate := counterDelta(commandsTotal.WithLabelValues("tldr", outcomeDuplicate))
+	hit := counterDelta(cacheRequestsTotal.WithLabelValues("tldr", "hit"))
+	mr.Set("tldr:-1001234567890:41", "1")
+	handleTLDRCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, 1, SummaryShort)
+	if got := duplicate(); got != 1 {
+		t.Errorf("duplicate = %v, want 1", got)
+	}
+	if got := hit(); got != 1 {
+		t.Errorf("cache hits = %v, want 1", got)
+	}
+
+	rateLimited := counterDelta(commandsTotal.WithLabelValues("factcheck", outcomeRateLimited))
+	handleAnalysisCommand(newAnalysisMockContext(nil, &reply), allowlist, nil, dailyRequestLimit+1, factcheckMode)
+	if got := rateLimited(); got != 1 {
+		t.Errorf("rate_limited = %v, want 1", got)
+	}
+
+	rejected := counterDelta(commandsTotal.WithLabelValues("opinion", outcomeRejected))
+	ctx := newAnalysisMockContext(nil, &reply)
+	ctx.message.ReplyTo = &tele.Message{ID: 50, Text: "no link here"}
+	handleOpinionCommand(ctx, allowlist, nil, 1, nil)
+	if got := rejected(); got != 1 {
+		t.Errorf("rejected = %v, want 1", got)
+	}
+}
+
+func TestCommandDispatchMetrics(t *testing.T) {
+	silenceStdout(t)
+	handled := ""
+	registry := newTestRegistry(&handled)
+	cmd, _ := registry.Lookup("opinion")
+	noReply := counterDelta(commandsTotal.WithLabelValues("opinion", outcomeNoReply))
+
+	reply := ""
+	ctx := newAnalysisMockContext(nil, &reply)
+	ctx.message.ReplyTo = nil
+	registry.dispatch(cmd)(ctx)
+
+	if got := noReply(); got != 1 {
+		t.Errorf("no_reply = %v, want 1", got)
+	}
+}
+
+func TestLLMRequestMetrics(t *testing.T) {
+	silenceStdout(t)
+	chunk := textChunk("Looks fine")
+	chunk.UsageMetadata = &genai.GenerateContentResponseUsageMetadata{
+		PromptTokenCount:     120,
+		CandidatesTokenCount: 30,
+		ThoughtsTokenCount:   50,
+	}
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Looks "), chunk}})
+
+	ok := llmRequestDuration.WithLabelValues(llmModel, "metrics_test", "ok")
+	okBefore := histogramCount(t, ok)
+	chunksBefore := histogramCount(t, llmChunks.WithLabelValues(llmModel, "metrics_test"))
+	prompt := counterDelta(llmTokensTotal.WithLabelValues(llmModel, "prompt"))
+	candidates := counterDelta(llmTokensTotal.WithLabelValues(llmModel, "candidates"))
+	thoughts := counterDelta(llmTokensTotal.WithLabelValues(llmModel, "thoughts"))
+
+	if _, err := runLLM(context.Background(), llmRequest{PromptType: "metrics_test"}); err != nil {
+		t.Fatalf("runLLM() error: %v", err)
+	}
+
+	if got := histogramCount(t, ok) - okBefore; got != 1 {
+		t.Errorf("successful LLM requests observed = %d, want 1", got)
+	}
+	if got := histogramCount(t, llmChunks.WithLabelValues(llmModel, "metrics_test")) - chunksBefore; got != 1 {
+		t.Errorf("chunk observations = %d, want 1", got)
+	}
+	if prompt() != 120 || candidates() != 30 || thoughts() != 50 {
+		t.Errorf("tokens = prompt %v, candidates %v, thoughts %v, want 120, 30, 50", prompt(), candidates(), thoughts())
+	}
+
+	useFakeLLMProvider(t, &fakeLLMProvider{err: errors.New("boom")})
+	failed := llmRequestDuration.WithLabelValues(llmModel, "metrics_test", "error")
+	failedBefore := histogramCount(t, failed)
+	runLLM(context.Background(), llmRequest{PromptType: "metrics_test"})
+	if got := histogramCount(t, failed) - failedBefore; got != 1 {
+		t.Errorf("failed LLM requests observed = %d, want 1", got)
+	}
+}
+
+func TestRedisErrorMetrics(t *testing.T) {
+	mr := useMiniredis(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+	client.AddHook(redisMetricsHook{})
+
+	getErrors := counterDelta(redisErrorsTotal.WithLabelValues("get"))
+	if err := client.Get(context.Background(), "missing").Err(); !errors.Is(err, redis.Nil) {
+		t.Fatalf("Get() error = %v, want redis.Nil", err)
+	}
+	if got := getErrors(); got != 0 {
+		t.Errorf("errors after a missing key = %v, want 0", got)
+	}
+
+	mr.Close()
+	client.Get(context.Background(), "key")
+	if got := getErrors(); got != 1 {
+		t.Errorf("errors after the server went away = %v, want 1", got)
+	}
+
+	pipelineErrors := counterDelta(redisErrorsTotal.WithLabelValues("incr"))
+	pipe := client.Pipeline()
+	pipe.Incr(context.Background(), "counter")
+	pipe.Exec(context.Background())
+	if got := pipelineErrors(); got != 1 {
+		t.Errorf("pipeline errors = %v, want 1", got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	recordCommand("opinion", outcomeSuccess)
+
+	rec := httptest.NewRecorder()
+	newAdminMux(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`brm_commands_total{command="opinion",outcome="success"}`,
+		"# TYPE brm_llm_request_duration_seconds histogram",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics does not contain %q", want)
+		}
+	}
+}
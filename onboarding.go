@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Unique callback identifiers of the access request buttons
+const (
+	accessApproveUnique = "access_approve"
+	accessDenyUnique    = "access_deny"
+)
+
+// onboardingBot is the part of *tele.Bot used by the onboarding flow
+type onboardingBot interface {
+	Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error)
+	Edit(msg tele.Editable, what interface{}, opts ...interface{}) (*tele.Message, error)
+	Leave(chat *tele.Chat) error
+}
+
+// accessRequest is a pending request from an unknown group to use the bot
+type accessRequest struct {
+	Chat        *tele.Chat
+	RequestedBy map[string]interface{}
+	ExpiresAt   time.Time
+	Prompts     []accessPrompt
+}
+
+// accessPrompt is the request message sent to one owner
+type accessPrompt struct {
+	OwnerID int64
+	Message *tele.Message
+}
+
+// accessRequests keeps pending access requests in memory until they are decided or expire
+type accessRequests struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	pending map[int64]accessRequest
+}
+
+// newAccessRequests creates an empty request list where requests live for ttl
+func newAccessRequests(ttl time.Duration) *accessRequests {
+	return &accessRequests{
+		ttl:     ttl,
+		pending: make(map[int64]accessRequest),
+	}
+}
+
+// Add registers a request for the chat. It returns false if one is already pending.
+func (r *accessRequests) Add(chat *tele.Chat, requestedBy map[string]interface{}, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, req := range r.pending {
+		if now.After(req.ExpiresAt) {
+			delete(r.pending, id)
+		}
+	}
+
+	if _, exists := r.pending[chat.ID]; exists {
+		return false
+	}
+
+	r.pending[chat.ID] = accessRequest{
+		Chat:        chat,
+		RequestedBy: requestedBy,
+		ExpiresAt:   now.Add(r.ttl),
+	}
+	return true
+}
+
+// SetPrompts records the messages that asked the owners about a pending request
+func (r *accessRequests) SetPrompts(chatID int64, prompts []accessPrompt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req, exists := r.pending[chatID]; exists {
+		req.Prompts = prompts
+		r.pending[chatID] = req
+	}
+}
+
+// Take removes and returns the pending request for the chat, if it has not expired
+func (r *accessRequests) Take(chatID int64, now time.Time) (accessRequest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req, exists := r.pending[chatID]
+	if !exists {
+		return accessRequest{}, false
+	}
+	delete(r.pending, chatID)
+
+	if now.After(req.ExpiresAt) {
+		return accessRequest{}, false
+	}
+	return req, true
+}
+
+// handleAddedToGroup asks the owners for access when the bot is added to an unknown group
+func handleAddedToGroup(c tele.Context, bot onboardingBot, allowlist *chatAllowlist, requests *accessRequests, ownerUserIDs []int64) error {
+	chat := c.Chat()
+	if chat == nil || allowlist.Contains(chat.ID) {
+		return nil
+	}
+
+	if len(ownerUserIDs) == 0 {
+		logJSON("warn", "Added to unauthorized group but no owners are configured", map[string]interface{}{
+			"chat": getChatInfo(c),
+		})
+		return nil
+	}
+
+	requestedBy := getUserInfo(c)
+	if !requests.Add(chat, requestedBy, time.Now()) {
+		logJSON("info", "Access request already pending", map[string]interface{}{
+			"chat": getChatInfo(c),
+		})
+		return nil
+	}
+
+	logJSON("info", "Access requested for unauthorized group", map[string]interface{}{
+		"user": requestedBy,
+		"chat": getChatInfo(c),
+	})
+
+	chatIDData := strconv.FormatInt(chat.ID, 10)
+
+	var prompts []accessPrompt
+	for _, ownerID := range ownerUserIDs {
+		// Owners read requests in their private chat's language
+		language := chatLanguage(ownerID)
+		markup := &tele.ReplyMarkup{}
+		markup.Inline(markup.Row(
+			markup.Data(localize(language, msgAccessApproveButton), accessApproveUnique, chatIDData),
+			markup.Data(localize(language, msgAccessDenyButton), accessDenyUnique, chatIDData),
+		))
+		text := localize(language, msgAccessRequest,
+			getChatInfo(c)["chat_title"], chat.ID, requestedBy["username"], requestedBy["user_id"], requests.ttl)
+
+		msg, err := bot.Send(&tele.User{ID: ownerID}, text, markup)
+		if err != nil {
+			logJSON("error", "Failed to send access request to owner", map[string]interface{}{
+				"owner_id": ownerID,
+				"error":    err.Error(),
+			})
+			continue
+		}
+		prompts = append(prompts, accessPrompt{OwnerID: ownerID, Message: msg})
+	}
+
+	if len(prompts) == 0 {
+		requests.Take(chat.ID, time.Now())
+		return nil
+	}
+	requests.SetPrompts(chat.ID, prompts)
+
+	return c.Send(localize(userLanguage(c), msgAccessRequested))
+}
+
+// handleAccessDecision applies an owner's Approve or Deny button press
+func handleAccessDecision(c tele.Context, bot onboardingBot, allowlist *chatAllowlist, requests *accessRequests, ownerUserIDs []int64, approve bool, leaveOnDeny bool) error {
+	if !isOwner(c, ownerUserIDs) {
+		return c.Respond(&tele.CallbackResponse{Text: localize(userLanguage(c), msgAccessOwnersOnly)})
+	}
+	language := userLanguage(c)
+
+	chatID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: localize(language, msgAccessInvalid)})
+	}
+
+	req, ok := requests.Take(chatID, time.Now())
+	if !ok {
+		return errors.Join(
+			c.Respond(&tele.CallbackResponse{Text: localize(language, msgAccessExpiredNotice)}),
+			c.Edit(localize(language, msgAccessExpired, chatID)),
+		)
+	}
+
+	logJSON("info", "Access request decided", map[string]interface{}{
+		"user":      getUserInfo(c),
+		"target_id": chatID,
+		"approved":  approve,
+	})
+
+	notice, decided := msgAccessDeniedNotice, msgAccessDenied
+	var persistErr error
+	if approve {
+		notice, decided = msgAccessApprovedNotice, msgAccessApproved
+		if persistErr = allowlist.Allow(context.Background(), chatID); persistErr != nil {
+			logJSON("warn", "Failed to persist allowlist change", map[string]interface{}{
+				"target_id": chatID,
+				"error":     persistErr.Error(),
+			})
+		}
+		if _, err := bot.Send(req.Chat, localize(chatLanguage(chatID), msgAccessGranted)); err != nil {
+			logJSON("warn", "Failed to notify group about approval", map[string]interface{}{
+				"target_id": chatID,
+				"error":     err.Error(),
+			})
+		}
+	} else if leaveOnDeny {
+		if err := bot.Leave(req.Chat); err != nil {
+			logJSON("warn", "Failed to leave denied group", map[string]interface{}{
+				"target_id": chatID,
+				"error":     err.Error(),
+			})
+		}
+	}
+
+	// Every owner's prompt shows the decision, with a warning if it was not persisted
+	outcome := func(language string) string {
+		text := localize(language, decided, chatID)
+		if persistErr != nil {
+			text += localize(language, msgNotPersisted)
+		}
+		return text
+	}
+	for _, prompt := range req.Prompts {
+		if prompt.OwnerID == c.Sender().ID || prompt.Message == nil {
+			continue
+		}
+		if _, err := bot.Edit(prompt.Message, outcome(chatLanguage(prompt.OwnerID))); err != nil {
+			logJSON("warn", "Failed to update access request of another owner", map[string]interface{}{
+				"owner_id":  prompt.OwnerID,
+				"target_id": chatID,
+				"error":     err.Error(),
+			})
+		}
+	}
+
+	return errors.Join(
+		c.Respond(&tele.CallbackResponse{Text: localize(language, notice)}),
+		c.Edit(outcome(language)),
+	)
+}
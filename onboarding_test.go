@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeOnboardingBot records messages and leave calls instead of talking to Telegram
+type fakeOnboardingBot struct {
+	sent    map[int64][]string
+	edited  map[int64][]string
+	markups []*tele.ReplyMarkup
+	left    []int64
+	sendErr error
+}
+
+func newFakeOnboardingBot() *fakeOnboardingBot {
+	return &fakeOnboardingBot{sent: make(map[int64][]string), edited: make(map[int64][]string)}
+}
+
+func (b *fakeOnboardingBot) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	if b.sendErr != nil {
+		return nil, b.sendErr
+	}
+	var id int64
+	switch r := to.(type) {
+	case *tele.User:
+		id = r.ID
+	case *tele.Chat:
+		id = r.ID
+	}
+	b.sent[id] = append(b.sent[id], what.(string))
+	for _, opt := range opts {
+		if markup, ok := opt.(*tele.ReplyMarkup); ok {
+			b.markups = append(b.markups, markup)
+		}
+	}
+	return &tele.Message{ID: len(b.sent[id]), Chat: &tele.Chat{ID: id}}, nil
+}
+
+func (b *fakeOnboardingBot) Edit(msg tele.Editable, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	_, chatID := msg.MessageSig()
+	b.edited[chatID] = append(b.edited[chatID], what.(string))
+	return msg.(*tele.Message), nil
+}
+
+func (b *fakeOnboardingBot) Leave(chat *tele.Chat) error {
+	b.left = append(b.left, chat.ID)
+	return nil
+}
+
+// MockInteractiveContext records Send, Edit and Respond calls
+type MockInteractiveContext struct {
+	MockContext
+	sent      []string
+	edited    []string
+	responses []string
+}
+
+func (m *MockInteractiveContext) Send(what interface{}, opts ...interface{}) error {
+	m.sent = append(m.sent, what.(string))
+	return nil
+}
+
+func (m *MockInteractiveContext) Edit(what interface{}, opts ...interface{}) error {
+	m.edited = append(m.edited, what.(string))
+	return nil
+}
+
+func (m *MockInteractiveContext) Respond(resp ...*tele.CallbackResponse) error {
+	for _, r := range resp {
+		m.responses = append(m.responses, r.Text)
+	}
+	return nil
+}
+
+// newAddedToGroupContext simulates the bot being added to a group by a user
+func newAddedToGroupContext(chatID int64) *MockInteractiveContext {
+	return &MockInteractiveContext{
+		MockContext: MockContext{
+			chat:    &tele.Chat{ID: chatID, Type: tele.ChatSuperGroup, Title: "New Group"},
+			sender:  &tele.User{ID: 555, Username: "adder"},
+			message: &tele.Message{ID: 1},
+		},
+	}
+}
+
+// newDecisionContext simulates an owner pressing an access request button
+func newDecisionContext(userID int64, chatID string) *MockInteractiveContext {
+	return &MockInteractiveContext{
+		MockContext: MockContext{
+			chat:     &tele.Chat{ID: userID, Type: tele.ChatPrivate},
+			sender:   &tele.User{ID: userID, Username: "owner"},
+			callback: &tele.Callback{Data: chatID},
+		},
+	}
+}
+
+func TestAccessRequestsAddAndTake(t *testing.T) {
+	requests := newAccessRequests(time.Hour)
+	now := time.Now()
+	chat := &tele.Chat{ID: -100}
+
+	if !requests.Add(chat, nil, now) {
+		t.Fatal("Add() = false for a new request")
+	}
+	if requests.Add(chat, nil, now) {
+		t.Error("Add() = true for an already pending request")
+	}
+
+	req, ok := requests.Take(-100, now.Add(time.Minute))
+	if !ok || req.Chat.ID != -100 {
+		t.Errorf("Take() = %v, %v, want pending request", req, ok)
+	}
+	if _, ok := requests.Take(-100, now); ok {
+		t.Error("Take() returned a request twice")
+	}
+}
+
+func TestAccessRequestsExpire(t *testing.T) {
+	requests := newAccessRequests(time.Hour)
+	now := time.Now()
+
+	requests.Add(&tele.Chat{ID: -100}, nil, now)
+	if _, ok := requests.Take(-100, now.Add(2*time.Hour)); ok {
+		t.Error("Take() returned an expired request")
+	}
+
+	requests.Add(&tele.Chat{ID: -200}, nil, now)
+	if !requests.Add(&tele.Chat{ID: -200}, nil, now.Add(2*time.Hour)) {
+		t.Error("Add() should replace an expired request")
+	}
+}
+
+func TestHandleAddedToGroupNotifiesOwners(t *testing.T) {
+	silenceStdout(t)
+	bot := newFakeOnboardingBot()
+	requests := newAccessRequests(time.Hour)
+	c := newAddedToGroupContext(-1007777777777)
+
+	if err := handleAddedToGroup(c, bot, newChatAllowlist(nil), requests, []int64{111, 222}); err != nil {
+		t.Fatalf("handleAddedToGroup returned error: %v", err)
+	}
+
+	for _, ownerID := range []int64{111, 222} {
+		if len(bot.sent[ownerID]) != 1 {
+			t.Fatalf("owner %d received %d messages, want 1", ownerID, len(bot.sent[ownerID]))
+		}
+		if !strings.Contains(bot.sent[ownerID][0], "-1007777777777") {
+			t.Errorf("access request %q does not mention the chat ID", bot.sent[ownerID][0])
+		}
+	}
+
+	if len(bot.markups) != 2 || len(bot.markups[0].InlineKeyboard) != 1 || len(bot.markups[0].InlineKeyboard[0]) != 2 {
+		t.Fatal("access request should have one row with Approve and Deny buttons")
+	}
+	if len(c.sent) != 1 {
+		t.Errorf("group received %d messages, want 1", len(c.sent))
+	}
+
+	// Adding the bot again while the request is pending does not spam owners
+	handleAddedToGroup(newAddedToGroupContext(-1007777777777), bot, newChatAllowlist(nil), requests, []int64{111, 222})
+	if len(bot.sent[111]) != 1 {
+		t.Errorf("owner received %d messages after duplicate add, want 1", len(bot.sent[111]))
+	}
+}
+
+func TestHandleAddedToGroupIgnoresAllowedChats(t *testing.T) {
+	bot := newFakeOnboardingBot()
+	c := newAddedToGroupContext(-100)
+
+	handleAddedToGroup(c, bot, newChatAllowlist([]int64{-100}), newAccessRequests(time.Hour), []int64{111})
+
+	if len(bot.sent) != 0 || len(c.sent) != 0 {
+		t.Error("no access request should be sent for an allowed chat")
+	}
+}
+
+func TestHandleAddedToGroupOwnersUnreachable(t *testing.T) {
+	silenceStdout(t)
+	bot := newFakeOnboardingBot()
+	bot.sendErr = errors.New("bot was blocked by the user")
+	requests := newAccessRequests(time.Hour)
+	c := newAddedToGroupContext(-100)
+
+	handleAddedToGroup(c, bot, newChatAllowlist(nil), requests, []int64{111})
+
+	if len(c.sent) != 0 {
+		t.Error("group should not be told a request was sent when no owner was notified")
+	}
+	if _, ok := requests.Take(-100, time.Now()); ok {
+		t.Error("undelivered request should not stay pending")
+	}
+}
+
+func TestHandleAccessDecisionApprove(t *testing.T) {
+	silenceStdout(t)
+	bot := newFakeOnboardingBot()
+	allowlist := newChatAllowlist(nil)
+	requests := newAccessRequests(time.Hour)
+	requests.Add(&tele.Chat{ID: -100}, nil, time.Now())
+
+	c := newDecisionContext(111, "-100")
+	if err := handleAccessDecision(c, bot, allowlist, requests, []int64{111}, true, true); err != nil {
+		t.Fatalf("handleAccessDecision returned error: %v", err)
+	}
+
+	if !allowlist.Contains(-100) {
+		t.Error("approved chat is not in the allowlist")
+	}
+	if len(bot.sent[-100]) != 1 {
+		t.Error("group was not notified about the approval")
+	}
+	if len(bot.left) != 0 {
+		t.Error("bot left an approved group")
+	}
+	if len(c.edited) != 1 || !strings.Contains(c.edited[0], "approved") {
+		t.Errorf("owner message edits = %v, want approval note", c.edited)
+	}
+	// Without Redis the approval only lives in memory for now
+	if !strings.Contains(c.edited[0], "not persisted") {
+		t.Errorf("owner message edit = %q, want persistence warning", c.edited[0])
+	}
+}
+
+func TestHandleAccessDecisionUpdatesOtherOwners(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	bot := newFakeOnboardingBot()
+	allowlist := newChatAllowlist(nil)
+	requests := newAccessRequests(time.Hour)
+	owners := []int64{111, 222}
+	handleAddedToGroup(newAddedToGroupContext(-100), bot, allowlist, requests, owners)
+
+	c := newDecisionContext(111, "-100")
+	if err := handleAccessDecision(c, bot, allowlist, requests, owners, true, false); err != nil {
+		t.Fatalf("handleAccessDecision returned error: %v", err)
+	}
+
+	if len(c.edited) != 1 || c.edited[0] != "✅ Access for chat -100 approved" {
+		t.Errorf("deciding owner edits = %v, want the approval", c.edited)
+	}
+	if len(bot.edited[111]) != 0 {
+		t.Errorf("deciding owner prompt edited twice: %v", bot.edited[111])
+	}
+	if len(bot.edited[222]) != 1 || bot.edited[222][0] != "✅ Access for chat -100 approved" {
+		t.Errorf("other owner edits = %v, want the approval", bot.edited[222])
+	}
+}
+
+func TestHandleAccessDecisionDeny(t *testing.T) {
+	tests := []struct {
+		name        string
+		leaveOnDeny bool
+		expectLeave bool
+	}{
+		{"Deny and leave", true, true},
+		{"Deny and stay", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			silenceStdout(t)
+			bot := newFakeOnboardingBot()
+			allowlist := newChatAllowlist(nil)
+			requests := newAccessRequests(time.Hour)
+			requests.Add(&tele.Chat{ID: -100}, nil, time.Now())
+
+			c := newDecisionContext(111, "-100")
+			handleAccessDecision(c, bot, allowlist, requests, []int64{111}, false, tt.leaveOnDeny)
+
+			if allowlist.Contains(-100) {
+				t.Error("denied chat is in the allowlist")
+			}
+			if left := len(bot.left) == 1; left != tt.expectLeave {
+				t.Errorf("bot left = %v, want %v", left, tt.expectLeave)
+			}
+			if len(c.edited) != 1 || !strings.Contains(c.edited[0], "denied") {
+				t.Errorf("owner message edits = %v, want denial note", c.edited)
+			}
+		})
+	}
+}
+
+func TestHandleAccessDecisionExpired(t *testing.T) {
+	bot := newFakeOnboardingBot()
+	allowlist := newChatAllowlist(nil)
+	requests := newAccessRequests(time.Hour)
+
+	c := newDecisionContext(111, "-100")
+	handleAccessDecision(c, bot, allowlist, requests, []int64{111}, true, false)
+
+	if allowlist.Contains(-100) {
+		t.Error("expired request was approved")
+	}
+	if len(c.responses) != 1 || !strings.Contains(c.responses[0], "expired") {
+		t.Errorf("callback responses = %v, want expiry notice", c.responses)
+	}
+}
+
+func TestHandleAccessDecisionNonOwner(t *testing.T) {
+	bot := newFakeOnboardingBot()
+	allowlist := newChatAllowlist(nil)
+	requests := newAccessRequests(time.Hour)
+	requests.Add(&tele.Chat{ID: -100}, nil, time.Now())
+
+	c := newDecisionContext(999, "-100")
+	handleAccessDecision(c, bot, allowlist, requests, []int64{111}, true, false)
+
+	if allowlist.Contains(-100) {
+		t.Error("non-owner was able to approve a request")
+	}
+	if _, ok := requests.Take(-100, time.Now()); !ok {
+		t.Error("request should stay pending after a non-owner click")
+	}
+}
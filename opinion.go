@@ -1,29 +1,97 @@
 package main
 
 import (
-	"math/rand"
+	"context"
 	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var urlRegex = regexp.MustCompile(`https?://[^\s]+`)
 
 // getOpinion analyzes a message and returns an opinion about it
-// Returns the opinion and a boolean indicating if processing was successful
-func getOpinion(text string) (string, bool) {
+// Returns the opinion, or the reply and category of the failure
+func getOpinion(text string) analysisResult {
+	return getOpinionInTone(inflight.Context(), text, selectPromptType(), "")
+}
+
+// getOpinionInTone is getOpinion with the tone and reply language selected by the caller
+func getOpinionInTone(ctx context.Context, text string, tone PromptType, language string) analysisResult {
 	if text == "" {
-		return "No text to analyze.", false
+		return failed(resultNoText, localize(language, msgNoText), nil)
 	}
 
 	// Extract URL from the message
-	url := extractURL(text)
+	url := extractURLInSpan(ctx, text)
 	
 	if url == "" {
 		// No URL found - return random angry/tired response
-		return getRandomRefusalResponse(), false
+		return failed(resultNoURL, localizedRefusal(language), nil)
 	}
 	
 	// URL found - process it
-	return processURL(url)
+	return processURLInTone(ctx, url, tone, language)
+}
+
+// getOpinionWithText is like getOpinion, but plain-text messages without a URL
+// of at least minLength characters are analyzed instead of refused
+func getOpinionWithText(ctx context.Context, text string, minLength int, tone PromptType, language string) analysisResult {
+	if text != "" && extractURL(text) == "" && utf8.RuneCountInString(strings.TrimSpace(text)) >= minLength {
+		return processText(ctx, text, tone, language)
+	}
+	return getOpinionInTone(ctx, text, tone, language)
+}
+
+// getSummary returns a neutral summary of the first URL in the message
+// Returns the summary, or the reply and category of the failure
+func getSummary(ctx context.Context, text string, length SummaryLength, language string) analysisResult {
+	if text == "" {
+		return failed(resultNoText, localize(language, msgNoTextSummary), nil)
+	}
+
+	url := extractURLInSpan(ctx, text)
+	if url == "" {
+		return failed(resultNoURL, localize(language, msgNoLinkSummary), nil)
+	}
+
+	summary, err := summarizeURLWithLLM(ctx, url, length, language)
+	if err != nil {
+		return llmFailed(language, "", err, msgSummaryFailed)
+	}
+
+	return answered(summary)
+}
+
+// getFactCheck rates the main claims of the first URL in the message and cites the sources
+// Returns the fact check, or the reply and category of the failure
+func getFactCheck(ctx context.Context, text string, language string) analysisResult {
+	if text == "" {
+		return failed(resultNoText, localize(language, msgNoTextFactCheck), nil)
+	}
+
+	url := extractURLInSpan(ctx, text)
+	if url == "" {
+		return failed(resultNoURL, localize(language, msgNoLinkFactCheck), nil)
+	}
+
+	result, err := factCheckURLWithLLM(ctx, url, language)
+	if err != nil {
+		return llmFailed(language, "", err, msgFactCheckFailed)
+	}
+
+	return answered(formatCitations(result.Text, result.Sources, language))
+}
+
+// extractURLInSpan is extractURL traced as an extract_url span, for the analysis paths
+func extractURLInSpan(ctx context.Context, text string) string {
+	_, span := tracer.Start(ctx, "extract_url")
+	defer span.End()
+
+	url := extractURL(text)
+	span.SetAttributes(attribute.Bool("url.found", url != ""))
+	return url
 }
 
 // extractURL extracts the first URL from the text
@@ -68,33 +136,33 @@ func containsChar(s string, c byte) bool {
 	return false
 }
 
-// processURL processes the URL (currently does nothing)
-func processURL(url string) (string, bool) {
+// processURL asks the LLM for an opinion about the URL in a random tone and the default language
+func processURL(url string) analysisResult {
+	return processURLInTone(inflight.Context(), url, selectPromptType(), "")
+}
+
+// processURLInTone asks the LLM for an opinion about the URL in the given tone and language
+func processURLInTone(ctx context.Context, url string, tone PromptType, language string) analysisResult {
 	// Call the LLM to analyze the URL
-	analysis, err := analyzeURLWithLLM(url)
+	analysis, err := analyzeURLInTone(ctx, url, tone, language)
 	if err != nil {
-		return "I'm tired dude, next time 😴", false
+		return llmFailed(language, tone, err, msgTired)
 	}
 	
-	return analysis, true
+	return answered(analysis)
+}
+
+// processText asks the LLM for an opinion about a plain-text message in the given tone and language
+func processText(ctx context.Context, text string, tone PromptType, language string) analysisResult {
+	analysis, err := analyzeTextWithLLM(ctx, text, tone, language)
+	if err != nil {
+		return llmFailed(language, tone, err, msgTired)
+	}
+
+	return answered(analysis)
 }
 
 // getRandomRefusalResponse returns a random refusal/angry response
 func getRandomRefusalResponse() string {
-	responses := []string{
-		"I'm tired 😴",
-		"I don't want to talk 😤",
-		"NO 😠",
-		"Not today 😑",
-		"Leave me alone 🙄",
-		"I'm not in the mood 😒",
-		"Go away 😡",
-		"Seriously? 🤨",
-		"Don't bother me 💢",
-		"Ask someone else 😾",
-		"I refuse 🚫",
-		"Absolutely not 😤",
-	}
-	
-	return responses[rand.Intn(len(responses))]
+	return localizedRefusal(defaultLanguage)
 }
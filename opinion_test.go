@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"testing"
+
+	"google.golang.org/genai"
 )
 
 func TestExtractURL(t *testing.T) {
@@ -109,13 +112,13 @@ func TestExtractURL(t *testing.T) {
 }
 
 func TestGetOpinionEmptyText(t *testing.T) {
-	result, success := getOpinion("")
+	result := getOpinion("")
 
-	if success {
-		t.Error("getOpinion(\"\") success = true, want false")
+	if result.Category != resultNoText {
+		t.Errorf("getOpinion(\"\") category = %s, want %s", result.Category, resultNoText)
 	}
-	if result != "No text to analyze." {
-		t.Errorf("getOpinion(\"\") = %q, want %q", result, "No text to analyze.")
+	if result.Answer != "No text to analyze." {
+		t.Errorf("getOpinion(\"\") = %q, want %q", result.Answer, "No text to analyze.")
 	}
 }
 
@@ -146,22 +149,22 @@ func TestGetOpinionNoURL(t *testing.T) {
 
 	for _, input := range inputs {
 		t.Run(input, func(t *testing.T) {
-			result, success := getOpinion(input)
+			result := getOpinion(input)
 
-			if success {
-				t.Errorf("getOpinion(%q) success = true, want false", input)
+			if result.Category != resultNoURL {
+				t.Errorf("getOpinion(%q) category = %s, want %s", input, result.Category, resultNoURL)
 			}
 
 			// Check that result is one of the valid refusal responses
 			found := false
 			for _, valid := range validResponses {
-				if result == valid {
+				if result.Answer == valid {
 					found = true
 					break
 				}
 			}
 			if !found {
-				t.Errorf("getOpinion(%q) = %q, not a valid refusal response", input, result)
+				t.Errorf("getOpinion(%q) = %q, not a valid refusal response", input, result.Answer)
 			}
 		})
 	}
@@ -217,14 +220,14 @@ func TestGetOpinionWithURL(t *testing.T) {
 	// Test with no API key configured
 	googleAPIKey = ""
 
-	result, success := getOpinion("Check out https://example.com")
+	result := getOpinion("Check out https://example.com")
 
 	// Without API key, it should fail with the tired response
-	if success {
-		t.Error("getOpinion with URL but no API key: success = true, want false")
+	if result.Category != resultLLMError {
+		t.Errorf("getOpinion with URL but no API key: category = %s, want %s", result.Category, resultLLMError)
 	}
-	if result != "I'm tired dude, next time 😴" {
-		t.Errorf("getOpinion with URL but no API key = %q, want %q", result, "I'm tired dude, next time 😴")
+	if result.Answer != "I'm tired dude, next time 😴" {
+		t.Errorf("getOpinion with URL but no API key = %q, want %q", result.Answer, "I'm tired dude, next time 😴")
 	}
 }
 
@@ -235,13 +238,13 @@ func TestProcessURLWithoutAPIKey(t *testing.T) {
 
 	googleAPIKey = ""
 
-	result, success := processURL("https://example.com")
+	result := processURL("https://example.com")
 
-	if success {
-		t.Error("processURL without API key: success = true, want false")
+	if result.Category != resultLLMError {
+		t.Errorf("processURL without API key: category = %s, want %s", result.Category, resultLLMError)
 	}
-	if result != "I'm tired dude, next time 😴" {
-		t.Errorf("processURL without API key = %q, want %q", result, "I'm tired dude, next time 😴")
+	if result.Answer != "I'm tired dude, next time 😴" {
+		t.Errorf("processURL without API key = %q, want %q", result.Answer, "I'm tired dude, next time 😴")
 	}
 }
 
@@ -347,39 +350,39 @@ func TestGetOpinionIntegration(t *testing.T) {
 	tests := []struct {
 		name            string
 		input           string
-		expectSuccess   bool
+		expectCategory  resultCategory
 		expectContains  string
 	}{
 		{
 			name:           "Empty input",
 			input:          "",
-			expectSuccess:  false,
+			expectCategory: resultNoText,
 			expectContains: "No text to analyze",
 		},
 		{
 			name:           "Text without URL",
 			input:          "Just some regular text here",
-			expectSuccess:  false,
+			expectCategory: resultNoURL,
 			expectContains: "", // Will be a random refusal
 		},
 		{
 			name:           "Whitespace only",
 			input:          "   \t\n   ",
-			expectSuccess:  false,
+			expectCategory: resultNoURL,
 			expectContains: "", // Will be a random refusal
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, success := getOpinion(tt.input)
+			result := getOpinion(tt.input)
 
-			if success != tt.expectSuccess {
-				t.Errorf("getOpinion(%q) success = %v, want %v", tt.input, success, tt.expectSuccess)
+			if result.Category != tt.expectCategory {
+				t.Errorf("getOpinion(%q) category = %s, want %s", tt.input, result.Category, tt.expectCategory)
 			}
 
-			if tt.expectContains != "" && !strings.Contains(result, tt.expectContains) {
-				t.Errorf("getOpinion(%q) = %q, want to contain %q", tt.input, result, tt.expectContains)
+			if tt.expectContains != "" && !strings.Contains(result.Answer, tt.expectContains) {
+				t.Errorf("getOpinion(%q) = %q, want to contain %q", tt.input, result.Answer, tt.expectContains)
 			}
 		})
 	}
@@ -751,14 +754,14 @@ func TestGetOpinionVariousURLTypes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, success := getOpinion(tt.input)
+			result := getOpinion(tt.input)
 
 			// Without API key, it should fail with tired message
-			if success {
-				t.Errorf("getOpinion(%q) with no API key: success = true, want false", tt.input)
+			if result.OK() {
+				t.Errorf("getOpinion(%q) with no API key: category = ok, want a failure", tt.input)
 			}
-			if result != "I'm tired dude, next time 😴" {
-				t.Errorf("getOpinion(%q) = %q, want %q", tt.input, result, "I'm tired dude, next time 😴")
+			if result.Answer != "I'm tired dude, next time 😴" {
+				t.Errorf("getOpinion(%q) = %q, want %q", tt.input, result.Answer, "I'm tired dude, next time 😴")
 			}
 		})
 	}
@@ -780,13 +783,13 @@ func TestProcessURLReturnsError(t *testing.T) {
 
 	for _, url := range urls {
 		t.Run(url, func(t *testing.T) {
-			result, success := processURL(url)
+			result := processURL(url)
 
-			if success {
-				t.Errorf("processURL(%q) without API key: success = true, want false", url)
+			if result.OK() {
+				t.Errorf("processURL(%q) without API key: category = ok, want a failure", url)
 			}
-			if result != "I'm tired dude, next time 😴" {
-				t.Errorf("processURL(%q) without API key = %q, want %q", url, result, "I'm tired dude, next time 😴")
+			if result.Answer != "I'm tired dude, next time 😴" {
+				t.Errorf("processURL(%q) without API key = %q, want %q", url, result.Answer, "I'm tired dude, next time 😴")
 			}
 		})
 	}
@@ -831,3 +834,79 @@ func TestRefusalResponsesHaveEmojis(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSummary(t *testing.T) {
+	originalKey := googleAPIKey
+	defer func() { googleAPIKey = originalKey }()
+	googleAPIKey = ""
+
+	tests := []struct {
+		name     string
+		input    string
+		category resultCategory
+		expected string
+	}{
+		{"Empty text", "", resultNoText, "No text to summarize."},
+		{"No URL", "just some words", resultNoURL, "There is no link to summarize 🤷"},
+		{"URL without API key", "read https://example.com", resultLLMError, "I couldn't summarize this link, try again later 😴"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := getSummary(context.Background(), tt.input, SummaryShort, "")
+			if result.Category != tt.category {
+				t.Errorf("getSummary(%q) category = %s, want %s", tt.input, result.Category, tt.category)
+			}
+			if result.Answer != tt.expected {
+				t.Errorf("getSummary(%q) = %q, want %q", tt.input, result.Answer, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetOpinionWithText(t *testing.T) {
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Text opinion")}})
+
+	long := strings.Repeat("word ", 20)
+
+	tests := []struct {
+		name             string
+		text             string
+		expectedCategory resultCategory
+		expectedAnswer   string
+	}{
+		{"Long text is analyzed", long, resultOK, "Text opinion"},
+		{"Short text is refused", "hello there", resultNoURL, ""},
+		{"Length counts characters, not bytes", strings.Repeat("ы", 30), resultNoURL, ""},
+		{"Empty text", "", resultNoText, "No text to analyze."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := getOpinionWithText(context.Background(), tt.text, 50, PromptPositive, "")
+			if result.Category != tt.expectedCategory {
+				t.Errorf("category = %s, want %s", result.Category, tt.expectedCategory)
+			}
+			if tt.expectedAnswer != "" && result.Answer != tt.expectedAnswer {
+				t.Errorf("answer = %q, want %q", result.Answer, tt.expectedAnswer)
+			}
+		})
+	}
+}
+
+func TestTextAnalysisEnabled(t *testing.T) {
+	texts := &textAnalysis{chatIDs: []int64{-100, -200}, minLength: 10}
+
+	if !texts.Enabled(-200) {
+		t.Error("Enabled(-200) = false, want true")
+	}
+	if texts.Enabled(-300) {
+		t.Error("Enabled(-300) = true, want false")
+	}
+
+	var disabled *textAnalysis
+	if disabled.Enabled(-100) {
+		t.Error("nil textAnalysis should be disabled")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"google.golang.org/genai"
+)
+
+// llmProvider streams model responses. The Gemini API is used in production,
+// tests substitute a fake provider.
+type llmProvider interface {
+	GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error]
+}
+
+// newLLMProvider creates the provider used for a request
+var newLLMProvider = newGeminiProvider
+
+// newGeminiProvider creates a Gemini API client using GOOGLE_API_KEY
+func newGeminiProvider(ctx context.Context) (llmProvider, error) {
+	if googleAPIKey == "" {
+		logJSON("error", "LLM API key not configured", nil)
+		return nil, fmt.Errorf("GOOGLE_API_KEY not configured")
+	}
+
+	logJSON("debug", "Creating LLM client", map[string]interface{}{
+		"api_key_length": len(googleAPIKey),
+	})
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey: googleAPIKey,
+	})
+	if err != nil {
+		logJSON("error", "Failed to create LLM client", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, fmt.Errorf("failed to create LLM client: %w", err)
+	}
+
+	logJSON("debug", "LLM client created successfully", nil)
+
+	return client.Models, nil
+}
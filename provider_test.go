@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// fakeLLMProvider streams canned responses and records the last request
+type fakeLLMProvider struct {
+	chunks   []*genai.GenerateContentResponse
+	queued   [][]*genai.GenerateContentResponse // responses of the first calls, before chunks
+	err      error
+	calls    int
+	model    string
+	contents []*genai.Content
+	config   *genai.GenerateContentConfig
+}
+
+func (f *fakeLLMProvider) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	f.calls++
+	f.model = model
+	f.contents = contents
+	f.config = config
+	chunks := f.chunks
+	if len(f.queued) > 0 {
+		chunks, f.queued = f.queued[0], f.queued[1:]
+	}
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		for _, chunk := range chunks {
+			if !yield(chunk, nil) {
+				return
+			}
+		}
+		if f.err != nil {
+			yield(nil, f.err)
+		}
+	}
+}
+
+// useFakeLLMProvider replaces the LLM provider for the duration of the test
+func useFakeLLMProvider(t *testing.T, fake *fakeLLMProvider) {
+	t.Helper()
+	original := newLLMProvider
+	newLLMProvider = func(ctx context.Context) (llmProvider, error) {
+		return fake, nil
+	}
+	t.Cleanup(func() { newLLMProvider = original })
+}
+
+// textChunk builds a streamed chunk with text and optional grounding sources
+func textChunk(text string, sources ...groundingSource) *genai.GenerateContentResponse {
+	candidate := &genai.Candidate{
+		Content: &genai.Content{Parts: []*genai.Part{genai.NewPartFromText(text)}},
+	}
+	if len(sources) > 0 {
+		metadata := &genai.GroundingMetadata{}
+		for _, source := range sources {
+			metadata.GroundingChunks = append(metadata.GroundingChunks, &genai.GroundingChunk{
+				Web: &genai.GroundingChunkWeb{Title: source.Title, URI: source.URI},
+			})
+		}
+		candidate.GroundingMetadata = metadata
+	}
+	return &genai.GenerateContentResponse{Candidates: []*genai.Candidate{candidate}}
+}
+
+func TestRunLLMCollectsTextAndSources(t *testing.T) {
+	silenceStdout(t)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{
+		textChunk("✅ True: the sky is blue.", groundingSource{"NASA", "https://nasa.gov/sky"}),
+		textChunk("\n❌ False: the moon is cheese.", groundingSource{"NASA", "https://nasa.gov/sky"}, groundingSource{"Wiki", "https://wiki.org/moon"}),
+	}}
+	useFakeLLMProvider(t, fake)
+
+	result, err := factCheckURLWithLLM(context.Background(), "https://example.com", "")
+	if err != nil {
+		t.Fatalf("factCheckURLWithLLM returned error: %v", err)
+	}
+	if result.Text != "✅ True: the sky is blue.\n❌ False: the moon is cheese." {
+		t.Errorf("text = %q", result.Text)
+	}
+	if len(result.Sources) != 2 || result.Sources[1].URI != "https://wiki.org/moon" {
+		t.Errorf("sources = %v, want 2 de-duplicated sources", result.Sources)
+	}
+
+	hasSearch := false
+	for _, tool := range fake.config.Tools {
+		if tool.GoogleSearch != nil {
+			hasSearch = true
+		}
+	}
+	if !hasSearch {
+		t.Error("fact check request should enable Google Search grounding")
+	}
+	if fake.contents[0].Parts[0].Text != "https://example.com" {
+		t.Errorf("contents = %v, want the URL", fake.contents[0].Parts[0].Text)
+	}
+}
+
+func TestRunLLMStreamError(t *testing.T) {
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{
+		chunks: []*genai.GenerateContentResponse{textChunk("partial")},
+		err:    errors.New("boom"),
+	})
+
+	if _, err := generateForURL(context.Background(), "https://example.com", "prompt", "test", ""); err == nil {
+		t.Error("generateForURL() expected error, got nil")
+	}
+}
+
+func TestRunLLMEmptyResponse(t *testing.T) {
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{})
+
+	if _, err := generateForURL(context.Background(), "https://example.com", "prompt", "test", ""); err == nil {
+		t.Error("generateForURL() expected error for empty response, got nil")
+	}
+}
+
+func TestFormatCitations(t *testing.T) {
+	sources := []groundingSource{
+		{Title: "NASA", URI: "https://nasa.gov/sky"},
+		{URI: "https://wiki.org/moon"},
+	}
+
+	result := formatCitations("Answer\n", sources, "en")
+	expected := "Answer\n\nSources:\n[1] NASA - https://nasa.gov/sky\n[2] https://wiki.org/moon - https://wiki.org/moon"
+	if result != expected {
+		t.Errorf("formatCitations() = %q, want %q", result, expected)
+	}
+
+	if got := formatCitations("Answer", sources[:1], "ru"); got != "Answer\n\nИсточники:\n[1] NASA - https://nasa.gov/sky" {
+		t.Errorf("formatCitations() in Russian = %q", got)
+	}
+
+	if formatCitations("Answer", nil, "en") != "Answer" {
+		t.Error("formatCitations() without sources should return the text unchanged")
+	}
+}
+
+func TestGroundingSourcesSkipsNonWeb(t *testing.T) {
+	metadata := &genai.GroundingMetadata{GroundingChunks: []*genai.GroundingChunk{
+		{},
+		{Web: &genai.GroundingChunkWeb{Title: "No URI"}},
+		{Web: &genai.GroundingChunkWeb{Domain: "example.com", URI: "https://example.com/a"}},
+	}}
+
+	sources := groundingSources(metadata)
+	if len(sources) != 1 || sources[0].Title != "example.com" {
+		t.Errorf("groundingSources() = %v, want one source titled by domain", sources)
+	}
+	if groundingSources(nil) != nil {
+		t.Error("groundingSources(nil) should return nil")
+	}
+}
+
+func TestGetFactCheck(t *testing.T) {
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{
+		textChunk("✅ True: example.com is an example domain.", groundingSource{"IANA", "https://iana.org/domains/example"}),
+	}})
+
+	result := getFactCheck(context.Background(), "look https://example.com", "")
+	if !result.OK() {
+		t.Fatalf("getFactCheck() = %s, answer %q", result.Category, result.Answer)
+	}
+	answer := result.Answer
+	if !strings.Contains(answer, "\n\nSources:\n[1] IANA - https://iana.org/domains/example") {
+		t.Errorf("answer = %q, want numbered citation", answer)
+	}
+}
+
+func TestGetFactCheckErrors(t *testing.T) {
+	silenceStdout(t)
+	useFakeLLMProvider(t, &fakeLLMProvider{err: errors.New("boom")})
+
+	tests := []struct {
+		text     string
+		category resultCategory
+		expected string
+	}{
+		{"", resultNoText, "No text to fact-check."},
+		{"no links here", resultNoURL, "There is no link to fact-check 🤷"},
+		{"https://example.com", resultLLMError, "I couldn't fact-check this link, try again later 😴"},
+	}
+
+	for _, tt := range tests {
+		result := getFactCheck(context.Background(), tt.text, "")
+		if result.Category != tt.category || result.Answer != tt.expected {
+			t.Errorf("getFactCheck(%q) = %q, %s, want %q, %s", tt.text, result.Answer, result.Category, tt.expected, tt.category)
+		}
+	}
+}
+
+func TestFactCheckCommandUsesSeparateCache(t *testing.T) {
+	mr := useMiniredis(t)
+	silenceStdout(t)
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	cmd := factcheckCommand(allowlist, nil)
+	if cmd.Cost != 2 || !cmd.NeedsReply {
+		t.Errorf("factcheck command = %+v, want cost 2 and reply required", cmd)
+	}
+
+	mr.Set("factcheck:-1001234567890:41", "1")
+	reply := ""
+	if err := cmd.Handler(newAnalysisMockContext(nil, &reply), cmd); err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	if reply != "I've already fact-checked this, try to use search" {
+		t.Errorf("reply = %q, want duplicate notice", reply)
+	}
+}
+
+func TestAnalyzeTextWithLLM(t *testing.T) {
+	silenceStdout(t)
+	fake := &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{textChunk("Bold claims, no evidence.")}}
+	useFakeLLMProvider(t, fake)
+
+	answer, err := analyzeTextWithLLM(context.Background(), "A long rant about tabs and spaces", PromptPositive, "")
+	if err != nil || answer != "Bold claims, no evidence." {
+		t.Fatalf("analyzeTextWithLLM() = %q, %v", answer, err)
+	}
+	if fake.contents[0].Parts[0].Text != "A long rant about tabs and spaces" {
+		t.Errorf("contents = %q, want the message text", fake.contents[0].Parts[0].Text)
+	}
+	if len(fake.config.Tools) != 0 {
+		t.Errorf("text analysis should not use tools, got %d", len(fake.config.Tools))
+	}
+}
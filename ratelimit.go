@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// dailyRequestLimit is the number of rate-limit units a user can spend per 24 hours
+const dailyRequestLimit = 5
+
+// consumeRateLimit records cost units for the user in a 24 hour sliding window.
+// It returns false without recording anything if the request does not fit in the limit.
+func consumeRateLimit(ctx context.Context, rdb redis.UniversalClient, userID int64, member string, cost int) (bool, int64) {
+	if cost <= 0 {
+		return true, 0
+	}
+
+	rateLimitKey := redisKey("ratelimit:%d", userID)
+	now := time.Now()
+	timeRange := now.Add(-24 * time.Hour)
+
+	// Remove old entries (older than timeRange)
+	rdb.ZRemRangeByScore(ctx, rateLimitKey, "0", fmt.Sprintf("%d", timeRange.Unix()))
+
+	// Count recent attempts
+	count, err := rdb.ZCount(ctx, rateLimitKey, fmt.Sprintf("%d", timeRange.Unix()), "+inf").Result()
+	if err == nil && count+int64(cost) > dailyRequestLimit {
+		return false, count
+	}
+
+	// Add current attempt to rate limit tracking, one member per unit
+	entries := make([]redis.Z, 0, cost)
+	for _, m := range rateLimitMembers(member, cost) {
+		entries = append(entries, redis.Z{Score: float64(now.Unix()), Member: m})
+	}
+	rdb.ZAdd(ctx, rateLimitKey, entries...)
+	// Set expiration to 2 days
+	rdb.Expire(ctx, rateLimitKey, 48*time.Hour)
+
+	return true, count
+}
+
+// refundRateLimit removes the units recorded by consumeRateLimit for a request
+func refundRateLimit(ctx context.Context, rdb redis.UniversalClient, userID int64, member string, cost int) error {
+	if cost <= 0 {
+		return nil
+	}
+	members := make([]interface{}, 0, cost)
+	for _, m := range rateLimitMembers(member, cost) {
+		members = append(members, m)
+	}
+	return rdb.ZRem(ctx, redisKey("ratelimit:%d", userID), members...).Err()
+}
+
+// rateLimitMembers names the sorted set members of a request, one per unit
+func rateLimitMembers(member string, cost int) []string {
+	members := make([]string, 0, cost)
+	for i := 0; i < cost; i++ {
+		if i == 0 {
+			members = append(members, member)
+		} else {
+			members = append(members, fmt.Sprintf("%s:%d", member, i))
+		}
+	}
+	return members
+}
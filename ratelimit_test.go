@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestConsumeRateLimit(t *testing.T) {
+	useMiniredis(t)
+	ctx := context.Background()
+
+	for i := 0; i < dailyRequestLimit; i++ {
+		if allowed, _ := consumeRateLimit(ctx, redisClient, 42, fmt.Sprintf("-100:%d", i), 1); !allowed {
+			t.Fatalf("request %d was rejected within the limit", i+1)
+		}
+	}
+
+	allowed, count := consumeRateLimit(ctx, redisClient, 42, "chat:over", 1)
+	if allowed {
+		t.Error("request over the limit was allowed")
+	}
+	if count != dailyRequestLimit {
+		t.Errorf("count = %d, want %d", count, dailyRequestLimit)
+	}
+
+	if allowed, _ := consumeRateLimit(ctx, redisClient, 43, "chat:other", 1); !allowed {
+		t.Error("another user's request was rejected")
+	}
+}
+
+func TestConsumeRateLimitCost(t *testing.T) {
+	mr := useMiniredis(t)
+	ctx := context.Background()
+
+	if allowed, _ := consumeRateLimit(ctx, redisClient, 42, "-100:1", 3); !allowed {
+		t.Fatal("request with cost 3 was rejected")
+	}
+	members, _ := mr.ZMembers("ratelimit:42")
+	if len(members) != 3 {
+		t.Errorf("recorded %d units, want 3", len(members))
+	}
+
+	if allowed, _ := consumeRateLimit(ctx, redisClient, 42, "-100:2", 3); allowed {
+		t.Error("request exceeding the remaining budget was allowed")
+	}
+	if allowed, _ := consumeRateLimit(ctx, redisClient, 42, "-100:3", 2); !allowed {
+		t.Error("request fitting the remaining budget was rejected")
+	}
+}
+
+func TestConsumeRateLimitZeroCost(t *testing.T) {
+	mr := useMiniredis(t)
+
+	if allowed, _ := consumeRateLimit(context.Background(), redisClient, 42, "-100:1", 0); !allowed {
+		t.Error("free request was rejected")
+	}
+	if mr.Exists("ratelimit:42") {
+		t.Error("free request was recorded")
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Policies for rate limiting while Redis is unreachable
+const (
+	redisFailOpen   = "fail_open"   // skip rate limiting
+	redisFailClosed = "fail_closed" // reject new requests from non-excluded users
+)
+
+// redisClient is the configured client; use activeRedis to get it only while reachable
+var redisClient redis.UniversalClient
+
+// redisUp reports whether the last health check of redisClient succeeded
+var redisUp atomic.Bool
+
+// redisConnectHooks run every time Redis becomes reachable, e.g. to reload persisted state
+var redisConnectHooks []func(ctx context.Context, client redis.UniversalClient)
+
+// redisUnavailablePolicy decides how rate limiting behaves while Redis is down
+var redisUnavailablePolicy = redisFailOpen
+
+// redisKeyPrefix is prepended to every key the bot writes, so several
+// bot instances can share one Redis/Valkey server
+var redisKeyPrefix string
+
+// redisConfig holds the Redis/Valkey connection settings
+type redisConfig struct {
+	Addrs            []string
+	Username         string
+	Password         string
+	DB               int
+	TLS              bool
+	TLSCAFile        string
+	TLSServerName    string
+	TLSSkipVerify    bool
+	SentinelMaster   string
+	SentinelUsername string
+	SentinelPassword string
+	Cluster          bool
+	KeyPrefix        string
+}
+
+// loadRedisConfig reads the Redis configuration from environment variables.
+// REDIS_URL (redis:// or rediss://) is applied first, explicit variables override it.
+func loadRedisConfig() (redisConfig, error) {
+	cfg := redisConfig{
+		Addrs: []string{"localhost:6379"},
+	}
+
+	if rawURL := os.Getenv("REDIS_URL"); rawURL != "" {
+		opts, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		cfg.Addrs = []string{opts.Addr}
+		cfg.Username = opts.Username
+		cfg.Password = opts.Password
+		cfg.DB = opts.DB
+		cfg.TLS = opts.TLSConfig != nil
+	}
+
+	if addrs := parseRedisAddrs(os.Getenv("REDIS_ADDR")); len(addrs) > 0 {
+		cfg.Addrs = addrs
+	}
+	if v := os.Getenv("REDIS_USERNAME"); v != "" {
+		cfg.Username = v
+	}
+	if v := os.Getenv("REDIS_PASSWORD"); v != "" {
+		cfg.Password = v
+	}
+	if v := os.Getenv("REDIS_DB"); v != "" {
+		db, err := strconv.Atoi(v)
+		if err != nil || db < 0 {
+			return cfg, fmt.Errorf("invalid REDIS_DB %q", v)
+		}
+		cfg.DB = db
+	}
+
+	var err error
+	if cfg.TLS, err = parseBoolEnv("REDIS_TLS", cfg.TLS); err != nil {
+		return cfg, err
+	}
+	if cfg.TLSSkipVerify, err = parseBoolEnv("REDIS_TLS_SKIP_VERIFY", false); err != nil {
+		return cfg, err
+	}
+	if cfg.Cluster, err = parseBoolEnv("REDIS_CLUSTER", false); err != nil {
+		return cfg, err
+	}
+	cfg.TLSCAFile = os.Getenv("REDIS_TLS_CA_FILE")
+	cfg.TLSServerName = os.Getenv("REDIS_TLS_SERVER_NAME")
+	if cfg.TLSCAFile != "" || cfg.TLSServerName != "" || cfg.TLSSkipVerify {
+		cfg.TLS = true
+	}
+
+	cfg.SentinelMaster = os.Getenv("REDIS_SENTINEL_MASTER")
+	cfg.SentinelUsername = os.Getenv("REDIS_SENTINEL_USERNAME")
+	cfg.SentinelPassword = os.Getenv("REDIS_SENTINEL_PASSWORD")
+
+	cfg.KeyPrefix = os.Getenv("REDIS_KEY_PREFIX")
+
+	if cfg.Cluster && cfg.SentinelMaster != "" {
+		return cfg, fmt.Errorf("REDIS_CLUSTER and REDIS_SENTINEL_MASTER are mutually exclusive")
+	}
+	if cfg.Cluster && cfg.DB != 0 {
+		return cfg, fmt.Errorf("REDIS_DB must be 0 in cluster mode")
+	}
+
+	return cfg, nil
+}
+
+// mode returns a human readable name of the connection mode
+func (cfg redisConfig) mode() string {
+	switch {
+	case cfg.SentinelMaster != "":
+		return "sentinel"
+	case cfg.Cluster || len(cfg.Addrs) > 1:
+		return "cluster"
+	default:
+		return "standalone"
+	}
+}
+
+// universalOptions converts the config into go-redis options
+func (cfg redisConfig) universalOptions() (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		DB:               cfg.DB,
+		MasterName:       cfg.SentinelMaster,
+		SentinelUsername: cfg.SentinelUsername,
+		SentinelPassword: cfg.SentinelPassword,
+		IsClusterMode:    cfg.Cluster,
+	}
+
+	if cfg.TLS {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	return opts, nil
+}
+
+// tlsConfig builds the TLS configuration, loading a custom CA if configured
+func (cfg redisConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.TLSSkipVerify,
+	}
+
+	if cfg.TLSCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// newRedisClient creates a standalone, sentinel or cluster client from the config
+func newRedisClient(cfg redisConfig) (redis.UniversalClient, error) {
+	opts, err := cfg.universalOptions()
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewUniversalClient(opts)
+	client.AddHook(redisTracingHook{})
+	client.AddHook(redisMetricsHook{})
+	return client, nil
+}
+
+// redisKey builds a namespaced Redis key
+func redisKey(format string, args ...interface{}) string {
+	return redisKeyPrefix + fmt.Sprintf(format, args...)
+}
+
+// parseRedisAddrs parses a comma-separated list of host:port addresses
+func parseRedisAddrs(addrsStr string) []string {
+	var addrs []string
+
+	for _, part := range strings.Split(addrsStr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		addrs = append(addrs, part)
+	}
+
+	return addrs
+}
+
+// parseBoolEnv reads a boolean environment variable, returning def if it is unset
+func parseBoolEnv(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	return b, nil
+}
+
+// activeRedis returns the Redis client if it is reachable, nil otherwise
+func activeRedis() redis.UniversalClient {
+	if redisClient == nil || !redisUp.Load() {
+		return nil
+	}
+	return redisClient
+}
+
+// checkRedis pings the client, updates the availability flag and logs state changes
+func checkRedis(ctx context.Context, client redis.UniversalClient) bool {
+	pingCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := client.Ping(pingCtx).Err()
+	up := err == nil
+
+	if previous := redisUp.Swap(up); previous != up {
+		if up {
+			logJSON("info", "Redis connection established", nil)
+			for _, hook := range redisConnectHooks {
+				hook(ctx, client)
+			}
+		} else {
+			logJSON("warn", "Redis connection lost, continuing without cache", map[string]interface{}{
+				"error":  err.Error(),
+				"policy": redisUnavailablePolicy,
+			})
+		}
+	}
+
+	return up
+}
+
+// monitorRedis checks the connection every interval until ctx is cancelled,
+// attaching the client as soon as Redis becomes reachable
+func monitorRedis(ctx context.Context, client redis.UniversalClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkRedis(ctx, client)
+		}
+	}
+}
+
+// parseRedisUnavailablePolicy validates the REDIS_UNAVAILABLE_POLICY value
+func parseRedisUnavailablePolicy(policy string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", redisFailOpen:
+		return redisFailOpen, nil
+	case redisFailClosed:
+		return redisFailClosed, nil
+	default:
+		return "", fmt.Errorf("invalid REDIS_UNAVAILABLE_POLICY %q, expected %s or %s", policy, redisFailOpen, redisFailClosed)
+	}
+}
+
+// parseDurationEnv reads a positive duration environment variable, returning def if it is unset
+func parseDurationEnv(name string, def time.Duration) (time.Duration, error) {
+	d, err := parseNonNegativeDurationEnv(name, def)
+	if err == nil && d == 0 {
+		return def, fmt.Errorf("invalid %s %q", name, os.Getenv(name))
+	}
+	return d, err
+}
+
+// parseNonNegativeDurationEnv reads a duration environment variable that may be zero,
+// returning def if it is unset
+func parseNonNegativeDurationEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return def, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return d, nil
+}
+
+// parseIntEnv reads a positive integer environment variable, returning def if it is unset
+func parseIntEnv(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return def, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
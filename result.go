@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// resultCategory classifies how a request was processed
+type resultCategory string
+
+const (
+	resultOK            resultCategory = "ok"
+	resultNoText        resultCategory = "no_text"        // nothing to analyze in the message, document or recording
+	resultNoURL         resultCategory = "no_url"         // no link, answered with a refusal
+	resultTooLarge      resultCategory = "too_large"      // media over the size or duration limit
+	resultUnsupported   resultCategory = "unsupported"    // media format the model can't read
+	resultFetchFailed   resultCategory = "fetch_failed"   // the link or media could not be retrieved or read
+	resultBlockedDomain resultCategory = "blocked_domain" // the link was refused as unsafe
+	resultSafetyBlocked resultCategory = "safety_blocked" // the safety filters blocked the prompt or answer
+	resultRecitation    resultCategory = "recitation"     // the answer stopped for reciting its sources
+	resultTruncated     resultCategory = "truncated"      // the answer hit the output token limit
+	resultLLMTimeout    resultCategory = "llm_timeout"
+	resultLLMQuota      resultCategory = "llm_quota" // the API rejected the request for the quota
+	resultLLMError      resultCategory = "llm_error"
+	resultCancelled     resultCategory = "cancelled" // aborted by a shutdown
+)
+
+// analysisResult is the reply to a request with its category; Err is the cause of a failure
+type analysisResult struct {
+	Answer   string
+	Category resultCategory
+	Err      error
+}
+
+// OK checks if the request was answered
+func (r analysisResult) OK() bool {
+	return r.Category == resultOK
+}
+
+// answered is a successful result
+func answered(answer string) analysisResult {
+	return analysisResult{Answer: answer, Category: resultOK}
+}
+
+// failed is a failed result replied with the given text
+func failed(category resultCategory, reply string, err error) analysisResult {
+	return analysisResult{Answer: reply, Category: category, Err: err}
+}
+
+// Cache durations of the answered marker
+const (
+	answerCacheTTL  = 30 * 24 * time.Hour
+	blockedCacheTTL = 24 * time.Hour
+)
+
+// resultPolicy is how handleAnalysisCommand treats a category
+type resultPolicy struct {
+	Cache   time.Duration // how long the replied message is marked, 0 to not mark it
+	Refund  bool          // give the rate-limit units back
+	Outcome string        // reported by brm_commands_total
+}
+
+// resultPolicies cache answers and refusals that would repeat, and refund the
+// requests that failed for reasons outside the user's control
+var resultPolicies = map[resultCategory]resultPolicy{
+	resultOK:            {Cache: answerCacheTTL, Outcome: outcomeSuccess},
+	resultNoText:        {Refund: true, Outcome: outcomeRejected},
+	resultNoURL:         {Refund: true, Outcome: outcomeRejected},
+	resultTooLarge:      {Refund: true, Outcome: outcomeRejected},
+	resultUnsupported:   {Refund: true, Outcome: outcomeRejected},
+	resultFetchFailed:   {Refund: true, Outcome: outcomeRejected},
+	resultBlockedDomain: {Cache: blockedCacheTTL, Outcome: outcomeRejected},
+	resultSafetyBlocked: {Cache: blockedCacheTTL, Outcome: outcomeLLMError},
+	resultRecitation:    {Refund: true, Outcome: outcomeLLMError},
+	resultTruncated:     {Refund: true, Outcome: outcomeLLMError},
+	resultLLMTimeout:    {Refund: true, Outcome: outcomeLLMError},
+	resultLLMQuota:      {Refund: true, Outcome: outcomeLLMError},
+	resultLLMError:      {Refund: true, Outcome: outcomeLLMError},
+	resultCancelled:     {Refund: true, Outcome: outcomeCancelled},
+}
+
+// cachedRefusals are the replies to messages marked with a refusal category
+var cachedRefusals = map[resultCategory]messageKey{
+	resultBlockedDomain: msgBlockedDomain,
+	resultSafetyBlocked: msgBlocked,
+}
+
+// rateLimitCharge are the rate-limit units consumed by a request
+type rateLimitCharge struct {
+	UserID int64
+	Member string
+	Cost   int
+}
+
+// settleResult applies the policy of the result category: it records the command
+// outcome, gives back the charge (nil if nothing was consumed) for failures outside
+// the user's control and marks cacheKey (if set) as answered or refused.
+// Failures caused by a shutdown become a request to retry.
+func settleResult(ctx context.Context, c tele.Context, rdb redis.UniversalClient, command string, result analysisResult, language string, charge *rateLimitCharge, cacheKey string) analysisResult {
+	if !result.OK() && inflight.Cancelled() {
+		result = failed(resultCancelled, localize(language, msgRestarting), result.Err)
+	}
+
+	policy := resultPolicies[result.Category]
+	recordCommand(command, policy.Outcome)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("command.outcome", policy.Outcome),
+		attribute.String("command.result", string(result.Category)),
+	)
+	if !result.OK() {
+		fields := map[string]interface{}{
+			"user":     getUserInfo(c),
+			"chat":     getChatInfo(c),
+			"category": result.Category,
+			"mode":     command,
+		}
+		if result.Err != nil {
+			fields["error"] = result.Err.Error()
+		}
+		logJSONContext(ctx, "info", "Request not answered", fields)
+	}
+	if rdb == nil {
+		return result
+	}
+
+	if policy.Refund && charge != nil {
+		if err := refundRateLimit(ctx, rdb, charge.UserID, charge.Member, charge.Cost); err != nil {
+			logJSONContext(ctx, "warn", "Failed to refund rate limit", map[string]interface{}{
+				"error": err.Error(),
+				"mode":  command,
+			})
+		}
+	}
+
+	// Mark the message as answered, or as refused when asking again would be refused too
+	if policy.Cache > 0 && cacheKey != "" {
+		var marker interface{} = time.Now().Unix()
+		if !result.OK() {
+			marker = string(result.Category)
+		}
+		if err := rdb.Set(ctx, cacheKey, marker, policy.Cache).Err(); err != nil {
+			logJSONContext(ctx, "warn", "Failed to cache result", map[string]interface{}{
+				"error": err.Error(),
+				"mode":  command,
+			})
+		}
+	}
+	return result
+}
+
+// llmErrorCategory classifies an error returned by runLLM
+func llmErrorCategory(err error) resultCategory {
+	var apiErr genai.APIError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return resultCancelled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return resultLLMTimeout
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusGatewayTimeout:
+		return resultLLMTimeout
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests:
+		return resultLLMQuota
+	case errors.Is(err, errLLMBlocked):
+		return resultSafetyBlocked
+	case errors.Is(err, errLLMRecitation):
+		return resultRecitation
+	case errors.Is(err, errLLMTruncated):
+		return resultTruncated
+	case errors.Is(err, errURLUnsafe):
+		return resultBlockedDomain
+	case errors.Is(err, errURLUnavailable):
+		return resultFetchFailed
+	default:
+		return resultLLMError
+	}
+}
+
+// llmFailureMessages are the replies to stopped answers, by category and tone;
+// the empty tone is for the neutral /tldr and /factcheck answers
+var llmFailureMessages = map[resultCategory]map[PromptType]messageKey{
+	resultSafetyBlocked: {
+		"":             msgBlocked,
+		PromptBullshit: msgBlockedBullshit,
+		PromptPositive: msgBlockedPositive,
+		PromptNegative: msgBlockedNegative,
+	},
+	resultRecitation: {
+		"":             msgRecited,
+		PromptBullshit: msgRecitedBullshit,
+		PromptPositive: msgRecitedPositive,
+		PromptNegative: msgRecitedNegative,
+	},
+	resultTruncated: {
+		"":             msgTruncated,
+		PromptBullshit: msgTruncatedBullshit,
+		PromptPositive: msgTruncatedPositive,
+		PromptNegative: msgTruncatedNegative,
+	},
+}
+
+// llmCategoryMessages are the replies to the other LLM failures with their own message
+var llmCategoryMessages = map[resultCategory]messageKey{
+	resultLLMTimeout:    msgLLMTimeout,
+	resultLLMQuota:      msgLLMQuota,
+	resultFetchFailed:   msgFetchFailed,
+	resultBlockedDomain: msgBlockedDomain,
+}
+
+// llmFailed classifies an LLM error and picks its reply: the message of the category
+// in the tone of the answer, or the fallback
+func llmFailed(language string, tone PromptType, err error, fallback messageKey) analysisResult {
+	category := llmErrorCategory(err)
+	key := fallback
+	if keys, ok := llmFailureMessages[category]; ok {
+		key = keys[tone]
+	} else if k, ok := llmCategoryMessages[category]; ok {
+		key = k
+	}
+	return failed(category, localize(language, key), err)
+}
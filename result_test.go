@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestLLMErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want resultCategory
+	}{
+		{errors.New("stream error"), resultLLMError},
+		{context.Canceled, resultCancelled},
+		{fmt.Errorf("stream: %w", context.DeadlineExceeded), resultLLMTimeout},
+		{genai.APIError{Code: http.StatusGatewayTimeout}, resultLLMTimeout},
+		{genai.APIError{Code: http.StatusTooManyRequests}, resultLLMQuota},
+		{genai.APIError{Code: http.StatusInternalServerError}, resultLLMError},
+		{&llmFinishError{Reason: string(genai.BlockedReasonSafety), Prompt: true}, resultSafetyBlocked},
+		{&llmFinishError{Reason: string(genai.FinishReasonRecitation)}, resultRecitation},
+		{&llmFinishError{Reason: string(genai.FinishReasonMaxTokens)}, resultTruncated},
+		{&llmFinishError{Reason: string(genai.FinishReasonOther)}, resultLLMError},
+		{&urlRetrievalError{Status: genai.URLRetrievalStatusUnsafe}, resultBlockedDomain},
+		{&urlRetrievalError{Status: genai.URLRetrievalStatusPaywall}, resultFetchFailed},
+	}
+	for _, tt := range tests {
+		if got := llmErrorCategory(tt.err); got != tt.want {
+			t.Errorf("llmErrorCategory(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestLLMFailed(t *testing.T) {
+	blocked := &llmFinishError{Reason: string(genai.FinishReasonSafety)}
+	truncated := &llmFinishError{Reason: string(genai.FinishReasonMaxTokens)}
+	tests := []struct {
+		tone PromptType
+		err  error
+		want messageKey
+	}{
+		{PromptBullshit, blocked, msgBlockedBullshit},
+		{PromptPositive, truncated, msgTruncatedPositive},
+		{"", &llmFinishError{Reason: string(genai.FinishReasonRecitation)}, msgRecited},
+		{PromptNegative, genai.APIError{Code: http.StatusTooManyRequests}, msgLLMQuota},
+		{PromptNegative, &urlRetrievalError{Status: genai.URLRetrievalStatusError}, msgFetchFailed},
+		{PromptNegative, &llmFinishError{Reason: string(genai.FinishReasonOther)}, msgTired},
+		{PromptNegative, errors.New("stream error"), msgTired},
+	}
+	for _, tt := range tests {
+		result := llmFailed("de", tt.tone, tt.err, msgTired)
+		if result.Answer != localize("de", tt.want) || result.Err == nil || result.OK() {
+			t.Errorf("llmFailed(%q, %v) = %q, %s, want %s", tt.tone, tt.err, result.Answer, result.Category, tt.want)
+		}
+	}
+}
+
+func TestResultPolicies(t *testing.T) {
+	categories := []resultCategory{
+		resultOK, resultNoText, resultNoURL, resultTooLarge, resultUnsupported, resultFetchFailed,
+		resultBlockedDomain, resultSafetyBlocked, resultRecitation, resultTruncated,
+		resultLLMTimeout, resultLLMQuota, resultLLMError, resultCancelled,
+	}
+	for _, category := range categories {
+		policy, ok := resultPolicies[category]
+		if !ok || policy.Outcome == "" {
+			t.Errorf("category %s has no policy", category)
+			continue
+		}
+		if policy.Cache > 0 && policy.Refund {
+			t.Errorf("category %s is both cached and refunded", category)
+		}
+		if _, refused := cachedRefusals[category]; policy.Cache > 0 && category != resultOK && !refused {
+			t.Errorf("category %s is cached without a refusal to repeat", category)
+		}
+	}
+}
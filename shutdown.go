@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// shutdownGracePeriod is how long in-flight requests may run after a shutdown signal,
+// configured with SHUTDOWN_GRACE_PERIOD
+var shutdownGracePeriod = 25 * time.Second
+
+// shutdownCancelWait bounds the wait for requests to reply after they were cancelled
+const shutdownCancelWait = 5 * time.Second
+
+// requestTracker counts in-flight requests; its context is cancelled when the
+// grace period runs out, which aborts LLM calls still streaming
+type requestTracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	draining bool
+	wg       sync.WaitGroup
+}
+
+// newRequestTracker creates a tracker that accepts requests
+func newRequestTracker() *requestTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &requestTracker{ctx: ctx, cancel: cancel}
+}
+
+// inflight tracks the requests handled by the bot
+var inflight = newRequestTracker()
+
+// Begin registers a request; it returns false once the bot is shutting down
+func (t *requestTracker) Begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.wg.Add(1)
+	return true
+}
+
+// End marks a request started with Begin as finished
+func (t *requestTracker) End() {
+	t.wg.Done()
+}
+
+// Draining checks if the bot stopped accepting requests
+func (t *requestTracker) Draining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// Context is the parent context of work done for requests
+func (t *requestTracker) Context() context.Context {
+	return t.ctx
+}
+
+// Cancelled checks if in-flight requests were cancelled by a shutdown
+func (t *requestTracker) Cancelled() bool {
+	return t.ctx.Err() != nil
+}
+
+// Drain stops accepting requests and waits up to grace for in-flight ones; the
+// rest are cancelled and get cancelWait to reply. It returns false if some
+// requests were still running when it gave up.
+func (t *requestTracker) Drain(grace time.Duration, cancelWait time.Duration) bool {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(grace):
+	}
+
+	logJSON("warn", "Grace period expired, cancelling in-flight requests", map[string]interface{}{
+		"grace_period": grace.String(),
+	})
+	t.cancel()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(cancelWait):
+		return false
+	}
+}
+
+// trackRequests is a middleware that registers every update handler with the
+// tracker and turns requests arriving during shutdown away
+func trackRequests(t *requestTracker) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if !t.Begin() {
+				if c.Message() == nil {
+					return nil
+				}
+				return c.Reply(localize(userLanguage(c), msgRestarting))
+			}
+			defer t.End()
+			return next(c)
+		}
+	}
+}
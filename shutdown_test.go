@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// blockingLLMProvider streams nothing until the request context is cancelled
+type blockingLLMProvider struct {
+	started chan struct{}
+}
+
+func (b *blockingLLMProvider) GenerateContentStream(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		close(b.started)
+		<-ctx.Done()
+		yield(nil, ctx.Err())
+	}
+}
+
+// useRequestTracker replaces the global request tracker for the duration of the test
+func useRequestTracker(t *testing.T) *requestTracker {
+	t.Helper()
+	original := inflight
+	inflight = newRequestTracker()
+	t.Cleanup(func() { inflight = original })
+	return inflight
+}
+
+func TestRequestTrackerDrainWaitsForRequests(t *testing.T) {
+	tracker := newRequestTracker()
+	if !tracker.Begin() {
+		t.Fatal("Begin() = false before shutdown")
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tracker.End()
+	}()
+
+	if !tracker.Drain(time.Second, time.Second) {
+		t.Error("Drain() = false, want the request to finish within the grace period")
+	}
+	if tracker.Cancelled() {
+		t.Error("requests finished in time should not be cancelled")
+	}
+	if tracker.Begin() {
+		t.Error("Begin() = true while draining, want new requests rejected")
+	}
+}
+
+func TestRequestTrackerDrainCancelsStragglers(t *testing.T) {
+	silenceStdout(t)
+	tracker := newRequestTracker()
+	tracker.Begin()
+	go func() {
+		<-tracker.Context().Done()
+		tracker.End()
+	}()
+
+	if !tracker.Drain(10*time.Millisecond, time.Second) {
+		t.Error("Drain() = false, want the cancelled request to finish")
+	}
+	if !tracker.Cancelled() {
+		t.Error("Cancelled() = false after the grace period expired")
+	}
+}
+
+func TestRequestTrackerDrainGivesUp(t *testing.T) {
+	silenceStdout(t)
+	tracker := newRequestTracker()
+	tracker.Begin()
+
+	start := time.Now()
+	if tracker.Drain(10*time.Millisecond, 10*time.Millisecond) {
+		t.Error("Drain() = true, want false for a request that never finishes")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Drain() took %s, want it bounded by the grace period and cancel wait", elapsed)
+	}
+}
+
+func TestTrackRequestsRejectsDuringShutdown(t *testing.T) {
+	tracker := newRequestTracker()
+	tracker.Drain(0, 0)
+
+	called := false
+	handler := trackRequests(tracker)(func(c tele.Context) error {
+		called = true
+		return nil
+	})
+
+	reply := ""
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	mockCtx.sender.LanguageCode = "ru"
+	if err := handler(mockCtx); err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	if called {
+		t.Error("handler should not run while shutting down")
+	}
+	if reply != localize("ru", msgRestarting) {
+		t.Errorf("reply = %q, want the restarting notice", reply)
+	}
+}
+
+func TestShutdownCancelsInFlightOpinion(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	tracker := useRequestTracker(t)
+	provider := &blockingLLMProvider{started: make(chan struct{})}
+	original := newLLMProvider
+	newLLMProvider = func(ctx context.Context) (llmProvider, error) {
+		return provider, nil
+	}
+	t.Cleanup(func() { newLLMProvider = original })
+
+	allowlist := newChatAllowlist([]int64{-1001234567890})
+	handler := trackRequests(tracker)(func(c tele.Context) error {
+		return handleOpinionCommand(c, allowlist, nil, 1, nil)
+	})
+
+	reply := ""
+	mockCtx := newAnalysisMockContext(nil, &reply)
+	go handler(mockCtx)
+
+	select {
+	case <-provider.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("LLM request did not start")
+	}
+
+	if !tracker.Drain(10*time.Millisecond, 5*time.Second) {
+		t.Fatal("Drain() = false, want the cancelled request to reply")
+	}
+	if reply != localize(defaultLanguage, msgRestarting) {
+		t.Errorf("reply = %q, want the restarting notice", reply)
+	}
+}
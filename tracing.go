@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v3"
+)
+
+// tracer creates the bot spans; it is a no-op until setupTracing installs an exporter
+var tracer = otel.Tracer("github.com/dk/brm")
+
+// requestContextKey stores the context of the current request in the tele.Context
+const requestContextKey = "request_context"
+
+// tracingEnabled checks if an OTLP endpoint is configured with the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables
+func tracingEnabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// setupTracing exports spans over OTLP/HTTP. The exporter, sampler and service name
+// are configured with the standard OTEL_* variables; the returned function flushes
+// the remaining spans on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			attribute.String("service.name", "brm"),
+			attribute.String("service.version", buildVersion),
+		),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// requestContext returns the context of the request handled in c; LLM calls and
+// Redis operations made with it belong to the request trace and stop on shutdown
+func requestContext(c tele.Context) context.Context {
+	if c != nil {
+		if ctx, ok := c.Get(requestContextKey).(context.Context); ok {
+			return ctx
+		}
+	}
+	return inflight.Context()
+}
+
+// startRequestSpan starts a span for the update in c with the chat and user
+// attributes, and makes it the request context of c. The context also carries the
+// requester the LLM usage is accounted to.
+func startRequestSpan(c tele.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(withRequester(requestContext(c), c), name, trace.WithAttributes(append(requestAttributes(c), attrs...)...))
+	c.Set(requestContextKey, ctx)
+	return ctx, span
+}
+
+// requestAttributes describes the chat, user and message of an update
+func requestAttributes(c tele.Context) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if chat := c.Chat(); chat != nil {
+		attrs = append(attrs,
+			attribute.Int64("telegram.chat.id", chat.ID),
+			attribute.String("telegram.chat.type", string(chat.Type)),
+		)
+	}
+	if sender := c.Sender(); sender != nil {
+		attrs = append(attrs, attribute.Int64("telegram.user.id", sender.ID))
+	}
+	if msg := c.Message(); msg != nil {
+		attrs = append(attrs, attribute.Int("telegram.message.id", msg.ID))
+		if msg.ReplyTo != nil {
+			attrs = append(attrs, attribute.Int("telegram.reply_to.id", msg.ReplyTo.ID))
+		}
+	}
+	return attrs
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// redisTracingHook creates a span for every Redis command and pipeline; keys
+// and values are not recorded
+type redisTracingHook struct{}
+
+func (redisTracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisTracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := tracer.Start(ctx, "redis "+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.String("db.operation.name", cmd.Name()),
+			),
+		)
+		err := next(ctx, cmd)
+		if errors.Is(err, redis.Nil) {
+			endSpan(span, nil)
+		} else {
+			endSpan(span, err)
+		}
+		return err
+	}
+}
+
+func (redisTracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		names := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			names[i] = cmd.Name()
+		}
+		ctx, span := tracer.Start(ctx, "redis pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.StringSlice("db.operation.names", names),
+				attribute.Int("db.operation.batch.size", len(cmds)),
+			),
+		)
+		err := next(ctx, cmds)
+		if errors.Is(err, redis.Nil) {
+			endSpan(span, nil)
+		} else {
+			endSpan(span, err)
+		}
+		return err
+	}
+}
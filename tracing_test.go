@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/genai"
+)
+
+// storingContext is a MockContextWithReply that keeps the values set during the update
+type storingContext struct {
+	*MockContextWithReply
+	store map[string]interface{}
+}
+
+func (s *storingContext) Get(key string) interface{}      { return s.store[key] }
+func (s *storingContext) Set(key string, val interface{}) { s.store[key] = val }
+
+// useSpanRecorder records the spans created during the test
+func useSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	original := tracer
+	tracer = provider.Tracer("test")
+	t.Cleanup(func() {
+		tracer = original
+		provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+// findSpan returns the first ended span with the name
+func findSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	var names []string
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+		names = append(names, span.Name())
+	}
+	t.Fatalf("span %q not found, got %v", name, names)
+	return nil
+}
+
+// spanAttribute returns the value of the span attribute with the key
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestHandleAnalysisCommandSpans(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	recorder := useSpanRecorder(t)
+	redisClient.AddHook(redisTracingHook{})
+	useFakeLLMProvider(t, &fakeLLMProvider{err: errors.New("quota exceeded")})
+
+	reply := ""
+	c := &storingContext{MockContextWithReply: newAnalysisMockContext(nil, &reply), store: map[string]interface{}{}}
+	handleAnalysisCommand(c, newChatAllowlist([]int64{-1001234567890}), nil, 1, factcheckMode)
+
+	command := findSpan(t, recorder, "command.factcheck")
+	for key, want := range map[string]interface{}{
+		"telegram.chat.id":     int64(-1001234567890),
+		"telegram.user.id":     int64(123456789),
+		"telegram.reply_to.id": int64(41),
+		"command":              "factcheck",
+		"command.outcome":      outcomeLLMError,
+	} {
+		if got, ok := spanAttribute(command, key); !ok || got.AsInterface() != want {
+			t.Errorf("command span %s = %v, want %v", key, got.AsInterface(), want)
+		}
+	}
+
+	llm := findSpan(t, recorder, "llm.generate")
+	if llm.Parent().SpanID() != command.SpanContext().SpanID() {
+		t.Error("llm.generate should be a child of the command span")
+	}
+	if llm.Status().Code != codes.Error {
+		t.Errorf("llm.generate status = %v, want error", llm.Status().Code)
+	}
+	if got, _ := spanAttribute(llm, "llm.prompt_type"); got.AsString() != "factcheck" {
+		t.Errorf("llm.prompt_type = %q, want factcheck", got.AsString())
+	}
+
+	extract := findSpan(t, recorder, "extract_url")
+	if extract.Parent().SpanID() != command.SpanContext().SpanID() {
+		t.Error("extract_url should be a child of the command span")
+	}
+	if got, _ := spanAttribute(extract, "url.found"); !got.AsBool() {
+		t.Error("extract_url url.found = false, want true")
+	}
+
+	lookup := findSpan(t, recorder, "redis get")
+	if lookup.SpanContext().TraceID() != command.SpanContext().TraceID() {
+		t.Error("Redis spans should belong to the command trace")
+	}
+	if lookup.Status().Code == codes.Error {
+		t.Error("a missing key should not mark the Redis span as failed")
+	}
+}
+
+func TestRunLLMSpanRecordsUsage(t *testing.T) {
+	silenceStdout(t)
+	recorder := useSpanRecorder(t)
+	chunk := textChunk("Fine")
+	chunk.UsageMetadata = &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 4}
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{chunk}})
+
+	if _, err := runLLM(context.Background(), llmRequest{PromptType: "negative", URL: "https://example.com"}); err != nil {
+		t.Fatalf("runLLM() error: %v", err)
+	}
+
+	span := findSpan(t, recorder, "llm.generate")
+	for key, want := range map[string]int64{"llm.chunks": 1, "llm.usage.prompt_tokens": 10, "llm.usage.output_tokens": 4} {
+		if got, _ := spanAttribute(span, key); got.AsInt64() != want {
+			t.Errorf("%s = %d, want %d", key, got.AsInt64(), want)
+		}
+	}
+	if len(span.Events()) == 0 || span.Events()[0].Name != "first_chunk" {
+		t.Errorf("events = %v, want first_chunk", span.Events())
+	}
+}
+
+func TestRequestContextFallsBackToTracker(t *testing.T) {
+	tracker := useRequestTracker(t)
+	if ctx := requestContext(nil); ctx != tracker.Context() {
+		t.Error("requestContext(nil) should be the tracker context")
+	}
+
+	c := &storingContext{MockContextWithReply: &MockContextWithReply{}, store: map[string]interface{}{}}
+	if ctx := requestContext(c); ctx != tracker.Context() {
+		t.Error("requestContext() without a span should be the tracker context")
+	}
+
+	useSpanRecorder(t)
+	ctx, span := startRequestSpan(c, "test")
+	defer span.End()
+	if requestContext(c) != ctx {
+		t.Error("requestContext() should return the context of the request span")
+	}
+
+	tracker.Drain(0, 0)
+	if ctx.Err() == nil {
+		t.Error("the request context should be cancelled with the tracker")
+	}
+}
+
+func TestLogJSONContextAddsTraceIDs(t *testing.T) {
+	useSpanRecorder(t)
+	ctx, span := tracer.Start(context.Background(), "test")
+	defer span.End()
+
+	capture := func(ctx context.Context) map[string]interface{} {
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		logJSONContext(ctx, "info", "Traced", map[string]interface{}{"key": "value"})
+
+		w.Close()
+		os.Stdout = oldStdout
+
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid log output %q: %v", buf.String(), err)
+		}
+		return entry
+	}
+
+	entry := capture(ctx)
+	if entry["trace_id"] != span.SpanContext().TraceID().String() || entry["span_id"] != span.SpanContext().SpanID().String() {
+		t.Errorf("log entry = %v, want the trace and span IDs", entry)
+	}
+	if entry["key"] != "value" {
+		t.Errorf("log entry = %v, want the data fields", entry)
+	}
+
+	entry = capture(context.Background())
+	if _, ok := entry["trace_id"]; ok {
+		t.Errorf("log entry without a span = %v, want no trace_id", entry)
+	}
+}
+
+func TestSetupTracingExportsSpans(t *testing.T) {
+	var exported atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			exported.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+
+	if !tracingEnabled() {
+		t.Fatal("tracingEnabled() = false with OTEL_EXPORTER_OTLP_ENDPOINT set")
+	}
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("setupTracing() error: %v", err)
+	}
+
+	_, span := tracer.Start(context.Background(), "exported")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error: %v", err)
+	}
+	if exported.Load() == 0 {
+		t.Error("no spans were exported to the collector")
+	}
+}
+
+func TestTracingDisabledByDefault(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if tracingEnabled() {
+		t.Error("tracingEnabled() = true without an OTLP endpoint")
+	}
+}
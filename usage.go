@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genai"
+	tele "gopkg.in/telebot.v3"
+)
+
+// usageRetention is how long the daily usage totals are kept
+const usageRetention = 90 * 24 * time.Hour
+
+// usageTopEntries is the number of chats and users listed by /usage
+const usageTopEntries = 5
+
+// modelPrice is the price of a model in USD per million tokens
+type modelPrice struct {
+	Input  float64 // prompt tokens, including tool use prompts
+	Output float64 // output and thinking tokens
+}
+
+// defaultModelPrices are the list prices of the default model and its cheaper
+// sibling; LLM_PRICES overrides them and adds other models
+var defaultModelPrices = map[string]modelPrice{
+	"gemini-flash-latest":      {Input: 0.30, Output: 2.50},
+	"gemini-flash-lite-latest": {Input: 0.10, Output: 0.40},
+}
+
+// modelPrices is the price table used to cost LLM requests
+var modelPrices = defaultModelPrices
+
+// parseModelPrices parses LLM_PRICES as comma-separated model=input:output pairs in
+// USD per million tokens, e.g. "gemini-flash-latest=0.30:2.50", merged over the defaults
+func parseModelPrices(pricesStr string) (map[string]modelPrice, error) {
+	prices := make(map[string]modelPrice, len(defaultModelPrices))
+	for model, price := range defaultModelPrices {
+		prices[model] = price
+	}
+
+	for _, part := range strings.Split(pricesStr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		model, rates, ok := strings.Cut(part, "=")
+		input, output, ok2 := strings.Cut(rates, ":")
+		if !ok || !ok2 || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("invalid LLM_PRICES entry %q, want model=input:output", part)
+		}
+		in, err1 := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		out, err2 := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if err1 != nil || err2 != nil || in < 0 || out < 0 {
+			return nil, fmt.Errorf("invalid LLM_PRICES entry %q, want model=input:output", part)
+		}
+		prices[strings.TrimSpace(model)] = modelPrice{Input: in, Output: out}
+	}
+
+	return prices, nil
+}
+
+// llmUsage is the token usage of one LLM request
+type llmUsage struct {
+	PromptTokens   int64
+	ThoughtsTokens int64
+	OutputTokens   int64
+}
+
+// usageFromMetadata converts the usage reported by the stream
+func usageFromMetadata(metadata *genai.GenerateContentResponseUsageMetadata) llmUsage {
+	if metadata == nil {
+		return llmUsage{}
+	}
+	return llmUsage{
+		PromptTokens:   int64(metadata.PromptTokenCount) + int64(metadata.ToolUsePromptTokenCount),
+		ThoughtsTokens: int64(metadata.ThoughtsTokenCount),
+		OutputTokens:   int64(metadata.CandidatesTokenCount),
+	}
+}
+
+// unpricedModels remembers the models already reported as missing from the price table
+var unpricedModels sync.Map
+
+// CostMicros returns the cost of the usage in millionths of a USD; models missing
+// from the price table cost nothing and are reported once
+func (u llmUsage) CostMicros(model string) int64 {
+	price, ok := modelPrices[model]
+	if !ok {
+		if _, reported := unpricedModels.LoadOrStore(model, true); !reported {
+			logJSON("warn", "LLM model has no price, its usage costs nothing", map[string]interface{}{
+				"model": model,
+			})
+		}
+		return 0
+	}
+	// Prices are per million tokens, so the token counts times the price is in micro-USD
+	return int64(math.Round(float64(u.PromptTokens)*price.Input + float64(u.ThoughtsTokens+u.OutputTokens)*price.Output))
+}
+
+// requester is the chat and user an LLM request is made for
+type requester struct {
+	ChatID int64
+	UserID int64
+}
+
+type requesterContextKey struct{}
+
+// withRequester stores the chat and user of the update in ctx, so runLLM can
+// account the usage to them
+func withRequester(ctx context.Context, c tele.Context) context.Context {
+	var who requester
+	if chat := c.Chat(); chat != nil {
+		who.ChatID = chat.ID
+	}
+	if sender := c.Sender(); sender != nil {
+		who.UserID = sender.ID
+	}
+	return context.WithValue(ctx, requesterContextKey{}, who)
+}
+
+// requesterFrom returns the chat and user stored by withRequester
+func requesterFrom(ctx context.Context) (requester, bool) {
+	who, ok := ctx.Value(requesterContextKey{}).(requester)
+	return who, ok
+}
+
+// usageDay is the UTC day the usage is accounted to
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// recordUsage adds the usage of one LLM request to the daily totals of the bot, the
+// chat and the user, and ranks the chat and user by cost. The bot and chat also get
+// monthly totals for the budget caps.
+func recordUsage(ctx context.Context, rdb redis.UniversalClient, model string, who requester, usage llmUsage, now time.Time) error {
+	day, month := usageDay(now), usageMonth(now)
+	cost := usage.CostMicros(model)
+
+	keys := []string{redisKey("usage:%s:total", day), redisKey("usage:%s:total", month)}
+	if who.ChatID != 0 {
+		keys = append(keys, redisKey("usage:%s:chat:%d", day, who.ChatID), redisKey("usage:%s:chat:%d", month, who.ChatID))
+	}
+	if who.UserID != 0 {
+		keys = append(keys, redisKey("usage:%s:user:%d", day, who.UserID))
+	}
+
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.HIncrBy(ctx, key, "requests", 1)
+			pipe.HIncrBy(ctx, key, "prompt_tokens", usage.PromptTokens)
+			pipe.HIncrBy(ctx, key, "thoughts_tokens", usage.ThoughtsTokens)
+			pipe.HIncrBy(ctx, key, "output_tokens", usage.OutputTokens)
+			pipe.HIncrBy(ctx, key, "cost_micros", cost)
+			pipe.Expire(ctx, key, usageRetention)
+		}
+		if who.ChatID != 0 {
+			key := redisKey("usage:%s:chats", day)
+			pipe.ZIncrBy(ctx, key, float64(cost), strconv.FormatInt(who.ChatID, 10))
+			pipe.Expire(ctx, key, usageRetention)
+		}
+		if who.UserID != 0 {
+			key := redisKey("usage:%s:users", day)
+			pipe.ZIncrBy(ctx, key, float64(cost), strconv.FormatInt(who.UserID, 10))
+			pipe.Expire(ctx, key, usageRetention)
+		}
+		return nil
+	})
+	return err
+}
+
+// accountUsage records the usage of an LLM request made for the requester in ctx
+func accountUsage(ctx context.Context, model string, metadata *genai.GenerateContentResponseUsageMetadata) {
+	rdb := activeRedis()
+	if metadata == nil || rdb == nil {
+		return
+	}
+
+	who, _ := requesterFrom(ctx)
+	usage := usageFromMetadata(metadata)
+	if err := recordUsage(context.WithoutCancel(ctx), rdb, model, who, usage, time.Now()); err != nil {
+		logJSONContext(ctx, "warn", "Failed to record LLM usage", map[string]interface{}{
+			"chat_id": who.ChatID,
+			"user_id": who.UserID,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// usageTotals are the accumulated usage of the bot, a chat or a user
+type usageTotals struct {
+	Requests       int64
+	PromptTokens   int64
+	ThoughtsTokens int64
+	OutputTokens   int64
+	CostMicros     int64
+}
+
+// add accumulates the fields of a usage hash
+func (t *usageTotals) add(fields map[string]string) {
+	for field, target := range map[string]*int64{
+		"requests":        &t.Requests,
+		"prompt_tokens":   &t.PromptTokens,
+		"thoughts_tokens": &t.ThoughtsTokens,
+		"output_tokens":   &t.OutputTokens,
+		"cost_micros":     &t.CostMicros,
+	} {
+		if n, err := strconv.ParseInt(fields[field], 10, 64); err == nil {
+			*target += n
+		}
+	}
+}
+
+// Cost returns the cost in USD
+func (t usageTotals) Cost() float64 {
+	return float64(t.CostMicros) / 1e6
+}
+
+// usageEntry is the usage of one chat or user
+type usageEntry struct {
+	ID int64
+	usageTotals
+}
+
+// usageReport is the usage over the last Days days, with the most expensive chats and users
+type usageReport struct {
+	Days  int
+	Total usageTotals
+	Chats []usageEntry
+	Users []usageEntry
+}
+
+// loadUsageReport sums the daily totals of the last days UTC days, today included
+func loadUsageReport(ctx context.Context, rdb redis.UniversalClient, now time.Time, days int, top int) (usageReport, error) {
+	report := usageReport{Days: days}
+	var dates []string
+	for i := 0; i < days; i++ {
+		dates = append(dates, usageDay(now.AddDate(0, 0, -i)))
+	}
+
+	for _, day := range dates {
+		fields, err := rdb.HGetAll(ctx, redisKey("usage:%s:total", day)).Result()
+		if err != nil {
+			return report, err
+		}
+		report.Total.add(fields)
+	}
+
+	var err error
+	if report.Chats, err = loadTopUsage(ctx, rdb, dates, "chat", top); err != nil {
+		return report, err
+	}
+	if report.Users, err = loadTopUsage(ctx, rdb, dates, "user", top); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// loadTopUsage returns the chats or users with the highest cost over the dates
+func loadTopUsage(ctx context.Context, rdb redis.UniversalClient, dates []string, kind string, top int) ([]usageEntry, error) {
+	costs := make(map[int64]float64)
+	for _, day := range dates {
+		ranked, err := rdb.ZRangeWithScores(ctx, redisKey("usage:%s:%ss", day, kind), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range ranked {
+			id, err := strconv.ParseInt(fmt.Sprint(z.Member), 10, 64)
+			if err != nil {
+				continue
+			}
+			costs[id] += z.Score
+		}
+	}
+
+	ids := make([]int64, 0, len(costs))
+	for id := range costs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if costs[ids[i]] != costs[ids[j]] {
+			return costs[ids[i]] > costs[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > top {
+		ids = ids[:top]
+	}
+
+	entries := make([]usageEntry, 0, len(ids))
+	for _, id := range ids {
+		entry := usageEntry{ID: id}
+		for _, day := range dates {
+			fields, err := rdb.HGetAll(ctx, redisKey("usage:%s:%s:%d", day, kind, id)).Result()
+			if err != nil {
+				return nil, err
+			}
+			entry.add(fields)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// handleUsageCommand reports the LLM spend of today, or of the last N days with /usage N
+func handleUsageCommand(c tele.Context) error {
+	language := userLanguage(c)
+	maxDays := int(usageRetention / (24 * time.Hour))
+	days := 1
+	if args := c.Args(); len(args) > 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(args[0]))
+		if err != nil || n < 1 || n > maxDays {
+			return c.Reply(localize(language, msgUsageHelp, maxDays))
+		}
+		days = n
+	}
+
+	rdb := activeRedis()
+	if rdb == nil {
+		return c.Reply(localize(language, msgUsageUnavailable))
+	}
+	report, err := loadUsageReport(context.Background(), rdb, time.Now(), days, usageTopEntries)
+	if err != nil {
+		logJSON("warn", "Failed to load LLM usage", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return c.Reply(localize(language, msgUsageUnavailable))
+	}
+
+	logJSON("info", "Usage report requested", map[string]interface{}{
+		"user": getUserInfo(c),
+		"days": days,
+	})
+	return c.Reply(formatUsageReport(language, report))
+}
+
+// formatUsageReport renders the /usage reply
+func formatUsageReport(language string, report usageReport) string {
+	var sb strings.Builder
+	sb.WriteString(localizeCount(language, msgUsagePeriod, report.Days))
+	if report.Total.Requests == 0 {
+		sb.WriteString("\n\n" + localize(language, msgUsageEmpty))
+		return sb.String()
+	}
+
+	total := report.Total
+	sb.WriteString("\n\n" + localize(language, msgUsageTotals,
+		total.Requests, total.PromptTokens, total.ThoughtsTokens, total.OutputTokens, total.Cost()))
+
+	for _, section := range []struct {
+		title   messageKey
+		entries []usageEntry
+	}{
+		{msgUsageTopChats, report.Chats},
+		{msgUsageTopUsers, report.Users},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		sb.WriteString("\n\n" + localize(language, section.title))
+		for _, entry := range section.entries {
+			sb.WriteString("\n" + localizeCount(language, msgUsageEntry, int(entry.Requests), entry.ID, entry.Cost()))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+func TestParseModelPrices(t *testing.T) {
+	prices, err := parseModelPrices("")
+	if err != nil {
+		t.Fatalf("parseModelPrices(\"\") error: %v", err)
+	}
+	if prices["gemini-flash-latest"] != defaultModelPrices["gemini-flash-latest"] {
+		t.Errorf("default price = %v, want %v", prices["gemini-flash-latest"], defaultModelPrices["gemini-flash-latest"])
+	}
+
+	prices, err = parseModelPrices("gemini-flash-latest=0.5:3, gemini-pro = 1.25 : 10 ,")
+	if err != nil {
+		t.Fatalf("parseModelPrices() error: %v", err)
+	}
+	if got := prices["gemini-flash-latest"]; got != (modelPrice{Input: 0.5, Output: 3}) {
+		t.Errorf("overridden price = %v", got)
+	}
+	if got := prices["gemini-pro"]; got != (modelPrice{Input: 1.25, Output: 10}) {
+		t.Errorf("added price = %v", got)
+	}
+	if defaultModelPrices["gemini-flash-latest"].Input != 0.30 {
+		t.Error("parseModelPrices() modified the defaults")
+	}
+
+	for _, invalid := range []string{"gemini", "gemini=1", "=1:2", "gemini=a:2", "gemini=1:-2"} {
+		if _, err := parseModelPrices(invalid); err == nil {
+			t.Errorf("parseModelPrices(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestUsageCost(t *testing.T) {
+	original := modelPrices
+	modelPrices = map[string]modelPrice{"test-model": {Input: 0.30, Output: 2.50}}
+	t.Cleanup(func() { modelPrices = original })
+
+	usage := usageFromMetadata(&genai.GenerateContentResponseUsageMetadata{
+		PromptTokenCount:        1000,
+		ToolUsePromptTokenCount: 200,
+		ThoughtsTokenCount:      300,
+		CandidatesTokenCount:    100,
+	})
+	if usage != (llmUsage{PromptTokens: 1200, ThoughtsTokens: 300, OutputTokens: 100}) {
+		t.Fatalf("usageFromMetadata() = %+v", usage)
+	}
+	// 1200 * 0.30 + 400 * 2.50 micro-USD
+	if got := usage.CostMicros("test-model"); got != 1360 {
+		t.Errorf("CostMicros() = %d, want 1360", got)
+	}
+	if got := usage.CostMicros("unknown-model"); got != 0 {
+		t.Errorf("CostMicros() of an unpriced model = %d, want 0", got)
+	}
+}
+
+func TestRecordUsageReport(t *testing.T) {
+	mr := useMiniredis(t)
+	original := modelPrices
+	modelPrices = map[string]modelPrice{"test-model": {Input: 1, Output: 10}}
+	t.Cleanup(func() { modelPrices = original })
+
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	usage := llmUsage{PromptTokens: 100, ThoughtsTokens: 20, OutputTokens: 30} // 600 micro-USD
+
+	for _, call := range []struct {
+		who requester
+		at  time.Time
+	}{
+		{requester{ChatID: -100, UserID: 1}, now},
+		{requester{ChatID: -100, UserID: 2}, now},
+		{requester{ChatID: -200, UserID: 1}, yesterday},
+		{requester{}, now},
+	} {
+		if err := recordUsage(ctx, redisClient, "test-model", call.who, usage, call.at); err != nil {
+			t.Fatalf("recordUsage() error: %v", err)
+		}
+	}
+
+	if ttl := mr.TTL("usage:2026-03-10:chat:-100"); ttl != usageRetention {
+		t.Errorf("usage TTL = %v, want %v", ttl, usageRetention)
+	}
+
+	report, err := loadUsageReport(ctx, redisClient, now, 1, 5)
+	if err != nil {
+		t.Fatalf("loadUsageReport() error: %v", err)
+	}
+	if report.Total.Requests != 3 || report.Total.PromptTokens != 300 || report.Total.CostMicros != 1800 {
+		t.Errorf("today's total = %+v", report.Total)
+	}
+	if len(report.Chats) != 1 || report.Chats[0].ID != -100 || report.Chats[0].Requests != 2 {
+		t.Errorf("today's chats = %+v", report.Chats)
+	}
+
+	report, err = loadUsageReport(ctx, redisClient, now, 2, 1)
+	if err != nil {
+		t.Fatalf("loadUsageReport() error: %v", err)
+	}
+	if report.Total.Requests != 4 {
+		t.Errorf("two-day requests = %d, want 4", report.Total.Requests)
+	}
+	if len(report.Users) != 1 || report.Users[0].ID != 1 || report.Users[0].Requests != 2 || report.Users[0].CostMicros != 1200 {
+		t.Errorf("top user over two days = %+v, want user 1 with 2 requests", report.Users)
+	}
+}
+
+func TestRunLLMAccountsUsageToRequester(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	chunk := textChunk("Looks fine")
+	chunk.UsageMetadata = &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 1000, CandidatesTokenCount: 100}
+	useFakeLLMProvider(t, &fakeLLMProvider{chunks: []*genai.GenerateContentResponse{chunk}})
+
+	reply := ""
+	ctx := withRequester(context.Background(), newAnalysisMockContext(nil, &reply))
+	if _, err := runLLM(ctx, llmRequest{PromptType: "factcheck"}); err != nil {
+		t.Fatalf("runLLM() error: %v", err)
+	}
+
+	report, err := loadUsageReport(context.Background(), redisClient, time.Now(), 1, 5)
+	if err != nil {
+		t.Fatalf("loadUsageReport() error: %v", err)
+	}
+	if report.Total.Requests != 1 {
+		t.Errorf("total requests = %d, want 1", report.Total.Requests)
+	}
+	if len(report.Chats) != 1 || report.Chats[0].ID != -1001234567890 || report.Chats[0].PromptTokens != 1000 {
+		t.Errorf("chats = %+v, want the command chat", report.Chats)
+	}
+	if len(report.Users) != 1 || report.Users[0].ID != 123456789 || report.Users[0].OutputTokens != 100 {
+		t.Errorf("users = %+v, want the command sender", report.Users)
+	}
+}
+
+func TestHandleUsageCommand(t *testing.T) {
+	useMiniredis(t)
+	silenceStdout(t)
+	reply := ""
+	handleUsageCommand(newAdminMockContext(111, []string{"0"}, &reply))
+	if !strings.Contains(reply, "Usage: /usage") {
+		t.Errorf("invalid days reply = %q", reply)
+	}
+
+	handleUsageCommand(newAdminMockContext(111, nil, &reply))
+	if !strings.Contains(reply, "No LLM requests recorded") {
+		t.Errorf("empty report = %q", reply)
+	}
+
+	recordUsage(context.Background(), redisClient, llmModel, requester{ChatID: -100, UserID: 42}, llmUsage{PromptTokens: 1000000}, time.Now())
+	handleUsageCommand(newAdminMockContext(111, []string{"7"}, &reply))
+	for _, want := range []string{"last 7 days", "Requests: 1", "Cost: $0.3000", "Top chats:", "• -100: $0.3000, 1 request", "• 42:"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("report %q does not contain %q", reply, want)
+		}
+	}
+}
+
+func TestHandleUsageCommandWithoutRedis(t *testing.T) {
+	silenceStdout(t)
+	original := redisClient
+	redisClient = nil
+	t.Cleanup(func() { redisClient = original })
+
+	reply := ""
+	handleUsageCommand(newAdminMockContext(111, nil, &reply))
+	if !strings.Contains(reply, "unavailable") {
+		t.Errorf("reply without Redis = %q", reply)
+	}
+}
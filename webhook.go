@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	webhookSecretHeader    = "X-Telegram-Bot-Api-Secret-Token"
+	webhookMaxBodyBytes    = 1 << 20
+	webhookShutdownTimeout = 10 * time.Second
+)
+
+// webhookSecretRegex is the token format accepted by setWebhook
+var webhookSecretRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookConfig configures webhook mode; without a public URL the bot uses long polling
+type webhookConfig struct {
+	PublicURL   string // HTTPS URL registered with setWebhook
+	Listen      string // local address of the HTTP server
+	SecretToken string // expected in the X-Telegram-Bot-Api-Secret-Token header
+	CertFile    string // optional TLS certificate of the local server
+	KeyFile     string // optional TLS key of the local server
+	UploadCert  bool   // send the certificate to Telegram, needed for self-signed certificates
+}
+
+// Enabled checks if the bot should receive updates via webhook
+func (w webhookConfig) Enabled() bool {
+	return w.PublicURL != ""
+}
+
+// loadWebhookConfig reads the WEBHOOK_* environment variables
+func loadWebhookConfig() (webhookConfig, error) {
+	config := webhookConfig{
+		PublicURL:   os.Getenv("WEBHOOK_URL"),
+		Listen:      os.Getenv("WEBHOOK_LISTEN"),
+		SecretToken: os.Getenv("WEBHOOK_SECRET_TOKEN"),
+		CertFile:    os.Getenv("WEBHOOK_TLS_CERT"),
+		KeyFile:     os.Getenv("WEBHOOK_TLS_KEY"),
+	}
+	if config.Listen == "" {
+		config.Listen = ":8443"
+	}
+
+	uploadCert, err := parseBoolEnv("WEBHOOK_UPLOAD_CERT", false)
+	if err != nil {
+		return config, err
+	}
+	config.UploadCert = uploadCert
+
+	if !config.Enabled() {
+		return config, nil
+	}
+	return config, config.validate()
+}
+
+// validate checks the settings Telegram would reject or the server could not use
+func (w webhookConfig) validate() error {
+	u, err := url.Parse(w.PublicURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid WEBHOOK_URL %q, expected an https:// URL", w.PublicURL)
+	}
+	if w.SecretToken != "" && !webhookSecretRegex.MatchString(w.SecretToken) {
+		return errors.New("invalid WEBHOOK_SECRET_TOKEN, expected 1-256 characters A-Z, a-z, 0-9, _ or -")
+	}
+	if (w.CertFile == "") != (w.KeyFile == "") {
+		return errors.New("WEBHOOK_TLS_CERT and WEBHOOK_TLS_KEY must be set together")
+	}
+	if w.UploadCert && w.CertFile == "" {
+		return errors.New("WEBHOOK_UPLOAD_CERT requires WEBHOOK_TLS_CERT")
+	}
+	return nil
+}
+
+// Path is the URL path Telegram posts updates to
+func (w webhookConfig) Path() string {
+	u, err := url.Parse(w.PublicURL)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// webhookPoller receives updates from Telegram over HTTP(S) instead of long polling
+type webhookPoller struct {
+	config   webhookConfig
+	listener net.Listener
+}
+
+// webhookAPI is the part of the Telegram client used to (de)register the webhook
+type webhookAPI interface {
+	SetWebhook(w *tele.Webhook) error
+	RemoveWebhook(dropPending ...bool) error
+}
+
+// newWebhookAPI returns a separate client for (de)registering the webhook. Every API
+// call of a telebot Bot reads state that Bot.Start writes, so calls on the polling bot
+// made before Start would race with it.
+func newWebhookAPI(bot *tele.Bot) (webhookAPI, error) {
+	return tele.NewBot(tele.Settings{Token: bot.Token, URL: bot.URL, Offline: true})
+}
+
+// startWebhook opens the listener and registers the webhook with Telegram, so
+// configuration errors are reported before the bot starts
+func startWebhook(api webhookAPI, config webhookConfig) (*webhookPoller, error) {
+	if config.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", config.Listen, err)
+	}
+
+	endpoint := &tele.WebhookEndpoint{PublicURL: config.PublicURL}
+	if config.UploadCert {
+		endpoint.Cert = config.CertFile
+	}
+	if err := api.SetWebhook(&tele.Webhook{SecretToken: config.SecretToken, Endpoint: endpoint}); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set webhook: %w", err)
+	}
+
+	return &webhookPoller{config: config, listener: listener}, nil
+}
+
+// Poll serves webhook requests until the bot stops
+func (p *webhookPoller) Poll(b *tele.Bot, updates chan tele.Update, stop chan struct{}) {
+	done := make(chan struct{})
+	server := &http.Server{
+		Handler:           webhookHandler(p.config.Path(), p.config.SecretToken, updates, done),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		if p.config.CertFile != "" {
+			served <- server.ServeTLS(p.listener, p.config.CertFile, p.config.KeyFile)
+		} else {
+			served <- server.Serve(p.listener)
+		}
+	}()
+
+	logJSON("info", "Webhook server started", map[string]interface{}{
+		"listen": p.listener.Addr().String(),
+		"path":   p.config.Path(),
+		"tls":    p.config.CertFile != "",
+	})
+
+	select {
+	case <-stop:
+	case err := <-served:
+		logJSON("error", "Webhook server stopped", map[string]interface{}{
+			"error": err.Error(),
+		})
+		<-stop
+	}
+
+	close(done)
+	ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logJSON("warn", "Failed to shut down webhook server", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// stopWebhook deletes the webhook once the bot has stopped; telebot cancels API
+// requests while stopping, so this cannot run inside Poll
+func stopWebhook(api webhookAPI) {
+	if err := api.RemoveWebhook(); err != nil {
+		logJSON("warn", "Failed to delete webhook", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	logJSON("info", "Webhook deleted", nil)
+}
+
+// webhookHandler validates posted updates and passes them to the bot; done stops
+// accepting updates once the bot no longer reads them
+func webhookHandler(path string, secretToken string, updates chan<- tele.Update, done <-chan struct{}) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get(webhookSecretHeader)
+		if secretToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secretToken)) != 1 {
+			logJSON("warn", "Webhook request with invalid secret token", map[string]interface{}{
+				"remote_addr": r.RemoteAddr,
+			})
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update tele.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, webhookMaxBodyBytes)).Decode(&update); err != nil {
+			logJSON("warn", "Failed to decode webhook update", map[string]interface{}{
+				"error": err.Error(),
+			})
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-done:
+			// Telegram retries updates that were not acknowledged
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	})
+	return mux
+}
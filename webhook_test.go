@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeWebhookAPI records webhook registration calls
+type fakeWebhookAPI struct {
+	mu  sync.Mutex
+	set *tele.Webhook
+	err error
+}
+
+func (f *fakeWebhookAPI) SetWebhook(w *tele.Webhook) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set = w
+	return f.err
+}
+
+func (f *fakeWebhookAPI) RemoveWebhook(dropPending ...bool) error {
+	return nil
+}
+
+// postUpdate posts a recorded update to the webhook URL
+func postUpdate(t *testing.T, handler http.Handler, url string, token string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set(webhookSecretHeader, token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestLoadWebhookConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expectErr bool
+		enabled   bool
+	}{
+		{"Disabled", nil, false, false},
+		{"Valid", map[string]string{"WEBHOOK_URL": "https://bot.example.com/telegram", "WEBHOOK_SECRET_TOKEN": "s3cret_token-1"}, false, true},
+		{"Plain HTTP", map[string]string{"WEBHOOK_URL": "http://bot.example.com/telegram"}, true, true},
+		{"Invalid secret", map[string]string{"WEBHOOK_URL": "https://bot.example.com", "WEBHOOK_SECRET_TOKEN": "not allowed!"}, true, true},
+		{"Certificate without key", map[string]string{"WEBHOOK_URL": "https://bot.example.com", "WEBHOOK_TLS_CERT": "cert.pem"}, true, true},
+		{"Upload without certificate", map[string]string{"WEBHOOK_URL": "https://bot.example.com", "WEBHOOK_UPLOAD_CERT": "true"}, true, true},
+		{"Invalid upload flag", map[string]string{"WEBHOOK_UPLOAD_CERT": "maybe"}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"WEBHOOK_URL", "WEBHOOK_LISTEN", "WEBHOOK_SECRET_TOKEN", "WEBHOOK_TLS_CERT", "WEBHOOK_TLS_KEY", "WEBHOOK_UPLOAD_CERT"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			config, err := loadWebhookConfig()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("loadWebhookConfig() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if config.Enabled() != tt.enabled {
+				t.Errorf("Enabled() = %v, want %v", config.Enabled(), tt.enabled)
+			}
+			if config.Listen != ":8443" {
+				t.Errorf("Listen = %q, want the default", config.Listen)
+			}
+		})
+	}
+}
+
+func TestWebhookConfigPath(t *testing.T) {
+	if path := (webhookConfig{PublicURL: "https://bot.example.com/telegram/hook"}).Path(); path != "/telegram/hook" {
+		t.Errorf("Path() = %q", path)
+	}
+	if path := (webhookConfig{PublicURL: "https://bot.example.com"}).Path(); path != "/" {
+		t.Errorf("Path() = %q, want /", path)
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	silenceStdout(t)
+	body := readFixture(t, "update_message.json")
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		body           []byte
+		expectedStatus int
+		expectUpdate   bool
+	}{
+		{"Recorded update", http.MethodPost, "/telegram", "secret", body, http.StatusOK, true},
+		{"Missing token", http.MethodPost, "/telegram", "", body, http.StatusUnauthorized, false},
+		{"Wrong token", http.MethodPost, "/telegram", "guess", body, http.StatusUnauthorized, false},
+		{"Wrong method", http.MethodGet, "/telegram", "secret", nil, http.StatusMethodNotAllowed, false},
+		{"Wrong path", http.MethodPost, "/other", "secret", body, http.StatusNotFound, false},
+		{"Malformed update", http.MethodPost, "/telegram", "secret", []byte(`{"update_id":`), http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := make(chan tele.Update, 1)
+			handler := webhookHandler("/telegram", "secret", updates, make(chan struct{}))
+
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set(webhookSecretHeader, tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.expectedStatus)
+			}
+			select {
+			case update := <-updates:
+				if !tt.expectUpdate {
+					t.Fatalf("unexpected update %d", update.ID)
+				}
+				if update.ID != 815204611 || update.Message == nil || update.Message.Text != "https://example.com/article looks interesting" {
+					t.Errorf("update = %+v, want the recorded message", update)
+				}
+				if update.Message.Sender.LanguageCode != "en" || update.Message.Chat.ID != -1001234567890 {
+					t.Errorf("message = %+v, want sender and chat from the recording", update.Message)
+				}
+			default:
+				if tt.expectUpdate {
+					t.Error("expected an update, got none")
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookHandlerWithoutSecret(t *testing.T) {
+	updates := make(chan tele.Update, 1)
+	handler := webhookHandler("/", "", updates, make(chan struct{}))
+
+	if recorder := postUpdate(t, handler, "/", "", readFixture(t, "update_message.json")); recorder.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", recorder.Code)
+	}
+	if len(updates) != 1 {
+		t.Error("expected the update to be delivered")
+	}
+}
+
+func TestWebhookHandlerShuttingDown(t *testing.T) {
+	silenceStdout(t)
+	done := make(chan struct{})
+	close(done)
+	handler := webhookHandler("/", "secret", make(chan tele.Update), done)
+
+	if recorder := postUpdate(t, handler, "/", "secret", readFixture(t, "update_message.json")); recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 so Telegram retries", recorder.Code)
+	}
+}
+
+func TestStartWebhookSetWebhookError(t *testing.T) {
+	api := &fakeWebhookAPI{err: errors.New("bad request: bad webhook")}
+	config := webhookConfig{PublicURL: "https://bot.example.com/hook", Listen: "127.0.0.1:0"}
+
+	if _, err := startWebhook(api, config); err == nil {
+		t.Fatal("startWebhook() expected error, got nil")
+	}
+}
+
+func TestStartWebhookInvalidCertificate(t *testing.T) {
+	api := &fakeWebhookAPI{}
+	config := webhookConfig{PublicURL: "https://bot.example.com/hook", Listen: "127.0.0.1:0", CertFile: "testdata/missing.pem", KeyFile: "testdata/missing.key"}
+
+	if _, err := startWebhook(api, config); err == nil {
+		t.Fatal("startWebhook() expected error, got nil")
+	}
+	if api.set != nil {
+		t.Error("webhook should not be registered with an unusable certificate")
+	}
+}
+
+func TestWebhookPollerDeliversUpdatesToHandlers(t *testing.T) {
+	silenceStdout(t)
+
+	// Fake Bot API: every method succeeds and is recorded
+	var mu sync.Mutex
+	var methods []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.URL.Path[len("/bottest-token/"):])
+		mu.Unlock()
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	}))
+	defer api.Close()
+
+	bot, err := tele.NewBot(tele.Settings{Token: "test-token", URL: api.URL, Offline: true})
+	if err != nil {
+		t.Fatalf("NewBot() error: %v", err)
+	}
+
+	webhookAPI, err := newWebhookAPI(bot)
+	if err != nil {
+		t.Fatalf("newWebhookAPI() error: %v", err)
+	}
+
+	config := webhookConfig{PublicURL: "https://bot.example.com/telegram", Listen: "127.0.0.1:0", SecretToken: "secret"}
+	poller, err := startWebhook(webhookAPI, config)
+	if err != nil {
+		t.Fatalf("startWebhook() error: %v", err)
+	}
+	bot.Poller = poller
+
+	received := make(chan string, 1)
+	bot.Handle(tele.OnText, func(c tele.Context) error {
+		received <- c.Text()
+		return nil
+	})
+	stopped := make(chan struct{})
+	go func() {
+		bot.Start()
+		close(stopped)
+	}()
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+poller.listener.Addr().String()+"/telegram", bytes.NewReader(readFixture(t, "update_message.json")))
+	req.Header.Set(webhookSecretHeader, "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+
+	select {
+	case text := <-received:
+		if text != "https://example.com/article looks interesting" {
+			t.Errorf("handler got %q", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("update was not delivered to the handler")
+	}
+
+	bot.Stop()
+	<-stopped
+	stopWebhook(webhookAPI)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 2 || methods[0] != "setWebhook" || methods[1] != "deleteWebhook" {
+		t.Errorf("Bot API calls = %v, want setWebhook then deleteWebhook", methods)
+	}
+}